@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/globalaccelerator"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// createAccelerator puts a Global Accelerator in front of loadBalancer, giving
+// the Traefik ingress two static anycast IPs and routing clients over the AWS
+// backbone from the nearest edge location. It returns those addresses.
+func createAccelerator(
+	ctx *pulumi.Context,
+	loadBalancer *elb.LoadBalancer,
+	region string,
+	ports []int,
+) (pulumi.StringArrayOutput, error) {
+	accelerator, err := globalaccelerator.NewAccelerator(ctx, "accelerator", &globalaccelerator.AcceleratorArgs{
+		Name:          pulumi.String(fmt.Sprintf("%s-%s", ctx.Project(), ctx.Stack())),
+		IpAddressType: pulumi.String("IPV4"),
+		Enabled:       pulumi.Bool(true),
+	})
+	if err != nil {
+		return pulumi.StringArrayOutput{}, err
+	}
+
+	var portRanges globalaccelerator.ListenerPortRangeArray
+	for _, port := range ports {
+		portRanges = append(portRanges, globalaccelerator.ListenerPortRangeArgs{
+			FromPort: pulumi.Int(port),
+			ToPort:   pulumi.Int(port),
+		})
+	}
+
+	listener, err := globalaccelerator.NewListener(ctx, "accelerator-listener", &globalaccelerator.ListenerArgs{
+		AcceleratorArn: accelerator.ID(),
+		Protocol:       pulumi.String("TCP"),
+		PortRanges:     portRanges,
+	})
+	if err != nil {
+		return pulumi.StringArrayOutput{}, err
+	}
+
+	_, err = globalaccelerator.NewEndpointGroup(ctx, "accelerator-endpoints", &globalaccelerator.EndpointGroupArgs{
+		ListenerArn:         listener.ID(),
+		EndpointGroupRegion: pulumi.String(region),
+		EndpointConfigurations: globalaccelerator.EndpointGroupEndpointConfigurationArray{
+			globalaccelerator.EndpointGroupEndpointConfigurationArgs{
+				EndpointId:                  loadBalancer.Arn,
+				ClientIpPreservationEnabled: pulumi.Bool(true),
+				Weight:                      pulumi.Int(100),
+			},
+		},
+	})
+	if err != nil {
+		return pulumi.StringArrayOutput{}, err
+	}
+
+	ips := accelerator.IpSets.ApplyT(func(sets []globalaccelerator.AcceleratorIpSet) []string {
+		var ips []string
+		for _, set := range sets {
+			ips = append(ips, set.IpAddresses...)
+		}
+		return ips
+	}).(pulumi.StringArrayOutput)
+
+	return ips, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Traefik keeps the ACME account and certificates in acme.json on the
+// Traefik storage, so they survive task replacements instead of being
+// requested again from Let's Encrypt, which rate limits duplicate
+// certificates.
+const (
+	acmeVolume       = "acme"
+	acmeMountPath    = "/acme"
+	acmeResolverName = "letsencrypt"
+)
+
+// acmeRole is the part a Traefik task plays in obtaining certificates.
+type acmeRole int
+
+const (
+	// acmeResolver obtains the certificates it serves itself.
+	acmeResolver acmeRole = iota
+	// acmeIssuer obtains the certificates for the readers, but serves no
+	// traffic.
+	acmeIssuer
+	// acmeReader serves the certificates the issuer obtained.
+	acmeReader
+)
+
+// sharedACME reports whether several Traefik tasks serve the certificates.
+// Traefik doesn't coordinate access to acme.json, so they would all request
+// and renew the same certificates and overwrite each other's acme.json.
+// Instead a single issuer obtains them, and the other tasks read them.
+func (c *stackConfig) sharedACME() bool {
+	return c.ACME != nil && (c.Traefik.DesiredCount > 1 || c.TraefikCanary != nil)
+}
+
+// servingACMERole is the role of the Traefik tasks serving traffic, the
+// stable and the canary ones.
+func (c *stackConfig) servingACMERole() acmeRole {
+	if c.sharedACME() {
+		return acmeReader
+	}
+	return acmeResolver
+}
+
+// certsDumperImage converts acme.json into certificate files.
+const certsDumperImage = "ldez/traefik-certs-dumper:v2.8.3"
+
+// certsDumperScript polls acme.json, since NFS doesn't notify other clients
+// of changes, and dumps its certificates into the certs directory next to a
+// dynamic configuration file listing them, which the readers watch.
+const certsDumperScript = `while true; do
+  if [ -s %[1]s/acme.json ] && ! cmp -s %[1]s/acme.json /tmp/acme.json; then
+    cp %[1]s/acme.json /tmp/acme.json &&
+    traefik-certs-dumper file --version v2 --source /tmp/acme.json --dest /tmp/certs --domain-subdir &&
+    mkdir -p %[2]s/certs && cp -r /tmp/certs/. %[2]s/certs/ &&
+    {
+      echo 'tls:'
+      echo '  certificates:'
+      for d in /tmp/certs/*/; do
+        n=$(basename "$d")
+        [ -f "$d/certificate.crt" ] || continue
+        echo "    - certFile: %[2]s/certs/$n/certificate.crt"
+        echo "      keyFile: %[2]s/certs/$n/privatekey.key"
+      done
+    } > %[2]s/certs.tmp && mv %[2]s/certs.tmp %[2]s/certs.yml
+  fi
+  sleep 30
+done`
+
+// certsDumperContainer is the sidecar of the reader tasks that turns the
+// issuer's acme.json into certificates Traefik loads.
+func certsDumperContainer(mountPoints []mountPoint) containerDefinition {
+	return containerDefinition{
+		Name:        "certs-dumper",
+		Image:       certsDumperImage,
+		Essential:   true,
+		EntryPoint:  []string{"sh", "-c", fmt.Sprintf(certsDumperScript, acmeMountPath, dynamicConfigPath)},
+		MountPoints: mountPoints,
+	}
+}
+
+// createACMEIssuer runs the issuer, a single Traefik task that obtains the
+// certificates of every router on the websecure entrypoint. It is never
+// registered with a load balancer, and ECS stops the old task before it
+// starts a new one, so there is never more than one.
+func createACMEIssuer(
+	ctx *pulumi.Context,
+	subnet *ec2.GetSubnetIdsResult,
+	traefikSg *ec2.SecurityGroup,
+	cluster *ecs.Cluster,
+	containerDef pulumi.StringOutput,
+	volumes ecs.TaskDefinitionVolumeArray,
+	ecsRole *iam.Role,
+	traefikRole *iam.Role,
+	conf *stackConfig,
+) (*ecs.TaskDefinition, *ecs.Service, error) {
+	containerDefs, err := conf.taskContainerDefinitions(ctx, "traefik-acme", containerDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	task, err := ecs.NewTaskDefinition(ctx, "traefik-acme-task", &ecs.TaskDefinitionArgs{
+		Family:                  pulumi.String("traefik-acme"),
+		ContainerDefinitions:    containerDefs,
+		Cpu:                     conf.taskCPU("traefik-acme"),
+		Memory:                  conf.taskMemory("traefik-acme"),
+		NetworkMode:             pulumi.String("awsvpc"),
+		RequiresCompatibilities: conf.compatibilities(),
+		RuntimePlatform:         conf.traefikPlatform().args(),
+		EphemeralStorage:        conf.ephemeralStorage("traefik-acme"),
+		ExecutionRoleArn:        ecsRole.Arn,
+		TaskRoleArn:             traefikRole.Arn,
+		Tags:                    conf.resourceTags(),
+		Volumes:                 volumes,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service, err := ecs.NewService(ctx, "traefik-acme-service", &ecs.ServiceArgs{
+		Name: pulumi.String("traefik-acme"),
+
+		Cluster:        cluster.Arn,
+		TaskDefinition: task.Arn,
+
+		DesiredCount:                    pulumi.Int(1),
+		DeploymentMinimumHealthyPercent: pulumi.Int(0),
+		DeploymentMaximumPercent:        pulumi.Int(100),
+		LaunchType:                      conf.launchType(),
+		PlatformVersion:                 conf.platformVersion(),
+		CapacityProviderStrategies:      conf.capacityProviderStrategies(),
+		DeploymentCircuitBreaker:        conf.circuitBreaker(),
+		ForceNewDeployment:              conf.forceNewDeployment(),
+		EnableExecuteCommand:            conf.enableExecuteCommand("traefik-acme"),
+		PlacementConstraints:            conf.placementConstraints("traefik-acme"),
+		OrderedPlacementStrategies:      conf.placementStrategies("traefik-acme"),
+		PropagateTags:                   conf.propagateTags(),
+		EnableEcsManagedTags:            pulumi.Bool(true),
+		Tags:                            conf.resourceTags(),
+
+		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
+			AssignPublicIp: conf.assignPublicIP(),
+			Subnets:        conf.taskSubnets(subnet),
+			SecurityGroups: pulumi.StringArray{traefikSg.ID().ToStringOutput()},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return task, service, nil
+}
+
+// createACMEPolicy lets the Traefik task role answer DNS-01 challenges in the
+// hosted zone.
+func createACMEPolicy(ctx *pulumi.Context, traefikRole *iam.Role, acme *acmeConfig) error {
+	policy, err := NewPolicyDocument(
+		Allow([]string{"route53:GetChange"}, "arn:aws:route53:::change/*"),
+		Allow([]string{"route53:ListHostedZonesByName"}, "*"),
+		Allow([]string{
+			"route53:ListResourceRecordSets",
+			"route53:ChangeResourceRecordSets",
+		}, "arn:aws:route53:::hostedzone/"+acme.HostedZoneID),
+	).JSON()
+	if err != nil {
+		return err
+	}
+
+	_, err = iam.NewRolePolicy(ctx, "traefik-acme-policy", &iam.RolePolicyArgs{
+		Role:   traefikRole.ID(),
+		Policy: pulumi.String(policy),
+	})
+	return err
+}
+
+// createTLSListener passes TLS on port 443 of the network load balancer
+// through to the websecure entrypoint, since Traefik terminates it itself.
+func createTLSListener(
+	ctx *pulumi.Context,
+	networkLb *elb.LoadBalancer,
+	vpc *ec2.LookupVpcResult,
+	conf *stackConfig,
+) (*elb.TargetGroup, error) {
+	tlsTg, err := elb.NewTargetGroup(ctx, "traefik-tls-tg", &elb.TargetGroupArgs{
+		Name:                conf.physicalName("traefik-tls"),
+		DeregistrationDelay: pulumi.Int(conf.DeregistrationDelay),
+		Port:                pulumi.Int(443),
+		Protocol:            pulumi.String("TCP"),
+		TargetType:          pulumi.String("ip"),
+		VpcId:               pulumi.String(vpc.Id),
+		HealthCheck:         networkHealthCheck(conf),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elb.NewListener(ctx, "traefik-tls-listener", &elb.ListenerArgs{
+		LoadBalancerArn: networkLb.Arn,
+		Port:            pulumi.Int(443),
+		Protocol:        pulumi.String("TCP"),
+		DefaultActions: elb.ListenerDefaultActionArray{
+			elb.ListenerDefaultActionArgs{
+				Type:           pulumi.String("forward"),
+				TargetGroupArn: tlsTg.Arn,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tlsTg, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// alarmPeriod is the period, in seconds, of the metrics the alarms evaluate.
+const alarmPeriod = 60
+
+// validateAlarms checks the thresholds of the alarms and fills in their
+// defaults.
+func validateAlarms(conf *stackConfig) error {
+	a := conf.Alarms
+	if a.ErrorRate == 0 {
+		a.ErrorRate = 5
+	}
+	if a.UnhealthyHosts == 0 {
+		a.UnhealthyHosts = 1
+	}
+	if a.CPU == 0 {
+		a.CPU = 80
+	}
+	if a.Memory == 0 {
+		a.Memory = 80
+	}
+	if a.EvaluationPeriods == 0 {
+		a.EvaluationPeriods = 5
+	}
+	for key, percent := range map[string]float64{"errorRate": a.ErrorRate, "cpu": a.CPU, "memory": a.Memory} {
+		if percent <= 0 || percent > 100 {
+			return fmt.Errorf("alarms.%s must be a percentage above 0, got %g", key, percent)
+		}
+	}
+	if a.UnhealthyHosts < 1 {
+		return fmt.Errorf("alarms.unhealthyHosts must be at least 1, got %d", a.UnhealthyHosts)
+	}
+	if a.MissingTasks < 0 {
+		return fmt.Errorf("alarms.missingTasks can't be negative, got %d", a.MissingTasks)
+	}
+	if a.EvaluationPeriods < 1 || a.EvaluationPeriods > 60 {
+		return fmt.Errorf("alarms.evaluationPeriods must be between 1 and 60 minutes, got %d", a.EvaluationPeriods)
+	}
+	return nil
+}
+
+// metricQuery is the query named id of the metric math of an alarm, for
+// metric of namespace.
+func metricQuery(id, namespace, metric, stat string, dimensions pulumi.StringMap) cloudwatch.MetricAlarmMetricQueryArgs {
+	return cloudwatch.MetricAlarmMetricQueryArgs{
+		Id: pulumi.String(id),
+		Metric: cloudwatch.MetricAlarmMetricQueryMetricArgs{
+			Namespace:  pulumi.String(namespace),
+			MetricName: pulumi.String(metric),
+			Stat:       pulumi.String(stat),
+			Period:     pulumi.Int(alarmPeriod),
+			Dimensions: dimensions,
+		},
+	}
+}
+
+// createAlarms creates the alarms of the 5xx rate of loadBalancer, the
+// unhealthy targets of targetGroups, and the CPU, memory and missing tasks
+// of services.
+func createAlarms(
+	ctx *pulumi.Context,
+	loadBalancer *elb.LoadBalancer,
+	targetGroups []lbTargetGroup,
+	cluster *ecs.Cluster,
+	services map[string]*ecs.Service,
+	conf *stackConfig,
+) error {
+	a := conf.Alarms
+	lb := pulumi.StringMap{"LoadBalancer": loadBalancer.ArnSuffix}
+	_, err := cloudwatch.NewMetricAlarm(ctx, "alb-5xx-rate", &cloudwatch.MetricAlarmArgs{
+		AlarmDescription:   pulumi.Sprintf("More than %g%% of the requests to the load balancer fail with 5xx responses", a.ErrorRate),
+		ComparisonOperator: pulumi.String("GreaterThanThreshold"),
+		EvaluationPeriods:  pulumi.Int(a.EvaluationPeriods),
+		Threshold:          pulumi.Float64(a.ErrorRate),
+		TreatMissingData:   pulumi.String("notBreaching"),
+		AlarmActions:       conf.alarmActions(),
+		OkActions:          conf.alarmActions(),
+		MetricQueries: cloudwatch.MetricAlarmMetricQueryArray{
+			metricQuery("elb", "AWS/ApplicationELB", "HTTPCode_ELB_5XX_Count", "Sum", lb),
+			metricQuery("target", "AWS/ApplicationELB", "HTTPCode_Target_5XX_Count", "Sum", lb),
+			metricQuery("requests", "AWS/ApplicationELB", "RequestCount", "Sum", lb),
+			cloudwatch.MetricAlarmMetricQueryArgs{
+				Id:         pulumi.String("rate"),
+				Expression: pulumi.String("IF(requests > 0, 100 * (FILL(elb, 0) + FILL(target, 0)) / requests, 0)"),
+				Label:      pulumi.String("5xx rate (%)"),
+				ReturnData: pulumi.Bool(true),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tg := range targetGroups {
+		_, err = cloudwatch.NewMetricAlarm(ctx, tg.name+"-unhealthy-hosts", &cloudwatch.MetricAlarmArgs{
+			AlarmDescription:   pulumi.Sprintf("The %s target group has unhealthy targets", tg.name),
+			Namespace:          pulumi.String("AWS/ApplicationELB"),
+			MetricName:         pulumi.String("UnHealthyHostCount"),
+			Statistic:          pulumi.String("Maximum"),
+			Period:             pulumi.Int(alarmPeriod),
+			ComparisonOperator: pulumi.String("GreaterThanOrEqualToThreshold"),
+			EvaluationPeriods:  pulumi.Int(a.EvaluationPeriods),
+			Threshold:          pulumi.Float64(float64(a.UnhealthyHosts)),
+			TreatMissingData:   pulumi.String("notBreaching"),
+			AlarmActions:       conf.alarmActions(),
+			OkActions:          conf.alarmActions(),
+			Dimensions: pulumi.StringMap{
+				"LoadBalancer": tg.loadBalancer.ArnSuffix,
+				"TargetGroup":  tg.targetGroup.ArnSuffix,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		service := pulumi.StringMap{
+			"ClusterName": cluster.Name,
+			"ServiceName": services[name].Name,
+		}
+		utilization := []struct {
+			metric    string
+			threshold float64
+		}{
+			{"CPUUtilization", a.CPU},
+			{"MemoryUtilization", a.Memory},
+		}
+		for _, u := range utilization {
+			_, err = cloudwatch.NewMetricAlarm(ctx, fmt.Sprintf("%s-%s", name, u.metric), &cloudwatch.MetricAlarmArgs{
+				AlarmDescription:   pulumi.Sprintf("%s of the %s service is above %g%%", u.metric, name, u.threshold),
+				Namespace:          pulumi.String("AWS/ECS"),
+				MetricName:         pulumi.String(u.metric),
+				Statistic:          pulumi.String("Average"),
+				Period:             pulumi.Int(alarmPeriod),
+				ComparisonOperator: pulumi.String("GreaterThanThreshold"),
+				EvaluationPeriods:  pulumi.Int(a.EvaluationPeriods),
+				Threshold:          pulumi.Float64(u.threshold),
+				TreatMissingData:   pulumi.String("notBreaching"),
+				AlarmActions:       conf.alarmActions(),
+				OkActions:          conf.alarmActions(),
+				Dimensions:         service,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Offboarded and scheduled down services desire no tasks, and so
+		// miss none.
+		_, err = cloudwatch.NewMetricAlarm(ctx, name+"-missing-tasks", &cloudwatch.MetricAlarmArgs{
+			AlarmDescription:   pulumi.Sprintf("The %s service runs fewer tasks than it desires", name),
+			ComparisonOperator: pulumi.String("GreaterThanThreshold"),
+			EvaluationPeriods:  pulumi.Int(a.EvaluationPeriods),
+			Threshold:          pulumi.Float64(float64(a.MissingTasks)),
+			TreatMissingData:   pulumi.String("notBreaching"),
+			AlarmActions:       conf.alarmActions(),
+			OkActions:          conf.alarmActions(),
+			MetricQueries: cloudwatch.MetricAlarmMetricQueryArray{
+				metricQuery("running", "ECS/ContainerInsights", "RunningTaskCount", "Minimum", service),
+				metricQuery("desired", "ECS/ContainerInsights", "DesiredTaskCount", "Maximum", service),
+				cloudwatch.MetricAlarmMetricQueryArgs{
+					Id:         pulumi.String("missing"),
+					Expression: pulumi.String("desired - running"),
+					Label:      pulumi.String("Missing tasks"),
+					ReturnData: pulumi.Bool(true),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudformation"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/sns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+var (
+	// slackWorkspacePattern and slackChannelPattern match the IDs of Slack
+	// workspaces and channels.
+	slackWorkspacePattern = regexp.MustCompile(`^[0-9A-Z]{1,255}$`)
+	slackChannelPattern   = regexp.MustCompile(`^[A-Za-z0-9]{1,255}$`)
+)
+
+// validateAlerts checks the subscriptions of the alert topic.
+func validateAlerts(conf *stackConfig) error {
+	a := conf.Alerts
+	for _, email := range a.Emails {
+		if !strings.Contains(email, "@") {
+			return fmt.Errorf("alerts.emails: %q is not an email address", email)
+		}
+	}
+	for _, url := range a.HTTPSEndpoints {
+		if !strings.HasPrefix(url, "https://") {
+			return fmt.Errorf("alerts.httpsEndpoints: %q is not an https:// URL", url)
+		}
+	}
+	if s := a.Slack; s != nil {
+		if !slackWorkspacePattern.MatchString(s.WorkspaceID) {
+			return fmt.Errorf("alerts.slack.workspaceId must be the ID of a Slack workspace authorized in AWS Chatbot, got %q", s.WorkspaceID)
+		}
+		if !slackChannelPattern.MatchString(s.ChannelID) {
+			return fmt.Errorf("alerts.slack.channelId must be the ID of a Slack channel, got %q", s.ChannelID)
+		}
+	}
+	return nil
+}
+
+// createAlertTopic creates the SNS topic the alarms of the stack notify,
+// with its subscriptions, and exports its ARN.
+func createAlertTopic(ctx *pulumi.Context, conf *stackConfig) error {
+	a := conf.Alerts
+	topic, err := sns.NewTopic(ctx, "alerts", &sns.TopicArgs{
+		DisplayName: pulumi.Sprintf("%s/%s alerts", ctx.Project(), ctx.Stack()),
+		Tags:        conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	conf.alertTopicArn = topic.Arn
+
+	subscriptions := map[string][]string{"email": a.Emails, "https": a.HTTPSEndpoints}
+	for _, protocol := range []string{"email", "https"} {
+		for i, endpoint := range subscriptions[protocol] {
+			_, err = sns.NewTopicSubscription(ctx, fmt.Sprintf("alerts-%s-%d", protocol, i), &sns.TopicSubscriptionArgs{
+				Topic:    topic.Arn,
+				Protocol: pulumi.String(protocol),
+				Endpoint: pulumi.String(endpoint),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if a.Slack != nil {
+		if err := createSlackChannel(ctx, topic, conf); err != nil {
+			return err
+		}
+	}
+
+	ctx.Export("alertTopicArn", topic.Arn)
+	return nil
+}
+
+// createSlackChannel has AWS Chatbot post the notifications of topic to the
+// Slack channel of alerts.slack. The provider has no Chatbot resources, so
+// the channel configuration is a CloudFormation stack of its own.
+func createSlackChannel(ctx *pulumi.Context, topic *sns.Topic, conf *stackConfig) error {
+	s := conf.Alerts.Slack
+	trust, err := assumeRolePolicy("chatbot.amazonaws.com")
+	if err != nil {
+		return err
+	}
+	role, err := iam.NewRole(ctx, "alerts-chatbot-role", &iam.RoleArgs{
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    trust,
+		Tags:                conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	// Lets Chatbot show the graphs of the alarms in the channel.
+	_, err = iam.NewRolePolicyAttachment(ctx, "alerts-chatbot-policy", &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/CloudWatchReadOnlyAccess"),
+	})
+	if err != nil {
+		return err
+	}
+
+	template, err := json.Marshal(map[string]interface{}{
+		"Parameters": map[string]interface{}{
+			"RoleArn":  map[string]string{"Type": "String"},
+			"TopicArn": map[string]string{"Type": "String"},
+		},
+		"Resources": map[string]interface{}{
+			"SlackChannel": map[string]interface{}{
+				"Type": "AWS::Chatbot::SlackChannelConfiguration",
+				"Properties": map[string]interface{}{
+					"ConfigurationName": awsNameInvalid.ReplaceAllString(fmt.Sprintf("%s-%s", ctx.Project(), ctx.Stack()), "-"),
+					"SlackWorkspaceId":  s.WorkspaceID,
+					"SlackChannelId":    s.ChannelID,
+					"IamRoleArn":        map[string]string{"Ref": "RoleArn"},
+					"SnsTopicArns":      []interface{}{map[string]string{"Ref": "TopicArn"}},
+					"GuardrailPolicies": []string{"arn:aws:iam::aws:policy/CloudWatchReadOnlyAccess"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cloudformation.NewStack(ctx, "alerts-slack", &cloudformation.StackArgs{
+		TemplateBody: pulumi.String(string(template)),
+		Parameters: pulumi.StringMap{
+			"RoleArn":  role.Arn,
+			"TopicArn": topic.Arn,
+		},
+		Tags: conf.resourceTags(),
+	})
+	return err
+}
+
+// alarmActions are the actions of the metric alarms when they go off or
+// recover: notifying the alert topic, or none without alerts.
+func (c *stackConfig) alarmActions() pulumi.ArrayInput {
+	if c.Alerts == nil {
+		return nil
+	}
+	return pulumi.Array{c.alertTopicArn}
+}
+
+// compositeAlarmActions are the alarmActions of the composite alarms.
+func (c *stackConfig) compositeAlarmActions() pulumi.StringArrayInput {
+	if c.Alerts == nil {
+		return nil
+	}
+	return pulumi.StringArray{c.alertTopicArn}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+)
+
+// App is an ECS service routed by Traefik. Its options turn into the docker
+// labels Traefik's ECS provider reads.
+type App struct {
+	Name string
+	// Image is the container image the app runs.
+	Image string
+	// Port is the container port Traefik forwards requests to.
+	Port int
+	// EntryPoints the app's router listens on.
+	EntryPoints []string
+
+	pathPrefix    string
+	internal      bool
+	middlewares   []Middleware
+	ipAllowList   []string
+	noCompression bool
+	reqHeaders    map[string]string
+	respHeaders   map[string]string
+	stackAuth     bool
+	canary        *appCanary
+	sticky        *StickyCookie
+	scheme        string
+	grpc          bool
+	host          string
+	extraLabels   map[string]string
+
+	environment      map[string]string
+	secrets          map[string]string
+	stackSecrets     map[string]string
+	parameters       map[string]string
+	taskSize         *taskSizeConfig
+	desiredCount     *int
+	runtimePlatform  *RuntimePlatform
+	volumes          []Volume
+	containerOptions ContainerOptions
+	gpus             int
+	taskPolicy       []PolicyStatement
+	tracing          bool
+	// role is the app's own task role, once createAppTaskRoles created it.
+	role *iam.Role
+	// taskVolumes are the task definition volumes of volumes.
+	taskVolumes ecs.TaskDefinitionVolumeArray
+}
+
+// appCanary is a second version of an app, running as a service of its own.
+type appCanary struct {
+	image  string
+	weight int
+}
+
+// NewApp returns an app listening on port 80, routed on the web entrypoint.
+func NewApp(name string) *App {
+	return &App{Name: name, Port: 80, EntryPoints: []string{"web"}}
+}
+
+// WithCanary also runs image as the app's canary, which gets weight percent
+// of the app's requests.
+func (a *App) WithCanary(image string, weight int) *App {
+	a.canary = &appCanary{image: image, weight: weight}
+	return a
+}
+
+// WithPathPrefix routes the requests whose path starts with prefix, e.g.
+// /api, to the app on any host, instead of those for the load balancer's
+// host. The prefix is stripped before the requests reach the app.
+func (a *App) WithPathPrefix(prefix string) *App {
+	a.pathPrefix = prefix
+	if len(prefix) > 1 {
+		a.pathPrefix = strings.TrimSuffix(prefix, "/")
+	}
+	return a
+}
+
+// rule is the rule of the app's router, given the host it is reached at.
+func (a *App) rule(host string) string {
+	if a.host != "" {
+		rule := fmt.Sprintf("Host(`%s`)", a.host)
+		if a.pathPrefix != "" {
+			rule += fmt.Sprintf(" && PathPrefix(`%s`)", a.pathPrefix)
+		}
+		return rule
+	}
+	if a.pathPrefix != "" {
+		return fmt.Sprintf("PathPrefix(`%s`)", a.pathPrefix)
+	}
+	return fmt.Sprintf("Host(`%s`)", host)
+}
+
+// WithInternal routes the app through the internal Traefik, on the host of
+// the internal load balancer, instead of the public one. It requires
+// internalTraefik.
+func (a *App) WithInternal() *App {
+	a.internal = true
+	return a
+}
+
+// WithRateLimit limits the app to average requests per second per client
+// IP, allowing bursts of up to burst requests.
+func (a *App) WithRateLimit(average, burst int) *App {
+	return a.Use(RateLimit(average, burst))
+}
+
+// WithRetry retries failed requests, see Retry.
+func (a *App) WithRetry(attempts int, initialInterval time.Duration) *App {
+	return a.Use(Retry(attempts, initialInterval))
+}
+
+// WithCircuitBreaker stops forwarding requests to the app, see
+// CircuitBreaker.
+func (a *App) WithCircuitBreaker(conditions ...BreakerCondition) *App {
+	return a.Use(CircuitBreaker(conditions...))
+}
+
+// WithIPAllowList only lets clients from ranges, in CIDR notation, reach the
+// app. It replaces the stack-wide ipAllowList for this app.
+func (a *App) WithIPAllowList(ranges ...string) *App {
+	a.ipAllowList = ranges
+	return a
+}
+
+// WithoutCompression opts the app out of the stack-wide compression, e.g.
+// because it compresses its responses itself.
+func (a *App) WithoutCompression() *App {
+	a.noCompression = true
+	return a
+}
+
+// StickyCookie is the cookie Traefik pins a client to a task with.
+type StickyCookie struct {
+	// Name defaults to one Traefik derives from the service name.
+	Name string `yaml:"name,omitempty"`
+	// Secure only sends the cookie over HTTPS.
+	Secure bool `yaml:"secure,omitempty"`
+	// HTTPOnly hides the cookie from JavaScript.
+	HTTPOnly bool `yaml:"httpOnly,omitempty"`
+}
+
+// WithStickySessions sends a client's requests to the task that served its
+// first one, for as long as the task is there, e.g. for apps keeping
+// sessions in memory. With a canary, clients also stick to a version.
+func (a *App) WithStickySessions(cookie StickyCookie) *App {
+	a.sticky = &cookie
+	return a
+}
+
+// WithH2C makes Traefik forward requests to the app with HTTP/2 without TLS,
+// whichever HTTP version the client speaks.
+func (a *App) WithH2C() *App {
+	a.scheme = "h2c"
+	return a
+}
+
+// WithGRPC marks the app as a gRPC service without TLS. Requests reach it
+// with HTTP/2, and the load balancer passes gRPC requests on to Traefik
+// with HTTP/2 as well.
+func (a *App) WithGRPC() *App {
+	a.grpc = true
+	return a.WithH2C()
+}
+
+// WithRequestHeaders sets headers on the requests forwarded to the app.
+func (a *App) WithRequestHeaders(headers map[string]string) *App {
+	a.reqHeaders = mergeHeaders(a.reqHeaders, headers)
+	return a
+}
+
+// WithResponseHeaders sets headers on the app's responses.
+func (a *App) WithResponseHeaders(headers map[string]string) *App {
+	a.respHeaders = mergeHeaders(a.respHeaders, headers)
+	return a
+}
+
+// WithoutRequestHeaders removes headers from the requests forwarded to the
+// app.
+func (a *App) WithoutRequestHeaders(names ...string) *App {
+	for _, name := range names {
+		a.reqHeaders = mergeHeaders(a.reqHeaders, map[string]string{name: ""})
+	}
+	return a
+}
+
+// WithoutResponseHeaders removes headers from the app's responses, e.g.
+// X-Powered-By.
+func (a *App) WithoutResponseHeaders(names ...string) *App {
+	for _, name := range names {
+		a.respHeaders = mergeHeaders(a.respHeaders, map[string]string{name: ""})
+	}
+	return a
+}
+
+// mergeHeaders adds headers to dst, replacing the values of those already
+// there, and returns dst.
+func mergeHeaders(dst, headers map[string]string) map[string]string {
+	if dst == nil {
+		dst = map[string]string{}
+	}
+	for name, value := range headers {
+		dst[name] = value
+	}
+	return dst
+}
+
+// WithForwardAuth checks every request with the authentication service at
+// address first, see ForwardAuth.
+func (a *App) WithForwardAuth(address string, responseHeaders ...string) *App {
+	return a.Use(ForwardAuth(address, responseHeaders...))
+}
+
+// WithStackAuth checks every request with the stack's authentication
+// service first, which the forwardAuth config deploys.
+func (a *App) WithStackAuth() *App {
+	a.stackAuth = true
+	return a
+}
+
+// Use adds middlewares to the app's router, after the ones added before.
+func (a *App) Use(middlewares ...Middleware) *App {
+	a.middlewares = append(a.middlewares, middlewares...)
+	return a
+}
+
+// labels are the docker labels of the app's container, or of its canary's.
+// An app being offboarded keeps its router, but Traefik ignores it.
+func (a *App) labels(rule string, conf *stackConfig, canary bool) (map[string]string, error) {
+	router := "traefik.http.routers." + a.Name
+	labels := map[string]string{
+		"traefik.enable":        fmt.Sprint(!conf.offboarding(a.Name)),
+		router + ".entrypoints": strings.Join(a.EntryPoints, ","),
+		router + ".rule":        rule,
+	}
+
+	// With a canary, the stable and canary containers each define a service
+	// of their own, which the generated weighted service splits the
+	// requests between. Both define the same router, so neither gets a
+	// default one.
+	service := a.Name
+	if a.canary != nil {
+		if w := a.canary.weight; w < 1 || w > 99 {
+			return nil, fmt.Errorf("app %s: the canary weight must be between 1 and 99, got %d", a.Name, w)
+		}
+		service = a.stableService()
+		if canary {
+			service = a.canaryService()
+		}
+		labels["traefik.http.services."+service+".loadbalancer.server.port"] = fmt.Sprint(a.Port)
+		labels[router+".service"] = a.Name + "@file"
+	}
+
+	if a.sticky != nil {
+		cookie := "traefik.http.services." + service + ".loadbalancer.sticky.cookie"
+		labels[cookie+".secure"] = fmt.Sprint(a.sticky.Secure)
+		labels[cookie+".httponly"] = fmt.Sprint(a.sticky.HTTPOnly)
+		if a.sticky.Name != "" {
+			labels[cookie+".name"] = a.sticky.Name
+		}
+		if a.canary == nil {
+			labels[router+".service"] = service
+		}
+	}
+
+	if a.scheme != "" {
+		labels["traefik.http.services."+service+".loadbalancer.server.scheme"] = a.scheme
+		if a.canary == nil {
+			labels[router+".service"] = service
+		}
+	}
+
+	if a.internal {
+		// The authentication service is only routed by the public Traefik.
+		if a.stackAuth {
+			return nil, fmt.Errorf("app %s: internal apps can't use WithStackAuth", a.Name)
+		}
+	}
+
+	// A copy, as the middlewares are inserted and appended to for every
+	// router, which would change the app's own.
+	middlewares := append([]Middleware(nil), a.middlewares...)
+	if a.stackAuth {
+		if conf.ForwardAuth == nil {
+			return nil, fmt.Errorf("app %s: WithStackAuth needs forwardAuth", a.Name)
+		}
+		middlewares = append([]Middleware{conf.forwardAuthMiddleware()}, middlewares...)
+	}
+
+	// The allow list is checked before anything else.
+	ipAllowList := conf.IPAllowList
+	if a.ipAllowList != nil {
+		ipAllowList = a.ipAllowList
+	}
+	if len(ipAllowList) > 0 {
+		middlewares = append([]Middleware{{
+			Kind: "ipallowlist",
+			Options: map[string]string{
+				"sourcerange":      strings.Join(ipAllowList, ","),
+				"ipstrategy.depth": "1",
+			},
+		}}, middlewares...)
+	}
+
+	// Error pages replace the errors of the middlewares after them too, such
+	// as a tripped circuit breaker's.
+	if conf.ErrorPages != nil {
+		n := 0
+		if len(ipAllowList) > 0 {
+			n = 1
+		}
+		middlewares = append(middlewares[:n], append([]Middleware{conf.errorPagesMiddleware()}, middlewares[n:]...)...)
+	}
+
+	// The app sees its paths as if it were served at the root, after the
+	// middlewares that may look at the whole path.
+	if a.pathPrefix != "" && a.pathPrefix != "/" {
+		if !strings.HasPrefix(a.pathPrefix, "/") {
+			return nil, fmt.Errorf("app %s: the path prefix must start with /, got %q", a.Name, a.pathPrefix)
+		}
+		middlewares = append(middlewares, Middleware{Kind: "stripprefix", Options: map[string]string{
+			"prefixes": a.pathPrefix,
+		}})
+	}
+
+	// Headers are set last, so the app gets them whatever the middlewares
+	// before did.
+	if a.reqHeaders != nil || a.respHeaders != nil {
+		middlewares = append(middlewares, Headers(a.reqHeaders, a.respHeaders))
+	}
+
+	if conf.Compress && !a.noCompression {
+		middlewares = append(middlewares, Middleware{Kind: "compress"})
+	}
+
+	// Middlewares are named after the app, so apps never share them.
+	var names []string
+	defined := map[string]bool{}
+	for _, m := range middlewares {
+		name, err := m.addLabels(labels, a.Name, defined, conf)
+		if err != nil {
+			return nil, fmt.Errorf("app %s: %w", a.Name, err)
+		}
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		labels[router+".middlewares"] = strings.Join(names, ",")
+	}
+
+	for k, v := range conf.Traefik.ConstraintLabels {
+		labels[k] = v
+	}
+	if a.internal {
+		labels[internalLabel] = internalLabelValue
+	}
+	for k, v := range a.extraLabels {
+		if _, ok := labels[k]; ok {
+			return nil, fmt.Errorf("app %s: the label %s is generated", a.Name, k)
+		}
+		labels[k] = v
+	}
+
+	return labels, nil
+}
+
+// stableService is the Traefik service of the stable containers of an app
+// with a canary.
+func (a *App) stableService() string {
+	return a.Name + "-stable"
+}
+
+// canaryService is the Traefik service of the canary containers.
+func (a *App) canaryService() string {
+	return a.Name + "-canary"
+}
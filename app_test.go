@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestAppLabelsMiddlewareOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		app  func() *App
+		conf func(*stackConfig)
+		want string
+	}{
+		{
+			name: "none",
+			app:  func() *App { return NewApp("whoami") },
+			conf: func(*stackConfig) {},
+			want: "",
+		},
+		{
+			name: "in the order they were added",
+			app: func() *App {
+				return NewApp("whoami").WithRateLimit(100, 50).WithRetry(3, 0)
+			},
+			conf: func(*stackConfig) {},
+			want: "whoami-ratelimit,whoami-retry",
+		},
+		{
+			name: "allow list first, stack auth before the app's",
+			app: func() *App {
+				return NewApp("whoami").WithRateLimit(100, 50).WithStackAuth().WithIPAllowList("10.0.0.0/8")
+			},
+			conf: func(c *stackConfig) { c.ForwardAuth = &forwardAuthConfig{Path: "/oauth2/auth"} },
+			want: "whoami-ipallowlist,whoami-forwardauth,whoami-ratelimit",
+		},
+		{
+			name: "error pages after the allow list",
+			app: func() *App {
+				return NewApp("whoami").WithCircuitBreaker(NetworkErrorRatioAbove(0.5))
+			},
+			conf: func(c *stackConfig) {
+				c.IPAllowList = []string{"10.0.0.0/8"}
+				c.ErrorPages = &errorPagesConfig{Status: []string{"500-599"}, Query: "/{status}.html"}
+			},
+			want: "whoami-ipallowlist,whoami-errors,whoami-circuitbreaker",
+		},
+		{
+			name: "error pages first without an allow list",
+			app: func() *App {
+				return NewApp("whoami").WithRateLimit(100, 50)
+			},
+			conf: func(c *stackConfig) {
+				c.ErrorPages = &errorPagesConfig{Status: []string{"500-599"}, Query: "/{status}.html"}
+			},
+			want: "whoami-errors,whoami-ratelimit",
+		},
+		{
+			name: "prefix stripped, then headers, then compression",
+			app: func() *App {
+				return NewApp("whoami").
+					WithRequestHeaders(map[string]string{"X-App": "whoami"}).
+					WithPathPrefix("/api").
+					WithRateLimit(100, 50)
+			},
+			conf: func(c *stackConfig) { c.Compress = true },
+			want: "whoami-ratelimit,whoami-stripprefix,whoami-headers,whoami-compress",
+		},
+		{
+			name: "without compression",
+			app: func() *App {
+				return NewApp("whoami").WithRateLimit(100, 50).WithoutCompression()
+			},
+			conf: func(c *stackConfig) { c.Compress = true },
+			want: "whoami-ratelimit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &stackConfig{Traefik: TraefikOptions{version: traefikVersion{3, 1}}}
+			tt.conf(conf)
+			app := tt.app()
+			// The canary's router gets the same middlewares as the app's.
+			for _, canary := range []bool{false, true} {
+				labels, err := app.labels("PathPrefix(`/`)", conf, canary)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := labels["traefik.http.routers.whoami.middlewares"]; got != tt.want {
+					t.Errorf("middlewares = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// WithHost routes the requests for host to the app, instead of those for
+// the load balancer's host. With a path prefix, both must match.
+func (a *App) WithHost(host string) *App {
+	a.host = host
+	return a
+}
+
+// WithEnvironment sets environment variables of the app's containers, and
+// those of its canary.
+func (a *App) WithEnvironment(environment map[string]string) *App {
+	if a.environment == nil {
+		a.environment = map[string]string{}
+	}
+	for name, value := range environment {
+		a.environment[name] = value
+	}
+	return a
+}
+
+// WithSecrets sets environment variables of the app's containers to the
+// values of Secrets Manager secrets or SSM parameters, by ARN.
+func (a *App) WithSecrets(secrets map[string]string) *App {
+	if a.secrets == nil {
+		a.secrets = map[string]string{}
+	}
+	for name, arn := range secrets {
+		a.secrets[name] = arn
+	}
+	return a
+}
+
+// WithLabels adds docker labels to the app's containers, next to the ones
+// generated for Traefik.
+func (a *App) WithLabels(labels map[string]string) *App {
+	if a.extraLabels == nil {
+		a.extraLabels = map[string]string{}
+	}
+	for k, v := range labels {
+		a.extraLabels[k] = v
+	}
+	return a
+}
+
+// WithTaskSize sizes the app's tasks, unless taskSizes does.
+func (a *App) WithTaskSize(cpu, memory int) *App {
+	a.taskSize = &taskSizeConfig{CPU: cpu, Memory: memory}
+	return a
+}
+
+// WithDesiredCount runs count tasks of the app, unless desiredCounts says
+// otherwise.
+func (a *App) WithDesiredCount(count int) *App {
+	a.desiredCount = &count
+	return a
+}
+
+// registerApps checks the apps and takes the task sizes and counts they ask
+// for, where the configuration doesn't set them. Apps can't take the names
+// of the stack's own services, nor of each other's services.
+func registerApps(apps []*App, conf *stackConfig) error {
+	names := map[string]bool{
+		"traefik": true, "traefik-canary": true, "traefik-internal": true, "traefik-acme": true, forwardAuthService: true,
+	}
+	conf.appServices = map[string]bool{}
+	for _, app := range apps {
+		for _, name := range []string{app.Name, app.canaryService()} {
+			if names[name] {
+				return fmt.Errorf("app %s: there is already a service %s", app.Name, name)
+			}
+			names[name] = true
+		}
+		if app.Image == "" {
+			return fmt.Errorf("app %s needs an image", app.Name)
+		}
+		if app.Port < 1 || app.Port > 65535 {
+			return fmt.Errorf("app %s: the port must be between 1 and 65535, got %d", app.Name, app.Port)
+		}
+		if app.internal && conf.InternalTraefik == nil {
+			return fmt.Errorf("app %s: WithInternal needs internalTraefik", app.Name)
+		}
+		conf.appServices[app.Name] = true
+
+		if _, ok := conf.TaskSizes[app.Name]; !ok && app.taskSize != nil {
+			if conf.TaskSizes == nil {
+				conf.TaskSizes = map[string]taskSizeConfig{}
+			}
+			conf.TaskSizes[app.Name] = *app.taskSize
+		}
+		if _, ok := conf.DesiredCounts[app.Name]; !ok && app.desiredCount != nil {
+			if *app.desiredCount < 0 {
+				return fmt.Errorf("app %s: the desired count must not be negative, got %d", app.Name, *app.desiredCount)
+			}
+			if conf.DesiredCounts == nil {
+				conf.DesiredCounts = map[string]int{}
+			}
+			conf.DesiredCounts[app.Name] = *app.desiredCount
+		}
+	}
+	return validateTaskSizes(conf)
+}
+
+// environmentVariables are the environment variables and secrets of the
+// app's container definition, with the ARNs of its stack secrets, sorted so
+// that the task definition only changes with them.
+func (a *App) environmentVariables(stackSecrets map[string]string) (environment []keyValuePair, secrets []secret) {
+	for name, value := range a.environment {
+		environment = append(environment, keyValuePair{Name: name, Value: value})
+	}
+	for name, arn := range a.secrets {
+		secrets = append(secrets, secret{Name: name, ValueFrom: arn})
+	}
+	for name, arn := range stackSecrets {
+		secrets = append(secrets, secret{Name: name, ValueFrom: arn})
+	}
+	sort.Slice(environment, func(i, j int) bool { return environment[i].Name < environment[j].Name })
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+	return environment, secrets
+}
+
+// createAppIngress lets Traefik, and the internal one if any, reach the apps
+// listening on other ports than 80, which container-sg lets in already.
+func createAppIngress(ctx *pulumi.Context, containerSg *ec2.SecurityGroup, traefikSgs []*ec2.SecurityGroup, apps []*App) error {
+	ports := map[int]bool{80: true}
+	for _, app := range apps {
+		if ports[app.Port] {
+			continue
+		}
+		ports[app.Port] = true
+		for i, sg := range traefikSgs {
+			name := fmt.Sprintf("container-sg-%d", app.Port)
+			if i > 0 {
+				name += "-internal"
+			}
+			_, err := ec2.NewSecurityGroupRule(ctx, name, &ec2.SecurityGroupRuleArgs{
+				Type:                  pulumi.String("ingress"),
+				SecurityGroupId:       containerSg.ID(),
+				SourceSecurityGroupId: sg.ID(),
+				Protocol:              pulumi.String("tcp"),
+				FromPort:              pulumi.Int(app.Port),
+				ToPort:                pulumi.Int(app.Port),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// arnService is the service field of an ARN, whatever its partition.
+func arnService(arn string) string {
+	fields := strings.SplitN(arn, ":", 4)
+	if len(fields) < 4 {
+		return ""
+	}
+	return fields[2]
+}
+
+// createAppSecretsPolicy lets the task execution role read the secrets of
+// the apps.
+func createAppSecretsPolicy(ctx *pulumi.Context, ecsRole *iam.Role, apps []*App) error {
+	var secretArns, parameterArns []string
+	for _, app := range apps {
+		for _, arn := range app.secrets {
+			if arnService(arn) == "ssm" {
+				parameterArns = append(parameterArns, arn)
+			} else {
+				secretArns = append(secretArns, arn)
+			}
+		}
+	}
+	if len(secretArns) == 0 && len(parameterArns) == 0 {
+		return nil
+	}
+	sort.Strings(secretArns)
+	sort.Strings(parameterArns)
+
+	var statements []PolicyStatement
+	if len(secretArns) > 0 {
+		statements = append(statements, Allow([]string{"secretsmanager:GetSecretValue"}, secretArns...))
+	}
+	if len(parameterArns) > 0 {
+		statements = append(statements, Allow([]string{"ssm:GetParameters"}, parameterArns...))
+	}
+	policy, err := NewPolicyDocument(statements...).JSON()
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicy(ctx, "app-secrets-policy", &iam.RolePolicyArgs{
+		Role:   ecsRole.ID(),
+		Policy: pulumi.String(policy),
+	})
+	return err
+}
+
+// createApp runs app as a service of its own, whose containers Traefik
+// discovers and routes to.
+func createApp(
+	ctx *pulumi.Context,
+	subnet *ec2.GetSubnetIdsResult,
+	containerSg *ec2.SecurityGroup,
+	cluster *ecs.Cluster,
+	app *App,
+	containerDef pulumi.StringOutput,
+	ecsRole *iam.Role,
+	conf *stackConfig,
+) (*ecs.TaskDefinition, *ecs.Service, error) {
+	// whoami's task definition predates the other apps.
+	var opts []pulumi.ResourceOption
+	if app.Name == "whoami" {
+		opts = append(opts, pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String("app-task")}}))
+	}
+	containerDefs, err := conf.taskContainerDefinitions(ctx, app.Name, containerDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	task, err := ecs.NewTaskDefinition(ctx, app.Name+"-task", &ecs.TaskDefinitionArgs{
+		Family:                  pulumi.String(app.Name),
+		ContainerDefinitions:    containerDefs,
+		Cpu:                     conf.taskCPU(app.Name),
+		Memory:                  conf.taskMemory(app.Name),
+		NetworkMode:             pulumi.String("awsvpc"),
+		RequiresCompatibilities: conf.compatibilities(),
+		RuntimePlatform:         app.platform(conf).args(),
+		EphemeralStorage:        conf.ephemeralStorage(app.Name),
+		ExecutionRoleArn:        ecsRole.Arn,
+		TaskRoleArn:             app.taskRole(app.Name, conf),
+		Tags:                    conf.resourceTags(),
+		Volumes:                 app.taskVolumes,
+	}, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// An app being offboarded is scaled to zero, and the update waits until
+	// its tasks have drained before it reports success.
+	count := conf.desiredCount(app.Name)
+	offboarding := conf.offboarding(app.Name)
+	if offboarding {
+		count = 0
+	}
+
+	registry, err := registerService(ctx, app.Name, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service, err := ecs.NewService(ctx, app.Name+"-service", &ecs.ServiceArgs{
+		Name: pulumi.String(app.Name),
+
+		Cluster:        cluster.Arn,
+		TaskDefinition: task.Arn,
+
+		DesiredCount:                    pulumi.Int(count),
+		LaunchType:                      conf.launchType(),
+		PlatformVersion:                 conf.platformVersion(),
+		CapacityProviderStrategies:      conf.capacityProviderStrategies(),
+		DeploymentMinimumHealthyPercent: conf.minimumHealthyPercent(app.Name),
+		DeploymentMaximumPercent:        conf.maximumPercent(app.Name),
+		DeploymentCircuitBreaker:        conf.circuitBreaker(),
+		ForceNewDeployment:              conf.forceNewDeployment(),
+		EnableExecuteCommand:            conf.enableExecuteCommand(app.Name),
+		PlacementConstraints:            conf.placementConstraints(app.Name),
+		OrderedPlacementStrategies:      conf.placementStrategies(app.Name),
+		PropagateTags:                   conf.propagateTags(),
+		EnableEcsManagedTags:            pulumi.Bool(true),
+		Tags:                            conf.resourceTags(),
+		ServiceRegistries:               registry,
+		WaitForSteadyState:              pulumi.Bool(offboarding),
+
+		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
+			AssignPublicIp: conf.assignPublicIP(),
+			Subnets:        conf.taskSubnets(subnet),
+			SecurityGroups: pulumi.StringArray{containerSg.ID().ToStringOutput()},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return task, service, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// edgeForwardAction forwards public traffic to the stable Traefik target
+// group, or splits it by weight with the canary one when there is a canary.
+func edgeForwardAction(traefikTg, canaryTg *elb.TargetGroup, conf *stackConfig) elb.ListenerDefaultActionArgs {
+	if canaryTg == nil {
+		return elb.ListenerDefaultActionArgs{
+			Type:           pulumi.String("forward"),
+			TargetGroupArn: traefikTg.Arn,
+		}
+	}
+
+	return elb.ListenerDefaultActionArgs{
+		Type: pulumi.String("forward"),
+		Forward: elb.ListenerDefaultActionForwardArgs{
+			TargetGroups: elb.ListenerDefaultActionForwardTargetGroupArray{
+				elb.ListenerDefaultActionForwardTargetGroupArgs{
+					Arn:    traefikTg.Arn,
+					Weight: pulumi.Int(100 - conf.TraefikCanary.Weight),
+				},
+				elb.ListenerDefaultActionForwardTargetGroupArgs{
+					Arn:    canaryTg.Arn,
+					Weight: pulumi.Int(conf.TraefikCanary.Weight),
+				},
+			},
+		},
+	}
+}
+
+// createTraefikCanary runs a second Traefik next to the stable one, registered
+// with canaryTg. It discovers the same cluster, so both route the same apps.
+func createTraefikCanary(
+	ctx *pulumi.Context,
+	subnet *ec2.GetSubnetIdsResult,
+	traefikSg *ec2.SecurityGroup,
+	canaryTg *elb.TargetGroup,
+	cluster *ecs.Cluster,
+	containerDef pulumi.StringOutput,
+	volumes ecs.TaskDefinitionVolumeArray,
+	ecsRole *iam.Role,
+	traefikRole *iam.Role,
+	conf *stackConfig,
+) (*ecs.TaskDefinition, *ecs.Service, error) {
+	containerDefs, err := conf.taskContainerDefinitions(ctx, "traefik-canary", containerDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	task, err := ecs.NewTaskDefinition(ctx, "traefik-canary-task", &ecs.TaskDefinitionArgs{
+		Family:                  pulumi.String("traefik-canary"),
+		ContainerDefinitions:    containerDefs,
+		Cpu:                     conf.taskCPU("traefik-canary"),
+		Memory:                  conf.taskMemory("traefik-canary"),
+		NetworkMode:             pulumi.String("awsvpc"),
+		RequiresCompatibilities: conf.compatibilities(),
+		RuntimePlatform:         conf.traefikPlatform().args(),
+		EphemeralStorage:        conf.ephemeralStorage("traefik-canary"),
+		ExecutionRoleArn:        ecsRole.Arn,
+		TaskRoleArn:             traefikRole.Arn,
+		Tags:                    conf.resourceTags(),
+		Volumes:                 volumes,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service, err := ecs.NewService(ctx, "traefik-canary-service", &ecs.ServiceArgs{
+		Name: pulumi.String("traefik-canary"),
+
+		Cluster:        cluster.Arn,
+		TaskDefinition: task.Arn,
+
+		DesiredCount:                    pulumi.Int(conf.desiredCount("traefik-canary")),
+		LaunchType:                      conf.launchType(),
+		PlatformVersion:                 conf.platformVersion(),
+		CapacityProviderStrategies:      conf.capacityProviderStrategies(),
+		DeploymentMinimumHealthyPercent: conf.minimumHealthyPercent("traefik-canary"),
+		DeploymentMaximumPercent:        conf.maximumPercent("traefik-canary"),
+		DeploymentCircuitBreaker:        conf.circuitBreaker(),
+		ForceNewDeployment:              conf.forceNewDeployment(),
+		EnableExecuteCommand:            conf.enableExecuteCommand("traefik-canary"),
+		PlacementConstraints:            conf.placementConstraints("traefik-canary"),
+		OrderedPlacementStrategies:      conf.placementStrategies("traefik-canary"),
+		PropagateTags:                   conf.propagateTags(),
+		EnableEcsManagedTags:            pulumi.Bool(true),
+		Tags:                            conf.resourceTags(),
+		HealthCheckGracePeriodSeconds:   conf.healthCheckGracePeriod("traefik-canary"),
+
+		LoadBalancers: ecs.ServiceLoadBalancerArray{
+			ecs.ServiceLoadBalancerArgs{
+				TargetGroupArn: canaryTg.Arn,
+				ContainerName:  pulumi.String("traefik"),
+				ContainerPort:  pulumi.Int(80),
+			},
+		},
+
+		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
+			AssignPublicIp: conf.assignPublicIP(),
+			Subnets:        conf.taskSubnets(subnet),
+			SecurityGroups: pulumi.StringArray{traefikSg.ID().ToStringOutput()},
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{canaryTg}))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return task, service, nil
+}
+
+// createAppCanary runs the canary of app as a service of its own, next to
+// the app's. Traefik splits the app's requests between the two.
+func createAppCanary(
+	ctx *pulumi.Context,
+	subnet *ec2.GetSubnetIdsResult,
+	containerSg *ec2.SecurityGroup,
+	cluster *ecs.Cluster,
+	app *App,
+	containerDef pulumi.StringOutput,
+	ecsRole *iam.Role,
+	conf *stackConfig,
+) (*ecs.TaskDefinition, *ecs.Service, error) {
+	name := app.canaryService()
+
+	// The canary leaves with its app.
+	count := conf.desiredCount(name)
+	if conf.offboarding(app.Name) {
+		count = 0
+	}
+
+	containerDefs, err := conf.taskContainerDefinitions(ctx, name, containerDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	task, err := ecs.NewTaskDefinition(ctx, name+"-task", &ecs.TaskDefinitionArgs{
+		Family:                  pulumi.String(name),
+		ContainerDefinitions:    containerDefs,
+		Cpu:                     conf.taskCPU(name),
+		Memory:                  conf.taskMemory(name),
+		NetworkMode:             pulumi.String("awsvpc"),
+		RequiresCompatibilities: conf.compatibilities(),
+		RuntimePlatform:         app.platform(conf).args(),
+		EphemeralStorage:        conf.ephemeralStorage(name),
+		ExecutionRoleArn:        ecsRole.Arn,
+		TaskRoleArn:             app.taskRole(name, conf),
+		Tags:                    conf.resourceTags(),
+		Volumes:                 app.taskVolumes,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registry, err := registerService(ctx, name, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service, err := ecs.NewService(ctx, name+"-service", &ecs.ServiceArgs{
+		Name: pulumi.String(name),
+
+		Cluster:        cluster.Arn,
+		TaskDefinition: task.Arn,
+
+		DesiredCount:                    pulumi.Int(count),
+		LaunchType:                      conf.launchType(),
+		PlatformVersion:                 conf.platformVersion(),
+		CapacityProviderStrategies:      conf.capacityProviderStrategies(),
+		DeploymentMinimumHealthyPercent: conf.minimumHealthyPercent(name),
+		DeploymentMaximumPercent:        conf.maximumPercent(name),
+		DeploymentCircuitBreaker:        conf.circuitBreaker(),
+		ForceNewDeployment:              conf.forceNewDeployment(),
+		EnableExecuteCommand:            conf.enableExecuteCommand(name),
+		PlacementConstraints:            conf.placementConstraints(name),
+		OrderedPlacementStrategies:      conf.placementStrategies(name),
+		PropagateTags:                   conf.propagateTags(),
+		EnableEcsManagedTags:            pulumi.Bool(true),
+		Tags:                            conf.resourceTags(),
+		ServiceRegistries:               registry,
+
+		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
+			AssignPublicIp: conf.assignPublicIP(),
+			Subnets:        conf.taskSubnets(subnet),
+			SecurityGroups: pulumi.StringArray{containerSg.ID().ToStringOutput()},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return task, service, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// clusterNamePattern matches the names ECS accepts for clusters.
+var clusterNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,255}$`)
+
+// validateCluster checks the cluster settings. An existing cluster is used as
+// it is, so the settings the stack would change on it are rejected.
+func validateCluster(conf *stackConfig) error {
+	c := conf.Cluster
+	if c.Arn != "" {
+		switch {
+		case c.Name != "" || c.ContainerInsights != "" || len(c.Tags) > 0:
+			return fmt.Errorf("cluster.arn uses an existing cluster, whose name, containerInsights and tags can't be set")
+		case conf.LaunchType == "EC2":
+			return fmt.Errorf("cluster.arn requires launchType FARGATE, the EC2 capacity provider would replace those of the cluster")
+		case conf.ExecuteCommand != nil:
+			return fmt.Errorf("cluster.arn can't be used with executeCommand, which configures the session logging of the cluster")
+		}
+		return nil
+	}
+	if c.Name != "" && !clusterNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("cluster.name must be up to 255 letters, numbers, hyphens and underscores, got %q", c.Name)
+	}
+	switch c.ContainerInsights {
+	case "", "enabled", "enhanced", "disabled":
+	default:
+		return fmt.Errorf("cluster.containerInsights must be enabled, enhanced or disabled, got %q", c.ContainerInsights)
+	}
+	if n := len(conf.clusterTagMap()); n > 50 {
+		return fmt.Errorf("cluster.tags: the cluster takes at most 50 tags, with those of tags, got %d", n)
+	}
+	return checkTags("cluster.tags", c.Tags)
+}
+
+// clusterTagMap are the tags of the cluster, those of cluster.tags
+// overriding tags.
+func (c *stackConfig) clusterTagMap() map[string]string {
+	tags := map[string]string{}
+	for k, v := range c.Tags {
+		tags[k] = v
+	}
+	for k, v := range c.Cluster.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// clusterTags are the tags of the cluster, or nil without tags.
+func (c *stackConfig) clusterTags() pulumi.StringMapInput {
+	tags := c.clusterTagMap()
+	if len(tags) == 0 {
+		return nil
+	}
+	return pulumi.ToStringMap(tags)
+}
+
+// clusterSettings are the settings of the cluster, or nil to keep the
+// account's defaults.
+func (c *stackConfig) clusterSettings() ecs.ClusterSettingArrayInput {
+	if c.Cluster.ContainerInsights == "" {
+		return nil
+	}
+	return ecs.ClusterSettingArray{
+		ecs.ClusterSettingArgs{
+			Name:  pulumi.String("containerInsights"),
+			Value: pulumi.String(c.Cluster.ContainerInsights),
+		},
+	}
+}
+
+// createCluster creates the ECS cluster, and the log group of its ECS Exec
+// sessions if executeCommand is set, or reads the existing cluster of
+// cluster.arn.
+func createCluster(ctx *pulumi.Context, conf *stackConfig) (*ecs.Cluster, *cloudwatch.LogGroup, error) {
+	if conf.Cluster.Arn != "" {
+		cluster, err := ecs.GetCluster(ctx, "traefik-cluster-demo", pulumi.ID(conf.Cluster.Arn), nil)
+		return cluster, nil, err
+	}
+
+	// Create an ECS cluster to run a container-based service.
+	args := &ecs.ClusterArgs{
+		Settings: conf.clusterSettings(),
+		Tags:     conf.clusterTags(),
+	}
+	if conf.Cluster.Name != "" {
+		args.Name = pulumi.String(conf.Cluster.Name)
+	}
+	var execLogs *cloudwatch.LogGroup
+	if conf.ExecuteCommand != nil {
+		var err error
+		args.Configuration, execLogs, err = createExecConfiguration(ctx, conf)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	cluster, err := ecs.NewCluster(ctx, "traefik-cluster-demo", args)
+	return cluster, execLogs, err
+}
@@ -0,0 +1,49 @@
+// Command rollback re-applies the manifest of a previously recorded
+// deployment to a stack, using the Pulumi Automation API:
+//
+//	go run ./cmd/rollback --stack dev --to 3
+//
+// The deployment id is the version of the stack's manifest parameter in SSM.
+// The stack stays pinned to that deployment until `rollbackTo` is removed
+// from its configuration.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+func main() {
+	stackName := flag.String("stack", "", "name of the stack to roll back")
+	to := flag.String("to", "", "id of the deployment to roll back to")
+	workDir := flag.String("dir", ".", "directory containing the Pulumi program")
+	flag.Parse()
+
+	if *stackName == "" || *to == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+
+	stack, err := auto.SelectStackLocalSource(ctx, *stackName, *workDir)
+	if err != nil {
+		log.Fatalf("selecting stack %s: %v", *stackName, err)
+	}
+
+	if err := stack.SetConfig(ctx, "rollbackTo", auto.ConfigValue{Value: *to}); err != nil {
+		log.Fatalf("setting rollbackTo: %v", err)
+	}
+
+	if _, err := stack.Up(ctx, optup.ProgressStreams(os.Stdout)); err != nil {
+		log.Fatalf("rolling back to deployment %s: %v", *to, err)
+	}
+
+	fmt.Printf("\n%s is running deployment %s. Remove `rollbackTo` from the stack config to resume normal deployments.\n", *stackName, *to)
+}
@@ -0,0 +1,1604 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// stackConfig holds the settings read from the Pulumi stack configuration.
+type stackConfig struct {
+	// Traefik configures the proxy itself.
+	Traefik TraefikOptions
+	// TraefikCanary runs a second Traefik version side by side with the
+	// stable one and sends it a share of the public traffic.
+	TraefikCanary *traefikCanaryConfig
+	// InternalTraefik runs a second Traefik behind an internal load
+	// balancer, for the apps that must not be reachable from the internet.
+	InternalTraefik *internalTraefikConfig
+
+	// LaunchType is FARGATE, or EC2 to run the tasks on instances of an Auto
+	// Scaling group.
+	LaunchType string
+	// EC2 sizes the instances of the EC2 launch type.
+	EC2 *ec2Config
+	// PlatformVersion is the Fargate platform version of the services,
+	// LATEST or a pinned one such as 1.4.0.
+	PlatformVersion string
+	// RuntimePlatform is the CPU architecture and operating system of the
+	// tasks, X86_64 Linux if not set.
+	RuntimePlatform *RuntimePlatform
+	// EphemeralStorage maps service names to the GiB of scratch space of
+	// their tasks on Fargate.
+	EphemeralStorage map[string]int
+	// TaskSizes maps service names to the CPU and memory of their tasks.
+	TaskSizes map[string]taskSizeConfig
+	// DesiredCounts maps service names to the number of their tasks, which
+	// defaults by environment.
+	DesiredCounts map[string]int
+	// appServices are the services of the apps, once registered.
+	appServices map[string]bool
+	// production tells whether the stack is a production environment, by
+	// its name.
+	production bool
+	// Containers maps the names of the stack's own containers, such as
+	// traefik, to how ECS runs and stops them.
+	Containers map[string]ContainerOptions
+	// Placement maps service names to the placement constraints and
+	// strategies of their tasks on the EC2 launch type.
+	Placement map[string]placementConfig
+	// Cluster names and configures the ECS cluster, or reuses an existing
+	// one.
+	Cluster clusterConfig
+	// AssumeRole deploys the stack through a role, such as one of another
+	// account, instead of with the credentials Pulumi runs with.
+	AssumeRole *assumeRoleConfig
+	// provider is the AWS provider assuming the role, once createProvider
+	// created it.
+	provider *aws.Provider
+	// GitHubActions creates the role GitHub Actions workflows of a
+	// repository deploy the stack with.
+	GitHubActions *githubActionsConfig
+	// Tags are the tags of the cluster, the services and the task
+	// definitions, which the services propagate to their tasks.
+	Tags map[string]string
+	// DefaultTags are the tags of every AWS resource of the stack that has
+	// tags, besides the pulumi-stack tag.
+	DefaultTags map[string]string
+	// capacityProvider is the EC2 capacity provider, once it is associated
+	// with the cluster.
+	capacityProvider pulumi.StringOutput
+
+	// DeploymentHistory records every deployment's manifest to SSM.
+	DeploymentHistory bool
+	// RollbackTo re-applies the manifest of a previously recorded deployment
+	// instead of the generated container definitions.
+	RollbackTo string
+	// rollback is the manifest of the deployment RollbackTo, once loaded.
+	rollback *deploymentManifest
+	// registries authenticates the resolution of image digests to private
+	// ECR registries.
+	registries *registryAuth
+
+	// CertificateArns are existing ACM certificates served by an HTTPS
+	// listener. The first one is the default certificate.
+	CertificateArns []string
+	// SslPolicy is the security policy of the HTTPS listeners, which decides
+	// the TLS versions and ciphers they negotiate.
+	SslPolicy string
+	// ACME has Traefik terminate TLS itself with Let's Encrypt certificates
+	// obtained through the Route53 DNS challenge.
+	ACME *acmeConfig
+
+	// InternalDashboard serves the Traefik dashboard/API from a separate
+	// internal load balancer instead of port 8080 of the public one.
+	InternalDashboard bool
+	// DashboardAllowedCidrs may reach the internal dashboard load balancer in
+	// addition to the VPC, e.g. a VPN range.
+	DashboardAllowedCidrs []string
+
+	// GlobalAccelerator fronts the public load balancer with an AWS Global
+	// Accelerator for static anycast IPs.
+	GlobalAccelerator bool
+
+	// HealthPort is the port of a dedicated Traefik entrypoint serving /ping
+	// to the load balancer health checks.
+	HealthPort int
+
+	// EntryPoints are extra Traefik entrypoints, each with a listener of
+	// its own.
+	EntryPoints []EntryPointOptions
+
+	// Offboard lists apps that are being removed. They stop receiving
+	// traffic and are scaled to zero before their resources are deleted.
+	Offboard []string
+
+	// IPAllowList are the CIDR ranges allowed to reach apps that don't
+	// have an allow list of their own. Empty allows everyone.
+	IPAllowList []string
+
+	// Compress compresses the responses of every app that doesn't opt out.
+	Compress bool
+
+	// SkipWhoami leaves out the whoami example app.
+	SkipWhoami bool
+
+	// StrictIAM scopes the task execution role to the stack's images and
+	// log groups, instead of the AWS managed policy.
+	StrictIAM bool
+	// PermissionsBoundary is the ARN of the managed policy that bounds the
+	// permissions of every role of the stack.
+	PermissionsBoundary string
+	// IAM puts the roles and policies of the stack under a path, with name
+	// prefixes.
+	IAM iamConfig
+	// NamePrefix prefixes the names of the Traefik task role, the ECS
+	// policy and the target groups, which are generated without it.
+	NamePrefix string
+
+	// ErrorPages replaces the error responses of every app with pages of
+	// an error page service.
+	ErrorPages *errorPagesConfig
+
+	// Redirects send the requests for some hosts or paths elsewhere, e.g.
+	// from www.example.com to example.com.
+	Redirects []redirectConfig
+
+	// ForwardAuth runs an authentication service, such as oauth2-proxy,
+	// that apps can ask Traefik to check their requests with.
+	ForwardAuth *forwardAuthConfig
+
+	// Timeouts keep long-lived connections, such as WebSockets, open through
+	// the load balancer and Traefik.
+	Timeouts *timeoutsConfig
+
+	// ImageRefresh periodically redeploys services that track mutable tags.
+	ImageRefresh imageRefreshConfig
+	// ServiceDiscovery registers the app services in a Cloud Map namespace.
+	ServiceDiscovery *serviceDiscoveryConfig
+	// namespaceID is the ID of the namespace, once createServiceDiscovery
+	// created it.
+	namespaceID pulumi.StringOutput
+
+	// FireLens routes the logs of the containers through a Fluent Bit
+	// sidecar in every task.
+	FireLens *fireLensConfig
+	// fireLensOptions are the options of the log routers' output, once
+	// createFireLens created its destination.
+	fireLensOptions map[string]string
+	// openSearchEndpoint is the endpoint of the domain of
+	// fireLens.openSearchDomain, once createFireLens created it.
+	openSearchEndpoint pulumi.StringOutput
+	// Logs configures the retention and the encryption of the log groups,
+	// and that of every service, which its containers log to without
+	// fireLens.
+	Logs logsConfig
+	// logGroups maps the services to the names of their log groups, and
+	// logRegion is their region, once createLogGroups created them.
+	logGroups map[string]string
+	logRegion string
+
+	// Secrets are Secrets Manager secrets, created by the stack or existing,
+	// that apps set environment variables from by name.
+	Secrets map[string]secretConfig
+	// secretArns are the ARNs of the secrets, once createSecrets created
+	// them.
+	secretArns map[string]pulumi.StringOutput
+	// Parameters are SSM SecureString parameters, created by the stack or
+	// existing, that apps set environment variables from by name.
+	Parameters map[string]parameterConfig
+	// parameterArns are the ARNs of the parameters, once createParameters
+	// created them.
+	parameterArns map[string]pulumi.StringOutput
+
+	// KMS is the customer managed key of the log groups, the secrets and
+	// the ECS Exec sessions, created by the stack or existing.
+	KMS *kmsConfig
+	// kmsKeyArn is the ARN of the key, once createKMSKey created it.
+	kmsKeyArn pulumi.StringOutput
+
+	// ExecuteCommand enables ECS Exec for some of the services.
+	ExecuteCommand *executeCommandConfig
+	// appRoleArn is the task role of the tasks without one, once
+	// createAppRole created it.
+	appRoleArn pulumi.StringOutput
+
+	// Deployment tunes the rolling deployments of the services.
+	Deployment deploymentConfig
+	// HealthCheckGracePeriods maps the names of load-balanced services to
+	// the seconds their new tasks may fail load balancer health checks.
+	HealthCheckGracePeriods map[string]int
+	// ScheduledScaling maps service names to the task counts they scale
+	// between on a schedule.
+	ScheduledScaling map[string]scheduledScalingConfig
+
+	// LoadBalancingAlgorithm selects how the ALB spreads requests over the
+	// Traefik replicas: round_robin or least_outstanding_requests.
+	LoadBalancingAlgorithm string
+
+	// DeregistrationDelay is how long, in seconds, the ALB lets in-flight
+	// requests to a deregistering Traefik task finish.
+	DeregistrationDelay int
+	// SlowStart is how long, in seconds, a new Traefik task's share of
+	// requests ramps up. Zero disables slow start.
+	SlowStart int
+
+	// Monitoring enables the CloudWatch alarms of the monitoring module.
+	Monitoring bool
+	// AnomalyBandWidth is the width, in standard deviations, of the anomaly
+	// detection band used by the target group alarms.
+	AnomalyBandWidth float64
+	// Alarms creates CloudWatch alarms of the load balancer, the target
+	// groups and the services, with their thresholds.
+	Alarms *alarmsConfig
+	// Alerts creates an SNS topic every alarm of the stack notifies, with
+	// its subscriptions.
+	Alerts *alertsConfig
+	// alertTopicArn is the ARN of the topic, once createAlertTopic created
+	// it.
+	alertTopicArn pulumi.StringOutput
+	// ECSEvents sends the failures of the tasks, deployments and services of
+	// the cluster to the alert topic, or to a Lambda function.
+	ECSEvents *ecsEventsConfig
+	// ManagedPrometheus scrapes the Prometheus metrics of Traefik with a
+	// collector sidecar and writes them to an Amazon Managed Service for
+	// Prometheus workspace.
+	ManagedPrometheus *managedPrometheusConfig
+	// prometheusWorkspaceArn and prometheusEndpoint are those of the
+	// workspace, once createPrometheusWorkspace created or looked it up.
+	prometheusWorkspaceArn pulumi.StringOutput
+	prometheusEndpoint     pulumi.StringOutput
+	// ManagedGrafana creates an Amazon Managed Grafana workspace with
+	// CloudWatch and the Prometheus workspace as data sources, and a starter
+	// dashboard of Traefik and the services.
+	ManagedGrafana *managedGrafanaConfig
+	// Synthetics runs a CloudWatch Synthetics canary against the load
+	// balancer and the apps, and alarms when they stop responding with 200.
+	Synthetics *syntheticsConfig
+	// CloudWatchDashboard creates a CloudWatch dashboard of the load
+	// balancer and the services.
+	CloudWatchDashboard bool
+	// SLOs maps app names to their service level objectives.
+	SLOs map[string]appSLO
+}
+
+// TraefikOptions are the settings turned into Traefik's static configuration.
+type TraefikOptions struct {
+	// Image is the Traefik image, e.g. traefik:v2.7 or traefik:v3.1.
+	Image string `json:"image"`
+	// Version overrides the version read from the image tag, for images
+	// whose tag doesn't carry one.
+	Version string `json:"version"`
+	version traefikVersion
+	// DesiredCount is the number of Traefik tasks serving traffic, 2 on
+	// production stacks and 1 otherwise by default.
+	DesiredCount int `json:"desiredCount"`
+
+	// LogLevel is one of DEBUG, INFO, WARN, ERROR, FATAL or PANIC, ERROR on
+	// production stacks and DEBUG otherwise by default.
+	LogLevel string `json:"logLevel"`
+	// LogFormat is common or json.
+	LogFormat string `json:"logFormat"`
+	// AccessLog ships JSON access logs to CloudWatch Logs.
+	AccessLog bool `json:"accessLog"`
+	// AccessLogRetention is how many days CloudWatch keeps the access logs.
+	AccessLogRetention int `json:"accessLogRetention"`
+	// AccessLogFilters limit the access logs to the requests matching them.
+	AccessLogFilters AccessLogFilters `json:"accessLogFilters"`
+	// AccessLogFields select which fields and request headers are logged.
+	AccessLogFields AccessLogFields `json:"accessLogFields"`
+	// Clusters are further ECS clusters, by name or ARN, whose services
+	// Traefik routes besides those of the stack's cluster.
+	Clusters []string `json:"clusters"`
+	// AutoDiscoverClusters routes the services of every cluster in the
+	// region instead.
+	AutoDiscoverClusters bool `json:"autoDiscoverClusters"`
+	// RefreshSeconds is how often the ECS provider polls the ECS API.
+	RefreshSeconds int `json:"refreshSeconds"`
+	// ExposedByDefault routes every ECS service, not just the ones labeled
+	// `traefik.enable=true`.
+	ExposedByDefault bool `json:"exposedByDefault"`
+	// ConstraintLabels restrict Traefik to containers carrying all of these
+	// labels. The containers of the stack get them too.
+	ConstraintLabels map[string]string `json:"constraintLabels"`
+	// excludedLabels keep Traefik away from containers carrying any of
+	// them.
+	excludedLabels map[string]string
+	// API narrows down what the dashboard entrypoint serves.
+	API APIOptions `json:"api"`
+	// FileProvider reads dynamic configuration that can't be expressed as
+	// docker labels from a directory on EFS.
+	FileProvider bool `json:"fileProvider"`
+	// Plugins maps plugin names, as used by middlewares, to the plugins
+	// Traefik downloads from the plugin catalog on start.
+	Plugins map[string]PluginOptions `json:"plugins"`
+	// Metrics publishes Prometheus metrics on an entrypoint of their own.
+	Metrics MetricsOptions `json:"metrics"`
+	// Tracing exports OpenTelemetry traces of the requests.
+	Tracing *TracingOptions `json:"tracing"`
+	// Hub connects Traefik to Traefik Hub.
+	Hub *HubOptions `json:"hub"`
+	// RuntimePlatform overrides the stack's runtimePlatform for the Traefik
+	// tasks.
+	RuntimePlatform *RuntimePlatform `json:"runtimePlatform"`
+}
+
+// taskSizeConfig is the CPU, in CPU units, and memory, in MiB, of a task.
+type taskSizeConfig struct {
+	CPU    int `json:"cpu"`
+	Memory int `json:"memory"`
+}
+
+// placementConfig is where ECS places the tasks of a service on the
+// instances of the EC2 launch type.
+type placementConfig struct {
+	Constraints []placementConstraint `json:"constraints"`
+	Strategies  []placementStrategy   `json:"strategies"`
+}
+
+// placementConstraint is a distinctInstance constraint, or a memberOf one
+// with a cluster query language expression.
+type placementConstraint struct {
+	Type       string `json:"type"`
+	Expression string `json:"expression"`
+}
+
+// placementStrategy spreads tasks by a field, binpacks them by cpu or
+// memory, or places them randomly.
+type placementStrategy struct {
+	Type  string `json:"type"`
+	Field string `json:"field"`
+}
+
+// ec2Config is the Auto Scaling group of ECS-optimized instances the tasks
+// run on with the EC2 launch type.
+type ec2Config struct {
+	InstanceType string `json:"instanceType"`
+	MinSize      int    `json:"minSize"`
+	MaxSize      int    `json:"maxSize"`
+	// TargetCapacity is the percentage of the instances' capacity the
+	// capacity provider aims for tasks to use.
+	TargetCapacity int `json:"targetCapacity"`
+	// GPU runs the GPU-optimized AMI, for the apps requesting GPUs. The
+	// instance type must then have GPUs, g4dn.xlarge by default.
+	GPU bool `json:"gpu"`
+	// OS is the operating system of the instances, amazon-linux-2 or
+	// bottlerocket.
+	OS string `json:"os"`
+	// SubnetIds are the subnets of the instances and tasks. Tasks on EC2
+	// get no public IPs, so these need a NAT gateway to pull images.
+	SubnetIds []string `json:"subnetIds"`
+}
+
+// internalTraefikConfig is the Traefik routing internal apps.
+type internalTraefikConfig struct {
+	// DesiredCount is the number of internal Traefik tasks, defaulting
+	// like traefik.desiredCount.
+	DesiredCount int `json:"desiredCount"`
+	// AllowedCidrs may reach the internal load balancer in addition to the
+	// VPC, e.g. a VPN range.
+	AllowedCidrs []string `json:"allowedCidrs"`
+}
+
+// HubOptions connect the Traefik tasks to Traefik Hub, which requires a
+// Traefik Hub image.
+type HubOptions struct {
+	// TokenSecret is the Secrets Manager secret holding the gateway's
+	// token.
+	TokenSecret string `json:"tokenSecret"`
+}
+
+// AccessLogFilters only log requests that match at least one of them.
+type AccessLogFilters struct {
+	// StatusCodes are codes or ranges, e.g. 500-599.
+	StatusCodes []string `json:"statusCodes" yaml:"statusCodes,omitempty"`
+	// RetryAttempts logs requests that were retried.
+	RetryAttempts bool `json:"retryAttempts" yaml:"retryAttempts,omitempty"`
+	// MinDuration logs requests that took longer, e.g. 500ms.
+	MinDuration string `json:"minDuration" yaml:"minDuration,omitempty"`
+}
+
+// AccessLogFields keep, drop or redact access log fields and headers.
+type AccessLogFields struct {
+	// DefaultMode applies to fields not in Names.
+	DefaultMode string            `json:"defaultMode" yaml:"defaultMode,omitempty"`
+	Names       map[string]string `json:"names" yaml:"names,omitempty"`
+	Headers     struct {
+		// DefaultMode applies to headers not in Names. Traefik drops
+		// headers unless told otherwise.
+		DefaultMode string            `json:"defaultMode" yaml:"defaultMode,omitempty"`
+		Names       map[string]string `json:"names" yaml:"names,omitempty"`
+	} `json:"headers" yaml:"headers,omitempty"`
+}
+
+// PluginOptions select a Traefik plugin.
+type PluginOptions struct {
+	// ModuleName is the plugin's Go module, e.g. github.com/traefik/plugindemo.
+	ModuleName string `json:"moduleName" yaml:"moduleName"`
+	Version    string `json:"version" yaml:"version"`
+}
+
+// MetricsOptions configure Traefik's Prometheus metrics.
+type MetricsOptions struct {
+	Prometheus bool `json:"prometheus"`
+	// Port is the port of the metrics entrypoint.
+	Port int `json:"port"`
+	// Listener adds a listener on the internal load balancer that forwards
+	// the metrics port to Traefik, for scrapers that can't discover tasks.
+	Listener bool `json:"listener"`
+}
+
+// TracingOptions configure Traefik's OpenTelemetry tracing.
+type TracingOptions struct {
+	// SampleRate is the share of requests traced, from 0 to 1.
+	SampleRate float64 `json:"sampleRate"`
+	// Endpoint is the host:port of an OTLP gRPC collector. Without one, a
+	// collector sidecar forwards the traces to X-Ray.
+	Endpoint string `json:"endpoint"`
+	// Insecure talks to Endpoint without TLS.
+	Insecure bool `json:"insecure"`
+}
+
+// APIOptions select the parts of the Traefik API that are routed.
+type APIOptions struct {
+	// DisableDashboard serves the API without the web UI.
+	DisableDashboard bool `json:"disableDashboard"`
+	// Debug exposes the /debug endpoints (expvar and pprof).
+	Debug bool `json:"debug"`
+	// RawData exposes /api/rawdata, which dumps the whole dynamic
+	// configuration including middleware settings.
+	RawData bool `json:"rawData"`
+	// AuthSecret is the Secrets Manager secret, by name or ARN, holding the
+	// htpasswd lines of the users allowed to log in to the dashboard.
+	AuthSecret string `json:"authSecret"`
+}
+
+// traefikCanaryConfig describes the Traefik version being rolled out.
+type traefikCanaryConfig struct {
+	Image   string `json:"image"`
+	Version string `json:"version"`
+	version traefikVersion
+
+	// Weight is the percentage of public traffic sent to the canary.
+	Weight int `json:"weight"`
+}
+
+// EntryPointOptions declare a custom Traefik entrypoint.
+type EntryPointOptions struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	// Protocol is http, served by the public ALB, or tcp or udp, served by
+	// a network load balancer.
+	Protocol string `json:"protocol"`
+}
+
+// acmeConfig configures the Let's Encrypt certificate resolver.
+type acmeConfig struct {
+	// Email is the contact address of the ACME account.
+	Email string `json:"email"`
+	// HostedZoneID is the Route53 zone the DNS-01 challenge records are
+	// written to.
+	HostedZoneID string `json:"hostedZoneId"`
+	// CAServer overrides the ACME directory, e.g. the Let's Encrypt staging
+	// environment.
+	CAServer string `json:"caServer"`
+	// TLS restricts the connections Traefik terminates.
+	TLS *TLSOptions `json:"tls"`
+}
+
+// TLSOptions are Traefik's default TLS options, applied to every router
+// whose TLS Traefik terminates.
+type TLSOptions struct {
+	// MinVersion is the oldest TLS version accepted, e.g. VersionTLS12.
+	MinVersion string `json:"minVersion" yaml:"minVersion,omitempty"`
+	// CipherSuites are the cipher suites accepted up to TLS 1.2, by their
+	// Go names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+	CipherSuites []string `json:"cipherSuites" yaml:"cipherSuites,omitempty"`
+	// SNIStrict rejects clients that don't send a server name matching a
+	// certificate.
+	SNIStrict bool `json:"sniStrict" yaml:"sniStrict,omitempty"`
+}
+
+// timeoutsConfig sets the timeouts of the public load balancer and of the
+// Traefik entrypoints behind it together, in seconds.
+type timeoutsConfig struct {
+	// Idle is how long the load balancer keeps a connection without any
+	// traffic open, 1 to 4000. Traefik keeps its side open for longer.
+	Idle int `json:"idle"`
+	// Read and Write limit how long Traefik reads a request and writes its
+	// response, 0 for no limit.
+	Read  int `json:"read"`
+	Write int `json:"write"`
+	// ResponseHeader limits how long Traefik waits for the response headers
+	// of an app, 0 for no limit.
+	ResponseHeader int `json:"responseHeader"`
+}
+
+// redirectConfig redirects the requests for From to To. Both are a host
+// with an optional path, e.g. example.com/docs. The rest of the path and
+// the scheme are kept.
+type redirectConfig struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Permanent redirects with 301 or 308 instead of 302 or 307.
+	Permanent bool `json:"permanent"`
+}
+
+// forwardAuthConfig is the authentication service of the stack, which runs
+// as an ECS service of its own.
+type forwardAuthConfig struct {
+	Image string `json:"image"`
+	Port  int    `json:"port"`
+	// Path is where the service answers the checks, e.g. /oauth2/auth. A
+	// 2xx answer lets the request through, any other is returned instead.
+	Path string `json:"path"`
+	// PathPrefix is routed to the service on every host, for its sign-in
+	// pages and callbacks, e.g. /oauth2/.
+	PathPrefix string `json:"pathPrefix"`
+	// ResponseHeaders are copied from the service's answer to the request,
+	// e.g. X-Auth-Request-Email.
+	ResponseHeaders []string `json:"responseHeaders"`
+	// Environment holds the container's environment variables, Secrets
+	// the ARNs of Secrets Manager secrets injected as such.
+	Environment map[string]string `json:"environment"`
+	Secrets     map[string]string `json:"secrets"`
+}
+
+// errorPagesConfig configures the errors middleware of the apps. The pages
+// are served either by a sidecar of the Traefik tasks running Image, or by
+// an existing Traefik Service.
+type errorPagesConfig struct {
+	// Status are the status codes or ranges replaced, e.g. 500-599.
+	Status []string `json:"status"`
+	// Query is the path of the page requested from the error page service,
+	// where {status} is the status code.
+	Query string `json:"query"`
+	// Image serves the pages on Port.
+	Image string `json:"image"`
+	Port  int    `json:"port"`
+	// Service is a Traefik service serving the pages, e.g. errors@ecs.
+	Service string `json:"service"`
+}
+
+// imageRefreshConfig schedules forced redeployments so services pick up new
+// images published under the tags they run.
+type imageRefreshConfig struct {
+	Enabled bool `json:"enabled"`
+	// Schedule is an EventBridge schedule expression.
+	Schedule string `json:"schedule"`
+	// Exclude lists services that must not be redeployed.
+	Exclude []string `json:"exclude"`
+}
+
+// serviceDiscoveryConfig is the private DNS namespace apps find each other
+// in, as <app>.<namespace>.
+type serviceDiscoveryConfig struct {
+	Namespace string `json:"namespace"`
+	// TTL is how many seconds resolvers cache the addresses of the tasks.
+	TTL int `json:"ttl"`
+}
+
+// fireLensConfig is where FireLens sends the logs: a log group of the stack,
+// a Kinesis Data Firehose delivery stream or an OpenSearch domain.
+type fireLensConfig struct {
+	// Destination is cloudwatch, firehose or opensearch.
+	Destination string `json:"destination"`
+	// Image is the Fluent Bit image of the log routers.
+	Image string `json:"image"`
+	// LogRetention is how many days CloudWatch keeps the logs.
+	LogRetention        int    `json:"logRetention"`
+	DeliveryStream      string `json:"deliveryStream"`
+	OpenSearchHost      string `json:"openSearchHost"`
+	OpenSearchDomainArn string `json:"openSearchDomainArn"`
+	OpenSearchIndex     string `json:"openSearchIndex"`
+	// OpenSearchDomain creates the domain the logs are sent to with
+	// destination opensearch, instead of openSearchHost and
+	// openSearchDomainArn.
+	OpenSearchDomain *openSearchDomainConfig `json:"openSearchDomain"`
+	// AccessLog sends the access logs of Traefik through the log routers
+	// too, instead of to a log group of their own.
+	AccessLog bool `json:"accessLog"`
+}
+
+// openSearchDomainConfig is the OpenSearch domain the stack creates for the
+// logs.
+type openSearchDomainConfig struct {
+	// EngineVersion is the OpenSearch version, OpenSearch_2.11 by default.
+	EngineVersion string `json:"engineVersion"`
+	// InstanceType and InstanceCount are the data nodes, a single
+	// t3.small.search by default. Several are spread over availability
+	// zones.
+	InstanceType  string `json:"instanceType"`
+	InstanceCount int    `json:"instanceCount"`
+	// VolumeSize is the size of the volume of every node in GiB, 10 by
+	// default.
+	VolumeSize int `json:"volumeSize"`
+	// AllowedCidrs may query the domain without signing their requests,
+	// e.g. to open OpenSearch Dashboards in a browser.
+	AllowedCidrs []string `json:"allowedCidrs"`
+}
+
+// clusterConfig names and configures the ECS cluster of the stack.
+type clusterConfig struct {
+	// Name is the name of the cluster, generated by Pulumi if not set.
+	// Renaming the cluster replaces it, and its services with it.
+	Name string `json:"name"`
+	// Arn runs the services on an existing cluster instead of creating one.
+	Arn string `json:"arn"`
+	// ContainerInsights is enabled, enhanced or disabled, the account's
+	// default if not set.
+	ContainerInsights string `json:"containerInsights"`
+	// Tags are tags of the cluster only, on top of tags.
+	Tags map[string]string `json:"tags"`
+}
+
+// secretConfig is a secret of the stack, either created from Value or an
+// existing one.
+type secretConfig struct {
+	// Value is the secret string, set with pulumi config set --secret.
+	Value       string `json:"value"`
+	Description string `json:"description"`
+	// KmsKeyArn encrypts the secret instead of the aws/secretsmanager key.
+	KmsKeyArn string `json:"kmsKeyArn"`
+	// Arn refers to an existing secret instead.
+	Arn string `json:"arn"`
+}
+
+// assumeRoleConfig is the role the stack is deployed with.
+type assumeRoleConfig struct {
+	RoleArn string `json:"roleArn"`
+	// ExternalID is the external ID the role's trust policy requires, if
+	// any.
+	ExternalID string `json:"externalId"`
+	// SessionName names the sessions, pulumi-<project>-<stack> by default.
+	SessionName string `json:"sessionName"`
+}
+
+// githubActionsConfig limits the deployment role to the workflows of a
+// repository run on some of its branches or environments.
+type githubActionsConfig struct {
+	// Repository is owner/name.
+	Repository string `json:"repository"`
+	// Branches are the branches, main by default, and may use * and ?
+	// wildcards.
+	Branches []string `json:"branches"`
+	// Environments are the GitHub environments of the jobs, for workflows
+	// that deploy through one.
+	Environments []string `json:"environments"`
+	// ProviderArn is the account's GitHub OIDC provider, if another stack
+	// created it already.
+	ProviderArn string `json:"providerArn"`
+	// PolicyArns are the managed policies of the role.
+	PolicyArns []string `json:"policyArns"`
+}
+
+// iamConfig is where the roles and managed policies of the stack go, and
+// how they are named, e.g. to match the name patterns of SCPs.
+type iamConfig struct {
+	// Path is the path of the roles, policies and instance profiles, e.g.
+	// /service-roles/.
+	Path string `json:"path"`
+	// RolePrefix and PolicyPrefix start the names of the roles and the
+	// managed policies.
+	RolePrefix   string `json:"rolePrefix"`
+	PolicyPrefix string `json:"policyPrefix"`
+}
+
+// alarmsConfig are the thresholds of the alarms of the stack.
+type alarmsConfig struct {
+	// ErrorRate is the percentage of 5xx responses of the load balancer
+	// that sets off its alarm, 5 by default.
+	ErrorRate float64 `json:"errorRate"`
+	// UnhealthyHosts is the number of unhealthy targets of a target group
+	// that sets off its alarm, 1 by default.
+	UnhealthyHosts int `json:"unhealthyHosts"`
+	// CPU and Memory are the average utilization percentages of a service
+	// that set off its alarms, 80 by default.
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	// MissingTasks is how many tasks fewer than desired a service may run
+	// without setting off its alarm, 0 by default.
+	MissingTasks int `json:"missingTasks"`
+	// EvaluationPeriods is how many minutes in a row a threshold has to be
+	// crossed for an alarm to go off, 5 by default.
+	EvaluationPeriods int `json:"evaluationPeriods"`
+}
+
+// managedPrometheusConfig is the workspace the metrics of Traefik are
+// written to.
+type managedPrometheusConfig struct {
+	// WorkspaceID is an existing workspace. Without one, the stack creates
+	// its own.
+	WorkspaceID string `json:"workspaceId"`
+	// ScrapeInterval is how often the collectors scrape Traefik, 30s by
+	// default.
+	ScrapeInterval string `json:"scrapeInterval"`
+}
+
+// ecsEventsConfig is where the events of the cluster go besides the alert
+// topic.
+type ecsEventsConfig struct {
+	// FunctionArn is a Lambda function the events are sent to, which
+	// EventBridge is allowed to invoke.
+	FunctionArn string `json:"functionArn"`
+}
+
+// syntheticsConfig is the canary of the stack.
+type syntheticsConfig struct {
+	// Schedule is a rate or cron expression of how often the canary runs,
+	// rate(5 minutes) by default.
+	Schedule string `json:"schedule"`
+	// Path is checked on the host of the load balancer, / by default.
+	Path string `json:"path"`
+	// Exclude are the apps the canary doesn't check, e.g. those that need
+	// authentication.
+	Exclude []string `json:"exclude"`
+}
+
+// managedGrafanaConfig is the Grafana workspace of the stack.
+type managedGrafanaConfig struct {
+	// Authentication is how users sign in to the workspace, AWS_SSO (IAM
+	// Identity Center) by default, or SAML.
+	Authentication string `json:"authentication"`
+}
+
+// alertsConfig are the subscriptions of the alert topic.
+type alertsConfig struct {
+	// Emails are the addresses notified by email, once they confirmed the
+	// subscription.
+	Emails []string `json:"emails"`
+	// HTTPSEndpoints are URLs SNS posts the notifications to, such as those
+	// of an incident management service.
+	HTTPSEndpoints []string `json:"httpsEndpoints"`
+	// Slack posts the notifications to a Slack channel through AWS
+	// Chatbot.
+	Slack *slackConfig `json:"slack"`
+}
+
+// slackConfig is a channel of a Slack workspace authorized in AWS Chatbot.
+type slackConfig struct {
+	WorkspaceID string `json:"workspaceId"`
+	ChannelID   string `json:"channelId"`
+}
+
+// logsConfig configures the log groups of the stack.
+type logsConfig struct {
+	// Retention is how many days CloudWatch keeps the logs, 30 by default.
+	Retention int `json:"retention"`
+	// KmsKeyArn encrypts the log groups instead of the stack's key.
+	KmsKeyArn string `json:"kmsKeyArn"`
+	// Groups override the retention and the key of some log groups: those
+	// of the services by name, and accessLog, executeCommand and fireLens.
+	Groups map[string]logGroupConfig `json:"groups"`
+}
+
+// logGroupConfig is the retention and the key of a log group, which default
+// to the stack-wide ones.
+type logGroupConfig struct {
+	Retention int    `json:"retention"`
+	KmsKeyArn string `json:"kmsKeyArn"`
+}
+
+// kmsConfig is the customer managed key of the stack.
+type kmsConfig struct {
+	// Create has the stack create the key, with a key policy that lets
+	// CloudWatch Logs use it.
+	Create bool `json:"create"`
+	// KeyArn uses an existing key instead, whose key policy must let
+	// CloudWatch Logs use it.
+	KeyArn string `json:"keyArn"`
+	// DeletionWindow is how many days a deleted key can still be restored,
+	// 30 by default.
+	DeletionWindow int `json:"deletionWindow"`
+}
+
+// parameterConfig is an SSM SecureString parameter of the stack, either
+// created from Value or an existing one.
+type parameterConfig struct {
+	// Value is the parameter's value, set with pulumi config set --secret.
+	Value       string `json:"value"`
+	Description string `json:"description"`
+	// KmsKeyArn encrypts the parameter instead of the stack's key or the
+	// aws/ssm key.
+	KmsKeyArn string `json:"kmsKeyArn"`
+	// Arn refers to an existing parameter instead.
+	Arn string `json:"arn"`
+}
+
+// executeCommandConfig lets operators open shells in the containers of some
+// services with ECS Exec.
+type executeCommandConfig struct {
+	// Services are the names of the services, as in imageRefresh.exclude.
+	Services []string `json:"services"`
+	// KmsKeyArn encrypts the sessions and their logs.
+	KmsKeyArn string `json:"kmsKeyArn"`
+	// LogRetention is how many days CloudWatch keeps the session logs.
+	LogRetention int `json:"logRetention"`
+}
+
+// deploymentConfig tunes the rolling deployments of the services.
+type deploymentConfig struct {
+	// CircuitBreaker stops deployments whose new tasks keep failing, and
+	// Rollback then rolls them back. Both default to true.
+	CircuitBreaker *bool `json:"circuitBreaker"`
+	Rollback       *bool `json:"rollback"`
+	// MinimumHealthyPercent and MaximumPercent bound the running tasks of a
+	// service during a deployment, as percentages of its task count.
+	MinimumHealthyPercent *int `json:"minimumHealthyPercent"`
+	MaximumPercent        int  `json:"maximumPercent"`
+	// Services maps service names to percentages of their own.
+	Services map[string]deploymentPercents `json:"services"`
+	// PinImages pins the images of the containers to the digests their tags
+	// point at during pulumi up, so that an image pushed under the same tag
+	// changes the task definition and is deployed.
+	PinImages bool `json:"pinImages"`
+	// ForceNewDeployment replaces the tasks of a service whenever pulumi up
+	// updates it, even when its task definition is unchanged.
+	ForceNewDeployment bool `json:"forceNewDeployment"`
+}
+
+// deploymentPercents are the percentages of the deployments of one service.
+// Those left out are the stack's.
+type deploymentPercents struct {
+	MinimumHealthyPercent *int `json:"minimumHealthyPercent"`
+	MaximumPercent        int  `json:"maximumPercent"`
+}
+
+// scheduledScalingConfig bounds the task count of a service, and changes the
+// bounds on a schedule, e.g. down to zero at night.
+type scheduledScalingConfig struct {
+	// MinCapacity and MaxCapacity bound the task count until the first
+	// action.
+	MinCapacity int `json:"minCapacity"`
+	MaxCapacity int `json:"maxCapacity"`
+	// Timezone is the IANA time zone of the schedules, UTC by default.
+	Timezone string                   `json:"timezone"`
+	Actions  []scheduledScalingAction `json:"actions"`
+}
+
+// scheduledScalingAction sets new bounds on the task count of a service at
+// the times of Schedule, an at, rate or cron expression.
+type scheduledScalingAction struct {
+	Name        string `json:"name"`
+	Schedule    string `json:"schedule"`
+	MinCapacity *int   `json:"minCapacity"`
+	MaxCapacity *int   `json:"maxCapacity"`
+}
+
+// appSLO is the service level objective of one app, measured from the Traefik
+// access logs of its router. Either target may be left at zero to skip it.
+type appSLO struct {
+	// Availability is the percentage of requests that must not fail with a
+	// 5xx status, e.g. 99.9.
+	Availability float64 `json:"availability"`
+	// Latency is the percentage of requests that must complete within
+	// LatencyThresholdMs.
+	Latency            float64 `json:"latency"`
+	LatencyThresholdMs int     `json:"latencyThresholdMs"`
+}
+
+func loadConfig(ctx *pulumi.Context) (*stackConfig, error) {
+	cfg := config.New(ctx, "")
+
+	conf := &stackConfig{
+		DeploymentHistory:   cfg.GetBool("deploymentHistory"),
+		RollbackTo:          cfg.Get("rollbackTo"),
+		registries:          &registryAuth{tokens: map[string]string{}},
+		InternalDashboard:   cfg.GetBool("internalDashboard"),
+		HealthPort:          cfg.GetInt("healthPort"),
+		GlobalAccelerator:   cfg.GetBool("globalAccelerator"),
+		Compress:            cfg.GetBool("compress"),
+		SkipWhoami:          cfg.GetBool("skipWhoami"),
+		StrictIAM:           cfg.GetBool("strictIam"),
+		PermissionsBoundary: cfg.Get("permissionsBoundary"),
+		NamePrefix:          cfg.Get("namePrefix"),
+		LaunchType:          cfg.Get("launchType"),
+		PlatformVersion:     cfg.Get("platformVersion"),
+		Monitoring:          cfg.GetBool("monitoring"),
+		AnomalyBandWidth:    cfg.GetFloat64("anomalyBandWidth"),
+		CloudWatchDashboard: cfg.GetBool("cloudwatchDashboard"),
+
+		LoadBalancingAlgorithm: cfg.Get("loadBalancingAlgorithm"),
+		DeregistrationDelay:    300,
+		SlowStart:              cfg.GetInt("slowStart"),
+	}
+	conf.production = productionStack(ctx.Stack())
+	if arn := cfg.Get("certificateArn"); arn != "" {
+		conf.CertificateArns = append(conf.CertificateArns, arn)
+	}
+	var extraCertificateArns []string
+	if err := cfg.GetObject("extraCertificateArns", &extraCertificateArns); err != nil {
+		return nil, err
+	}
+	if len(extraCertificateArns) > 0 && len(conf.CertificateArns) == 0 {
+		return nil, fmt.Errorf("extraCertificateArns requires certificateArn")
+	}
+	conf.CertificateArns = append(conf.CertificateArns, extraCertificateArns...)
+	conf.SslPolicy = cfg.Get("sslPolicy")
+	if conf.SslPolicy == "" {
+		conf.SslPolicy = "ELBSecurityPolicy-TLS13-1-2-2021-06"
+	}
+	if err := cfg.GetObject("acme", &conf.ACME); err != nil {
+		return nil, err
+	}
+	if conf.ACME != nil {
+		if conf.ACME.Email == "" || conf.ACME.HostedZoneID == "" {
+			return nil, fmt.Errorf("acme requires email and hostedZoneId")
+		}
+		if conf.ACME.TLS != nil {
+			if err := validateTLSOptions(conf.ACME.TLS); err != nil {
+				return nil, fmt.Errorf("acme.tls: %w", err)
+			}
+		}
+	}
+	if err := cfg.GetObject("dashboardAllowedCidrs", &conf.DashboardAllowedCidrs); err != nil {
+		return nil, err
+	}
+	switch conf.LoadBalancingAlgorithm {
+	case "":
+		conf.LoadBalancingAlgorithm = "round_robin"
+	case "round_robin", "least_outstanding_requests":
+	case "weighted_random":
+		return nil, fmt.Errorf("loadBalancingAlgorithm weighted_random needs a pulumi-aws version newer than the v5.0.0 this stack pins")
+	default:
+		return nil, fmt.Errorf("loadBalancingAlgorithm must be round_robin or least_outstanding_requests, got %q", conf.LoadBalancingAlgorithm)
+	}
+	// The pinned provider's target groups have neither setting.
+	for _, key := range []string{"loadBalancingAnomalyMitigation", "crossZoneLoadBalancing"} {
+		if cfg.Get(key) != "" {
+			return nil, fmt.Errorf("%s needs a pulumi-aws version whose lb.TargetGroup has it, newer than the v5.0.0 this stack pins", key)
+		}
+	}
+	var err error
+	if err = cfg.GetObject("traefik", &conf.Traefik); err != nil {
+		return nil, err
+	}
+	if conf.Traefik.Image == "" {
+		conf.Traefik.Image = "traefik:v2.7"
+	}
+	if conf.Traefik.version, err = parseTraefikVersion(conf.Traefik.Image, conf.Traefik.Version); err != nil {
+		return nil, err
+	}
+	switch conf.Traefik.LogLevel {
+	case "":
+		conf.Traefik.LogLevel = "ERROR"
+		if !conf.production {
+			conf.Traefik.LogLevel = "DEBUG"
+		}
+	case "DEBUG", "INFO", "WARN", "ERROR", "FATAL", "PANIC":
+	default:
+		return nil, fmt.Errorf("traefik.logLevel must be one of DEBUG, INFO, WARN, ERROR, FATAL or PANIC, got %q", conf.Traefik.LogLevel)
+	}
+	switch conf.Traefik.LogFormat {
+	case "":
+		conf.Traefik.LogFormat = "common"
+	case "common", "json":
+	default:
+		return nil, fmt.Errorf("traefik.logFormat must be common or json, got %q", conf.Traefik.LogFormat)
+	}
+	for name, p := range conf.Traefik.Plugins {
+		if p.ModuleName == "" || p.Version == "" {
+			return nil, fmt.Errorf("traefik.plugins.%s needs a moduleName and a version", name)
+		}
+	}
+	// Plugins came with v2.3.
+	if len(conf.Traefik.Plugins) > 0 && !conf.Traefik.version.atLeast(2, 3) {
+		return nil, fmt.Errorf("traefik.plugins need Traefik v2.3 or later, %s is %s", conf.Traefik.Image, conf.Traefik.version)
+	}
+	if conf.Traefik.RefreshSeconds == 0 {
+		conf.Traefik.RefreshSeconds = 15
+	}
+	for k, v := range conf.Traefik.ConstraintLabels {
+		if strings.Contains(k+v, "`") {
+			return nil, fmt.Errorf("traefik.constraintLabels: %s=%s can't contain backticks", k, v)
+		}
+		// Traefik reads labels under traefik. as configuration.
+		if strings.HasPrefix(k, "traefik.") {
+			return nil, fmt.Errorf("traefik.constraintLabels: %s is in Traefik's own label namespace", k)
+		}
+	}
+	if conf.Traefik.AutoDiscoverClusters && len(conf.Traefik.Clusters) > 0 {
+		return nil, fmt.Errorf("traefik.clusters can't be combined with traefik.autoDiscoverClusters, which discovers every cluster")
+	}
+	for _, c := range conf.Traefik.Clusters {
+		if strings.HasPrefix(c, "arn:") {
+			if !clusterArnPattern.MatchString(c) {
+				return nil, fmt.Errorf("traefik.clusters: %q is not the ARN of an ECS cluster", c)
+			}
+		} else if !clusterName.MatchString(c) {
+			return nil, fmt.Errorf("traefik.clusters: %q is not the name of an ECS cluster", c)
+		}
+	}
+	if conf.Traefik.DesiredCount == 0 {
+		conf.Traefik.DesiredCount = conf.defaultDesiredCount("traefik")
+	}
+	if conf.Traefik.DesiredCount < 0 {
+		return nil, fmt.Errorf("traefik.desiredCount must be at least 1, got %d", conf.Traefik.DesiredCount)
+	}
+	if !conf.dashboardRouted() {
+		ctx.Log.Warn("the dashboard is not routed: set traefik.api.authSecret or internalDashboard to serve it", nil)
+	}
+
+	if err := cfg.GetObject("traefikCanary", &conf.TraefikCanary); err != nil {
+		return nil, err
+	}
+	if c := conf.TraefikCanary; c != nil {
+		if c.Image == "" {
+			return nil, fmt.Errorf("traefikCanary.image is required")
+		}
+		if c.version, err = parseTraefikVersion(c.Image, c.Version); err != nil {
+			return nil, err
+		}
+		if len(conf.Traefik.Plugins) > 0 && !c.version.atLeast(2, 3) {
+			return nil, fmt.Errorf("traefik.plugins need Traefik v2.3 or later, %s is %s", c.Image, c.version)
+		}
+		if c.Weight < 0 || c.Weight > 100 {
+			return nil, fmt.Errorf("traefikCanary.weight must be between 0 and 100, got %d", c.Weight)
+		}
+	}
+	if err := validateLaunchType(cfg, conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("ephemeralStorage", &conf.EphemeralStorage); err != nil {
+		return nil, err
+	}
+	if err := validateEphemeralStorage(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("taskSizes", &conf.TaskSizes); err != nil {
+		return nil, err
+	}
+	if err := validateTaskSizes(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("healthCheckGracePeriods", &conf.HealthCheckGracePeriods); err != nil {
+		return nil, err
+	}
+	if err := validateHealthCheckGracePeriods(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("desiredCounts", &conf.DesiredCounts); err != nil {
+		return nil, err
+	}
+	if err := validateDesiredCounts(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("containers", &conf.Containers); err != nil {
+		return nil, err
+	}
+	if err := validateContainerOptions(conf); err != nil {
+		return nil, err
+	}
+	if b := conf.PermissionsBoundary; b != "" && !permissionsBoundaryPattern.MatchString(b) {
+		return nil, fmt.Errorf("permissionsBoundary must be the ARN of a managed policy, got %q", b)
+	}
+	if err := cfg.GetObject("tags", &conf.Tags); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("defaultTags", &conf.DefaultTags); err != nil {
+		return nil, err
+	}
+	if err := validateTags(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("placement", &conf.Placement); err != nil {
+		return nil, err
+	}
+	if err := validatePlacement(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("runtimePlatform", &conf.RuntimePlatform); err != nil {
+		return nil, err
+	}
+	if err := validateRuntimePlatforms(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("internalTraefik", &conf.InternalTraefik); err != nil {
+		return nil, err
+	}
+	if i := conf.InternalTraefik; i != nil {
+		if i.DesiredCount == 0 {
+			i.DesiredCount = conf.defaultDesiredCount("traefik-internal")
+		}
+		if i.DesiredCount < 0 {
+			return nil, fmt.Errorf("internalTraefik.desiredCount must be at least 1, got %d", i.DesiredCount)
+		}
+		if _, ok := conf.Traefik.ConstraintLabels[internalLabel]; ok {
+			return nil, fmt.Errorf("traefik.constraintLabels: %s tells internal apps apart", internalLabel)
+		}
+		if conf.Traefik.Hub != nil {
+			return nil, fmt.Errorf("traefik.hub can't be combined with internalTraefik, which would need a gateway of its own")
+		}
+		conf.Traefik.excludedLabels = map[string]string{internalLabel: internalLabelValue}
+	}
+	if h := conf.Traefik.Hub; h != nil {
+		if h.TokenSecret == "" {
+			return nil, fmt.Errorf("traefik.hub requires a tokenSecret")
+		}
+		images := []string{conf.Traefik.Image}
+		if conf.TraefikCanary != nil {
+			images = append(images, conf.TraefikCanary.Image)
+		}
+		for _, image := range images {
+			if !strings.Contains(image, "traefik-hub") {
+				return nil, fmt.Errorf("traefik.hub needs a Traefik Hub image like ghcr.io/traefik/traefik-hub:v3.4, not %s", image)
+			}
+		}
+	}
+	if t := conf.Traefik.Tracing; t != nil {
+		// OpenTelemetry replaced the tracing backends of v2 in v3.
+		for _, v := range conf.traefikVersions() {
+			if !v.atLeast(3, 0) {
+				return nil, fmt.Errorf("traefik.tracing needs Traefik v3 or later, not %s", v)
+			}
+		}
+		if t.SampleRate == 0 {
+			t.SampleRate = 1
+		}
+		if t.SampleRate < 0 || t.SampleRate > 1 {
+			return nil, fmt.Errorf("traefik.tracing.sampleRate must be between 0 and 1, got %g", t.SampleRate)
+		}
+	}
+
+	switch conf.HealthPort {
+	case 0:
+		conf.HealthPort = 8082
+	case 80, 8080:
+		return nil, fmt.Errorf("healthPort %d is already used by a public entrypoint", conf.HealthPort)
+	}
+	if err := cfg.GetObject("managedPrometheus", &conf.ManagedPrometheus); err != nil {
+		return nil, err
+	}
+	if conf.ManagedPrometheus != nil {
+		if err := validateManagedPrometheus(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("synthetics", &conf.Synthetics); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("managedGrafana", &conf.ManagedGrafana); err != nil {
+		return nil, err
+	}
+	if conf.ManagedGrafana != nil {
+		if err := validateManagedGrafana(conf); err != nil {
+			return nil, err
+		}
+	}
+	if m := &conf.Traefik.Metrics; m.Prometheus {
+		switch m.Port {
+		case 0:
+			m.Port = 8083
+		case 80, 443, 8080, conf.HealthPort:
+			return nil, fmt.Errorf("traefik.metrics.port %d is already used by another entrypoint", m.Port)
+		}
+		if m.Listener && !conf.InternalDashboard {
+			return nil, fmt.Errorf("traefik.metrics.listener requires internalDashboard, metrics are not published on the public load balancer")
+		}
+	}
+	if err := cfg.GetObject("entryPoints", &conf.EntryPoints); err != nil {
+		return nil, err
+	}
+	if err := validateEntryPoints(conf); err != nil {
+		return nil, err
+	}
+	if err := validateNamePrefix(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("timeouts", &conf.Timeouts); err != nil {
+		return nil, err
+	}
+	if t := conf.Timeouts; t != nil {
+		if t.Idle == 0 {
+			t.Idle = 60
+		}
+		if t.Idle < 1 || t.Idle > 4000 {
+			return nil, fmt.Errorf("timeouts.idle must be between 1 and 4000 seconds, got %d", t.Idle)
+		}
+		if t.Read < 0 || t.Write < 0 || t.ResponseHeader < 0 {
+			return nil, fmt.Errorf("timeouts must not be negative")
+		}
+	}
+	if err := cfg.GetObject("errorPages", &conf.ErrorPages); err != nil {
+		return nil, err
+	}
+	if conf.ErrorPages != nil {
+		if err := validateErrorPages(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("redirects", &conf.Redirects); err != nil {
+		return nil, err
+	}
+	for _, r := range conf.Redirects {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("forwardAuth", &conf.ForwardAuth); err != nil {
+		return nil, err
+	}
+	if conf.ForwardAuth != nil {
+		if err := validateForwardAuth(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("offboard", &conf.Offboard); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("ipAllowList", &conf.IPAllowList); err != nil {
+		return nil, err
+	}
+	for _, r := range conf.IPAllowList {
+		if _, _, err := net.ParseCIDR(r); err != nil && net.ParseIP(r) == nil {
+			return nil, fmt.Errorf("ipAllowList: %q is neither an IP address nor a CIDR range", r)
+		}
+	}
+	if err := cfg.GetObject("imageRefresh", &conf.ImageRefresh); err != nil {
+		return nil, err
+	}
+	if conf.ImageRefresh.Schedule == "" {
+		conf.ImageRefresh.Schedule = "cron(0 3 * * ? *)"
+	}
+	if err := cfg.GetObject("serviceDiscovery", &conf.ServiceDiscovery); err != nil {
+		return nil, err
+	}
+	if conf.ServiceDiscovery != nil {
+		if err := validateServiceDiscovery(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("logs", &conf.Logs); err != nil {
+		return nil, err
+	}
+	if err := validateLogs(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("fireLens", &conf.FireLens); err != nil {
+		return nil, err
+	}
+	if conf.FireLens != nil {
+		if err := validateFireLens(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("secrets", &conf.Secrets); err != nil {
+		return nil, err
+	}
+	if err := validateSecrets(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("parameters", &conf.Parameters); err != nil {
+		return nil, err
+	}
+	if err := validateParameters(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("assumeRole", &conf.AssumeRole); err != nil {
+		return nil, err
+	}
+	if conf.AssumeRole != nil {
+		if err := validateAssumeRole(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("iam", &conf.IAM); err != nil {
+		return nil, err
+	}
+	if err := validateIAM(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("githubActions", &conf.GitHubActions); err != nil {
+		return nil, err
+	}
+	if conf.GitHubActions != nil {
+		if err := validateGitHubActions(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("kms", &conf.KMS); err != nil {
+		return nil, err
+	}
+	if conf.KMS != nil {
+		if err := validateKMS(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("executeCommand", &conf.ExecuteCommand); err != nil {
+		return nil, err
+	}
+	if e := conf.ExecuteCommand; e != nil && e.LogRetention == 0 {
+		e.LogRetention = conf.Logs.Retention
+	}
+	// Service Connect is configured on the ECS service, which the pinned
+	// provider can't do yet.
+	if cfg.Get("serviceConnect") != "" {
+		return nil, fmt.Errorf("serviceConnect needs a pulumi-aws version whose ecs.Service has serviceConnectConfiguration, newer than the v5.0.0 this stack pins")
+	}
+	if err := cfg.GetObject("cluster", &conf.Cluster); err != nil {
+		return nil, err
+	}
+	if err := validateCluster(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("deployment", &conf.Deployment); err != nil {
+		return nil, err
+	}
+	if err := validateDeployment(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("scheduledScaling", &conf.ScheduledScaling); err != nil {
+		return nil, err
+	}
+	if err := validateScheduledScaling(conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.GetObject("slos", &conf.SLOs); err != nil {
+		return nil, err
+	}
+	for app, slo := range conf.SLOs {
+		for _, target := range []float64{slo.Availability, slo.Latency} {
+			if target < 0 || target >= 100 {
+				return nil, fmt.Errorf("slos.%s: targets must be percentages below 100, got %g", app, target)
+			}
+		}
+		if slo.Latency > 0 && slo.LatencyThresholdMs <= 0 {
+			return nil, fmt.Errorf("slos.%s: a latency target needs latencyThresholdMs", app)
+		}
+	}
+	// SLOs are measured from the access logs.
+	if len(conf.SLOs) > 0 {
+		if conf.FireLens != nil && conf.FireLens.AccessLog {
+			return nil, fmt.Errorf("fireLens.accessLog cannot be used with slos, which are measured from the access log group")
+		}
+		conf.Traefik.AccessLog = true
+		if err := sloAccessLogFields(conf.Traefik); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateAccessLog(conf.Traefik); err != nil {
+		return nil, err
+	}
+	if conf.Traefik.AccessLogRetention == 0 {
+		conf.Traefik.AccessLogRetention = conf.Logs.Retention
+	}
+	if err := cfg.GetObject("deregistrationDelay", &conf.DeregistrationDelay); err != nil {
+		return nil, err
+	}
+	if conf.DeregistrationDelay < 0 || conf.DeregistrationDelay > 3600 {
+		return nil, fmt.Errorf("deregistrationDelay must be between 0 and 3600 seconds, got %d", conf.DeregistrationDelay)
+	}
+	if conf.SlowStart != 0 && (conf.SlowStart < 30 || conf.SlowStart > 900) {
+		return nil, fmt.Errorf("slowStart must be 0 or between 30 and 900 seconds, got %d", conf.SlowStart)
+	}
+	if conf.AnomalyBandWidth == 0 {
+		conf.AnomalyBandWidth = 2
+	}
+	if err := cfg.GetObject("alarms", &conf.Alarms); err != nil {
+		return nil, err
+	}
+	if conf.Alarms != nil {
+		if err := validateAlarms(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("alerts", &conf.Alerts); err != nil {
+		return nil, err
+	}
+	if conf.Alerts != nil {
+		if err := validateAlerts(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.GetObject("ecsEvents", &conf.ECSEvents); err != nil {
+		return nil, err
+	}
+	if conf.ECSEvents != nil {
+		if err := validateECSEvents(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	return conf, nil
+}
+
+// dashboardScheme is the scheme of the dashboard listener, which serves the
+// default certificate when the stack has one.
+func (c *stackConfig) dashboardScheme() string {
+	if len(c.CertificateArns) > 0 {
+		return "https"
+	}
+	return "http"
+}
+
+var entryPointName = regexp.MustCompile(`^[a-z][a-z0-9]{0,23}$`)
+
+var (
+	clusterName       = regexp.MustCompile(`^[A-Za-z0-9_-]{1,255}$`)
+	clusterArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:ecs:[a-z0-9-]+:\d{12}:cluster/[A-Za-z0-9_-]{1,255}$`)
+)
+
+// validateTargetGroups checks that Traefik has at most three target groups
+// besides the web and dashboard ones, as ECS registers a service with at
+// most five: the gRPC one, ACME's, the metrics listener's and those of the
+// custom entrypoints.
+func validateTargetGroups(apps []*App, conf *stackConfig) error {
+	targets := len(conf.EntryPoints)
+	if usesGRPC(apps) && len(conf.CertificateArns) > 0 {
+		targets++
+	}
+	if conf.ACME != nil {
+		targets++
+	}
+	if conf.Traefik.Metrics.Listener {
+		targets++
+	}
+	if targets > 3 {
+		return fmt.Errorf("entryPoints: ECS can't register Traefik with more than three extra target groups, counting gRPC, ACME and the metrics listener")
+	}
+	return nil
+}
+
+// validateEntryPoints checks that the custom entrypoints have valid names and
+// protocols and don't clash with each other or the built-in ones.
+func validateEntryPoints(conf *stackConfig) error {
+	names := map[string]bool{"web": true, "traefik": true, "health": true, "metrics": true, "websecure": true, "auth": true}
+	ports := map[int]string{80: "web", 8080: "traefik", conf.HealthPort: "health"}
+	if conf.Traefik.Metrics.Prometheus {
+		ports[conf.Traefik.Metrics.Port] = "metrics"
+	}
+	if len(conf.CertificateArns) > 0 || conf.ACME != nil {
+		ports[443] = "https"
+	}
+
+	for i := range conf.EntryPoints {
+		ep := &conf.EntryPoints[i]
+		if !entryPointName.MatchString(ep.Name) {
+			return fmt.Errorf("entryPoints: %q must be lowercase letters and digits, at most 24 characters", ep.Name)
+		}
+		if names[ep.Name] {
+			return fmt.Errorf("entryPoints: the name %s is already taken", ep.Name)
+		}
+		names[ep.Name] = true
+
+		if ep.Port <= 0 || ep.Port > 65535 {
+			return fmt.Errorf("entryPoints.%s: invalid port %d", ep.Name, ep.Port)
+		}
+		if other, ok := ports[ep.Port]; ok {
+			return fmt.Errorf("entryPoints.%s: port %d is already used by %s", ep.Name, ep.Port, other)
+		}
+		ports[ep.Port] = ep.Name
+
+		switch ep.Protocol {
+		case "":
+			ep.Protocol = "http"
+		case "http", "tcp", "udp":
+		default:
+			return fmt.Errorf("entryPoints.%s: protocol must be http, tcp or udp, got %q", ep.Name, ep.Protocol)
+		}
+	}
+	return nil
+}
+
+// logRetentionDays are the retention periods CloudWatch Logs accepts.
+var logRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1827, 3653}
+
+func validateAccessLog(opts TraefikOptions) error {
+	if r := opts.AccessLogRetention; r != 0 {
+		if !validLogRetention(r) {
+			return fmt.Errorf("traefik.accessLogRetention must be one of %v days, got %d", logRetentionDays, r)
+		}
+	}
+
+	modes := map[string]string{
+		"traefik.accessLogFields.defaultMode":         opts.AccessLogFields.DefaultMode,
+		"traefik.accessLogFields.headers.defaultMode": opts.AccessLogFields.Headers.DefaultMode,
+	}
+	for name, mode := range opts.AccessLogFields.Names {
+		modes["traefik.accessLogFields.names."+name] = mode
+	}
+	for name, mode := range opts.AccessLogFields.Headers.Names {
+		modes["traefik.accessLogFields.headers.names."+name] = mode
+	}
+	for key, mode := range modes {
+		switch mode {
+		case "", "keep", "drop", "redact":
+		default:
+			return fmt.Errorf("%s must be keep, drop or redact, got %q", key, mode)
+		}
+	}
+	return nil
+}
+
+// sloAccessLogFields rejects access log settings that would skew the SLO
+// metrics, which need every request and the fields they are computed from.
+func sloAccessLogFields(opts TraefikOptions) error {
+	if f := opts.AccessLogFilters; len(f.StatusCodes) > 0 || f.RetryAttempts || f.MinDuration != "" {
+		return fmt.Errorf("traefik.accessLogFilters cannot be used with slos, which need every request logged")
+	}
+	for _, field := range []string{"DownstreamStatus", "RouterName", "Duration"} {
+		mode, ok := opts.AccessLogFields.Names[field]
+		if !ok {
+			mode = opts.AccessLogFields.DefaultMode
+		}
+		if mode == "drop" || mode == "redact" {
+			return fmt.Errorf("slos need the %s access log field, which traefik.accessLogFields would %s", field, mode)
+		}
+	}
+	return nil
+}
+
+// productionStack reports whether stack is a production environment: prod,
+// production, or a name that starts or ends with either, such as prod-eu.
+func productionStack(stack string) bool {
+	for _, env := range []string{"prod", "production"} {
+		if stack == env || strings.HasPrefix(stack, env+"-") || strings.HasSuffix(stack, "-"+env) {
+			return true
+		}
+	}
+	return false
+}
+
+// dashboardRouted reports whether the dashboard is served at all. It is never
+// served on the public load balancer without authentication.
+func (c *stackConfig) dashboardRouted() bool {
+	return c.InternalDashboard || c.Traefik.API.AuthSecret != ""
+}
+
+// offboarding reports whether app is being offboarded.
+func (c *stackConfig) offboarding(app string) bool {
+	for _, name := range c.Offboard {
+		if name == app {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTLSOptions checks opts against the versions and cipher suites Go,
+// and therefore Traefik, knows.
+func validateTLSOptions(opts *TLSOptions) error {
+	switch opts.MinVersion {
+	case "", "VersionTLS10", "VersionTLS11", "VersionTLS12":
+	case "VersionTLS13":
+		// Go doesn't let TLS 1.3 cipher suites be configured.
+		if len(opts.CipherSuites) > 0 {
+			return fmt.Errorf("cipherSuites don't apply to TLS 1.3, drop them or lower minVersion")
+		}
+	default:
+		return fmt.Errorf("minVersion must be VersionTLS10, VersionTLS11, VersionTLS12 or VersionTLS13, got %q", opts.MinVersion)
+	}
+
+	known := map[string]bool{}
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[s.Name] = true
+	}
+	for _, name := range opts.CipherSuites {
+		if !known[name] {
+			return fmt.Errorf("unknown cipher suite %q", name)
+		}
+	}
+	return nil
+}
+
+// constraints is the ECS provider's constraint expression that only matches
+// containers with all the constraint labels and none of the excluded ones,
+// or "" without any.
+func (o *TraefikOptions) constraints() string {
+	var terms []string
+	for k, v := range o.ConstraintLabels {
+		terms = append(terms, fmt.Sprintf("Label(`%s`,`%s`)", k, v))
+	}
+	for k, v := range o.excludedLabels {
+		terms = append(terms, fmt.Sprintf("!Label(`%s`,`%s`)", k, v))
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, " && ")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEntryPoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		entryPoints []EntryPointOptions
+		certificate bool
+		err         string
+	}{
+		{
+			name:        "none",
+			entryPoints: nil,
+		},
+		{
+			name:        "tcp and udp",
+			entryPoints: []EntryPointOptions{{Name: "postgres", Port: 5432, Protocol: "tcp"}, {Name: "dns", Port: 53, Protocol: "udp"}},
+		},
+		{
+			name:        "invalid name",
+			entryPoints: []EntryPointOptions{{Name: "My-Entrypoint", Port: 8443}},
+			err:         "must be lowercase letters and digits",
+		},
+		{
+			name:        "built-in name",
+			entryPoints: []EntryPointOptions{{Name: "websecure", Port: 8443}},
+			err:         "the name websecure is already taken",
+		},
+		{
+			name:        "duplicate name",
+			entryPoints: []EntryPointOptions{{Name: "grpc", Port: 9000}, {Name: "grpc", Port: 9001}},
+			err:         "the name grpc is already taken",
+		},
+		{
+			name:        "invalid port",
+			entryPoints: []EntryPointOptions{{Name: "big", Port: 70000}},
+			err:         "invalid port 70000",
+		},
+		{
+			name:        "dashboard port",
+			entryPoints: []EntryPointOptions{{Name: "admin", Port: 8080}},
+			err:         "port 8080 is already used by traefik",
+		},
+		{
+			name:        "https port with a certificate",
+			entryPoints: []EntryPointOptions{{Name: "tls", Port: 443}},
+			certificate: true,
+			err:         "port 443 is already used by https",
+		},
+		{
+			name:        "https port without a certificate",
+			entryPoints: []EntryPointOptions{{Name: "tls", Port: 443, Protocol: "tcp"}},
+		},
+		{
+			name:        "shared port",
+			entryPoints: []EntryPointOptions{{Name: "one", Port: 9000}, {Name: "two", Port: 9000}},
+			err:         "port 9000 is already used by one",
+		},
+		{
+			name:        "invalid protocol",
+			entryPoints: []EntryPointOptions{{Name: "quic", Port: 4433, Protocol: "quic"}},
+			err:         `protocol must be http, tcp or udp, got "quic"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &stackConfig{HealthPort: 8082, EntryPoints: tt.entryPoints}
+			if tt.certificate {
+				conf.CertificateArns = []string{"arn:aws:acm:eu-west-1:123456789012:certificate/abc"}
+			}
+			checkErr(t, validateEntryPoints(conf), tt.err)
+		})
+	}
+}
+
+func TestValidateEntryPointsDefaultsToHTTP(t *testing.T) {
+	conf := &stackConfig{HealthPort: 8082, EntryPoints: []EntryPointOptions{{Name: "alt", Port: 8000}}}
+	if err := validateEntryPoints(conf); err != nil {
+		t.Fatal(err)
+	}
+	if got := conf.EntryPoints[0].Protocol; got != "http" {
+		t.Errorf("protocol = %q, want http", got)
+	}
+}
+
+func TestValidateTLSOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts TLSOptions
+		err  string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "TLS 1.2 with cipher suites",
+			opts: TLSOptions{MinVersion: "VersionTLS12", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		},
+		{
+			name: "TLS 1.3",
+			opts: TLSOptions{MinVersion: "VersionTLS13"},
+		},
+		{
+			name: "TLS 1.3 with cipher suites",
+			opts: TLSOptions{MinVersion: "VersionTLS13", CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			err:  "cipherSuites don't apply to TLS 1.3",
+		},
+		{
+			name: "unknown version",
+			opts: TLSOptions{MinVersion: "TLSv1.2"},
+			err:  `got "TLSv1.2"`,
+		},
+		{
+			name: "insecure cipher suite",
+			opts: TLSOptions{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		},
+		{
+			name: "unknown cipher suite",
+			opts: TLSOptions{CipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256"}},
+			err:  `unknown cipher suite "ECDHE-RSA-AES128-GCM-SHA256"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErr(t, validateTLSOptions(&tt.opts), tt.err)
+		})
+	}
+}
+
+func TestValidateAccessLog(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(*TraefikOptions)
+		err  string
+	}{
+		{
+			name: "defaults",
+			opts: func(*TraefikOptions) {},
+		},
+		{
+			name: "retention",
+			opts: func(o *TraefikOptions) { o.AccessLogRetention = 14 },
+		},
+		{
+			name: "invalid retention",
+			opts: func(o *TraefikOptions) { o.AccessLogRetention = 10 },
+			err:  "traefik.accessLogRetention must be one of",
+		},
+		{
+			name: "modes",
+			opts: func(o *TraefikOptions) {
+				o.AccessLogFields.DefaultMode = "keep"
+				o.AccessLogFields.Names = map[string]string{"ClientUsername": "drop"}
+				o.AccessLogFields.Headers.DefaultMode = "drop"
+				o.AccessLogFields.Headers.Names = map[string]string{"Authorization": "redact"}
+			},
+		},
+		{
+			name: "invalid default mode",
+			opts: func(o *TraefikOptions) { o.AccessLogFields.DefaultMode = "hide" },
+			err:  `traefik.accessLogFields.defaultMode must be keep, drop or redact, got "hide"`,
+		},
+		{
+			name: "invalid field mode",
+			opts: func(o *TraefikOptions) { o.AccessLogFields.Names = map[string]string{"ClientHost": "mask"} },
+			err:  "traefik.accessLogFields.names.ClientHost must be keep, drop or redact",
+		},
+		{
+			name: "invalid header mode",
+			opts: func(o *TraefikOptions) {
+				o.AccessLogFields.Headers.Names = map[string]string{"Cookie": "Redact"}
+			},
+			err: "traefik.accessLogFields.headers.names.Cookie must be keep, drop or redact",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts TraefikOptions
+			tt.opts(&opts)
+			checkErr(t, validateAccessLog(opts), tt.err)
+		})
+	}
+}
+
+// checkErr fails t unless err contains want, or is nil for an empty want.
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Errorf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Errorf("no error, want one containing %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Errorf("error %q doesn't contain %q", err, want)
+	}
+}
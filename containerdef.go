@@ -0,0 +1,89 @@
+package main
+
+import "encoding/json"
+
+// containerDefinition is a container of a task definition, in the JSON ECS
+// reads the container definitions from. Fields left empty are left out, and
+// ECS picks their defaults.
+type containerDefinition struct {
+	Name                   string                 `json:"name"`
+	Image                  string                 `json:"image"`
+	Essential              bool                   `json:"essential"`
+	EntryPoint             []string               `json:"entryPoint,omitempty"`
+	Command                []string               `json:"command,omitempty"`
+	PortMappings           []portMapping          `json:"portMappings,omitempty"`
+	Environment            []keyValuePair         `json:"environment,omitempty"`
+	Secrets                []secret               `json:"secrets,omitempty"`
+	MountPoints            []mountPoint           `json:"mountPoints,omitempty"`
+	DockerLabels           map[string]string      `json:"dockerLabels,omitempty"`
+	LogConfiguration       *logConfiguration      `json:"logConfiguration,omitempty"`
+	ResourceRequirements   []resourceRequirement  `json:"resourceRequirements,omitempty"`
+	MemoryReservation      int                    `json:"memoryReservation,omitempty"`
+	FirelensConfiguration  *firelensConfiguration `json:"firelensConfiguration,omitempty"`
+	StopTimeout            int                    `json:"stopTimeout,omitempty"`
+	Ulimits                []Ulimit               `json:"ulimits,omitempty"`
+	LinuxParameters        *linuxParameters       `json:"linuxParameters,omitempty"`
+	ReadonlyRootFilesystem bool                   `json:"readonlyRootFilesystem,omitempty"`
+	HealthCheck            *HealthCheck           `json:"healthCheck,omitempty"`
+}
+
+// portMapping publishes a container port. With awsvpc networking, the host
+// port is the container port.
+type portMapping struct {
+	ContainerPort int    `json:"containerPort"`
+	HostPort      int    `json:"hostPort"`
+	Protocol      string `json:"protocol"`
+}
+
+// keyValuePair is an environment variable.
+type keyValuePair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// secret is an environment variable set from a Secrets Manager secret or an
+// SSM parameter, by ARN.
+type secret struct {
+	Name      string `json:"name"`
+	ValueFrom string `json:"valueFrom"`
+}
+
+// mountPoint mounts a volume of the task into the container.
+type mountPoint struct {
+	SourceVolume  string `json:"sourceVolume"`
+	ContainerPath string `json:"containerPath"`
+	ReadOnly      bool   `json:"readOnly"`
+}
+
+// logConfiguration is the log driver of a container and its options.
+type logConfiguration struct {
+	LogDriver string            `json:"logDriver"`
+	Options   map[string]string `json:"options"`
+}
+
+// resourceRequirement reserves GPUs of the instance for the container.
+type resourceRequirement struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// firelensConfiguration makes the container the log router of its task.
+type firelensConfiguration struct {
+	Type string `json:"type"`
+}
+
+// linuxParameters are the Linux-specific options of a container.
+type linuxParameters struct {
+	InitProcessEnabled bool `json:"initProcessEnabled"`
+}
+
+// tcpPort maps port over tcp.
+func tcpPort(port int) portMapping {
+	return portMapping{ContainerPort: port, HostPort: port, Protocol: "tcp"}
+}
+
+// marshalContainers renders the container definitions of a task definition.
+func marshalContainers(defs []containerDefinition) (string, error) {
+	b, err := json.Marshal(defs)
+	return string(b), err
+}
@@ -0,0 +1,161 @@
+package main
+
+import "fmt"
+
+// ContainerOptions tune how ECS runs and stops a container.
+type ContainerOptions struct {
+	// StopTimeout is how many seconds the container gets to exit after
+	// SIGTERM before it is killed, 30 by default and at most 120 on
+	// Fargate.
+	StopTimeout int `json:"stopTimeout"`
+	// Ulimits override the resource limits of the container's processes.
+	Ulimits []Ulimit `json:"ulimits"`
+	// InitProcessEnabled runs an init process as PID 1, which forwards
+	// signals and reaps zombie processes.
+	InitProcessEnabled bool `json:"initProcessEnabled"`
+	// ReadonlyRootFilesystem mounts the container's root file system
+	// read-only. Only its volumes remain writable.
+	ReadonlyRootFilesystem bool `json:"readonlyRootFilesystem"`
+	// HealthCheck is the command ECS runs in the container to tell whether
+	// it is healthy.
+	HealthCheck *HealthCheck `json:"healthCheck"`
+}
+
+// HealthCheck is a container health check. The durations are in seconds,
+// and ECS picks defaults for those left at zero.
+type HealthCheck struct {
+	// Command is run with CMD, or with CMD-SHELL in the container's shell,
+	// e.g. ["CMD-SHELL", "curl -f http://localhost/ || exit 1"].
+	Command     []string `json:"command"`
+	Interval    int      `json:"interval,omitempty"`
+	Timeout     int      `json:"timeout,omitempty"`
+	Retries     int      `json:"retries,omitempty"`
+	StartPeriod int      `json:"startPeriod,omitempty"`
+}
+
+// validate checks the health check of key.
+func (h *HealthCheck) validate(key string) error {
+	if len(h.Command) < 2 || (h.Command[0] != "CMD" && h.Command[0] != "CMD-SHELL") {
+		return fmt.Errorf("%s.healthCheck.command must start with CMD or CMD-SHELL, followed by the command", key)
+	}
+	if h.Interval != 0 && (h.Interval < 5 || h.Interval > 300) {
+		return fmt.Errorf("%s.healthCheck.interval must be between 5 and 300 seconds, got %d", key, h.Interval)
+	}
+	if h.Timeout != 0 && (h.Timeout < 2 || h.Timeout > 60) {
+		return fmt.Errorf("%s.healthCheck.timeout must be between 2 and 60 seconds, got %d", key, h.Timeout)
+	}
+	if h.Retries != 0 && (h.Retries < 1 || h.Retries > 10) {
+		return fmt.Errorf("%s.healthCheck.retries must be between 1 and 10, got %d", key, h.Retries)
+	}
+	if h.StartPeriod < 0 || h.StartPeriod > 300 {
+		return fmt.Errorf("%s.healthCheck.startPeriod must be between 0 and 300 seconds, got %d", key, h.StartPeriod)
+	}
+	return nil
+}
+
+// traefikHealthCheck pings Traefik on its health entrypoint.
+var traefikHealthCheck = &HealthCheck{
+	Command: []string{"CMD", "traefik", "healthcheck", "--ping", "--configFile=" + staticConfigPath},
+}
+
+// Ulimit is a resource limit, such as nofile, of a container's processes.
+type Ulimit struct {
+	Name      string `json:"name"`
+	SoftLimit int    `json:"softLimit"`
+	HardLimit int    `json:"hardLimit"`
+}
+
+// ulimitNames are the resource limits ECS can set.
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true,
+	"memlock": true, "msgqueue": true, "nice": true, "nofile": true, "nproc": true,
+	"rss": true, "rtprio": true, "rttime": true, "sigpending": true, "stack": true,
+}
+
+// validate checks the options of key.
+func (o ContainerOptions) validate(key string, conf *stackConfig) error {
+	if o.StopTimeout < 0 {
+		return fmt.Errorf("%s.stopTimeout must not be negative, got %d", key, o.StopTimeout)
+	}
+	if conf.LaunchType == "FARGATE" && o.StopTimeout > 120 {
+		return fmt.Errorf("%s.stopTimeout is at most 120 seconds on Fargate, got %d", key, o.StopTimeout)
+	}
+	for _, u := range o.Ulimits {
+		if !ulimitNames[u.Name] {
+			return fmt.Errorf("%s.ulimits: unknown limit %q", key, u.Name)
+		}
+		if u.SoftLimit < 0 || u.SoftLimit > u.HardLimit {
+			return fmt.Errorf("%s.ulimits: the softLimit of %s must be between 0 and its hardLimit, got %d and %d", key, u.Name, u.SoftLimit, u.HardLimit)
+		}
+	}
+	if o.HealthCheck != nil {
+		return o.HealthCheck.validate(key)
+	}
+	return nil
+}
+
+// apply sets the fields of def o tunes.
+func (o ContainerOptions) apply(def *containerDefinition) {
+	def.StopTimeout = o.StopTimeout
+	def.Ulimits = o.Ulimits
+	if o.InitProcessEnabled {
+		def.LinuxParameters = &linuxParameters{InitProcessEnabled: true}
+	}
+	def.ReadonlyRootFilesystem = o.ReadonlyRootFilesystem
+	def.HealthCheck = o.HealthCheck
+}
+
+// validateContainerOptions checks the options of the stack's own containers.
+// Traefik writes its static configuration at start, so its root file system
+// stays writable.
+func validateContainerOptions(conf *stackConfig) error {
+	for name, o := range conf.Containers {
+		switch name {
+		case "traefik", "certs-dumper", "error-pages", "otel-collector", "log-router", forwardAuthService:
+		default:
+			return fmt.Errorf("containers: unknown container %s, apps take theirs with WithContainerOptions", name)
+		}
+		if err := o.validate("containers."+name, conf); err != nil {
+			return err
+		}
+		if name == "traefik" && o.ReadonlyRootFilesystem {
+			return fmt.Errorf("containers.traefik: traefik writes its configuration to its root file system")
+		}
+	}
+	return nil
+}
+
+// traefikContainerOptions are the options of the Traefik containers, which
+// check their health with traefik healthcheck unless given a health check.
+func (c *stackConfig) traefikContainerOptions() ContainerOptions {
+	o := c.Containers["traefik"]
+	if o.HealthCheck == nil {
+		o.HealthCheck = traefikHealthCheck
+	}
+	return o
+}
+
+// withContainerOptions adds the options of the containers of defs to them.
+func (c *stackConfig) withContainerOptions(defs []containerDefinition) []containerDefinition {
+	for i := range defs {
+		c.Containers[defs[i].Name].apply(&defs[i])
+	}
+	return defs
+}
+
+// WithContainerOptions runs the app's containers, and those of its canary,
+// with o.
+func (a *App) WithContainerOptions(o ContainerOptions) *App {
+	a.containerOptions = o
+	return a
+}
+
+// validateAppContainerOptions checks the container options of apps.
+func validateAppContainerOptions(apps []*App, conf *stackConfig) error {
+	for _, app := range apps {
+		if err := app.containerOptions.validate("app "+app.Name, conf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
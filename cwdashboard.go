@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// awsNameInvalid matches the characters CloudWatch doesn't accept in the
+// names of dashboards, nor AWS Chatbot in those of channel configurations,
+// nor Grafana in those of workspaces.
+var awsNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// dashboardWidget is a metric widget of a CloudWatch dashboard body.
+type dashboardWidget struct {
+	Type       string                 `json:"type"`
+	X          int                    `json:"x"`
+	Y          int                    `json:"y"`
+	Width      int                    `json:"width"`
+	Height     int                    `json:"height"`
+	Properties dashboardWidgetMetrics `json:"properties"`
+}
+
+// dashboardWidgetMetrics are the properties of a metric widget. Every
+// metric is a namespace, a name, dimension name and value pairs, and the
+// rendering options of the metric last.
+type dashboardWidgetMetrics struct {
+	Title   string          `json:"title"`
+	Region  string          `json:"region"`
+	View    string          `json:"view"`
+	Period  int             `json:"period"`
+	Stat    string          `json:"stat"`
+	Metrics [][]interface{} `json:"metrics"`
+}
+
+// metric is a metric of a widget with its dimensions, followed by stat and
+// label.
+func metric(namespace, name string, dimensions []string, stat, label string) []interface{} {
+	m := []interface{}{namespace, name}
+	for _, d := range dimensions {
+		m = append(m, d)
+	}
+	return append(m, map[string]string{"stat": stat, "label": label})
+}
+
+// dashboardBody lays out the widgets of the stack's dashboard, two a row:
+// the requests, 5xx responses and latency of loadBalancer, the health of
+// targetGroups, and the CPU, memory and running tasks of services.
+func dashboardBody(region, loadBalancer string, targetGroups map[string][2]string, cluster string, services []string) (string, error) {
+	lb := []string{"LoadBalancer", loadBalancer}
+	const alb = "AWS/ApplicationELB"
+
+	var names []string
+	for name := range targetGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var health [][]interface{}
+	for _, name := range names {
+		tg := targetGroups[name]
+		dimensions := []string{"TargetGroup", tg[1], "LoadBalancer", tg[0]}
+		health = append(health,
+			metric(alb, "HealthyHostCount", dimensions, "Minimum", name+" healthy"),
+			metric(alb, "UnHealthyHostCount", dimensions, "Maximum", name+" unhealthy"),
+		)
+	}
+
+	var cpu, memory, tasks [][]interface{}
+	for _, service := range services {
+		dimensions := []string{"ClusterName", cluster, "ServiceName", service}
+		cpu = append(cpu, metric("AWS/ECS", "CPUUtilization", dimensions, "Average", service))
+		memory = append(memory, metric("AWS/ECS", "MemoryUtilization", dimensions, "Average", service))
+		tasks = append(tasks, metric("ECS/ContainerInsights", "RunningTaskCount", dimensions, "Average", service))
+	}
+
+	widgets := []dashboardWidgetMetrics{
+		{Title: "Requests", Stat: "Sum", Metrics: [][]interface{}{
+			metric(alb, "RequestCount", lb, "Sum", "requests"),
+		}},
+		{Title: "5xx responses", Stat: "Sum", Metrics: [][]interface{}{
+			metric(alb, "HTTPCode_ELB_5XX_Count", lb, "Sum", "load balancer"),
+			metric(alb, "HTTPCode_Target_5XX_Count", lb, "Sum", "targets"),
+		}},
+		{Title: "Target response time", Stat: "p90", Metrics: [][]interface{}{
+			metric(alb, "TargetResponseTime", lb, "p50", "p50"),
+			metric(alb, "TargetResponseTime", lb, "p90", "p90"),
+			metric(alb, "TargetResponseTime", lb, "p99", "p99"),
+		}},
+		{Title: "Target health", Stat: "Minimum", Metrics: health},
+		{Title: "Service CPU utilization", Stat: "Average", Metrics: cpu},
+		{Title: "Service memory utilization", Stat: "Average", Metrics: memory},
+		{Title: "Running tasks", Stat: "Average", Metrics: tasks},
+	}
+
+	body := struct {
+		Widgets []dashboardWidget `json:"widgets"`
+	}{}
+	for i, w := range widgets {
+		w.Region, w.View, w.Period = region, "timeSeries", 60
+		body.Widgets = append(body.Widgets, dashboardWidget{
+			Type:       "metric",
+			X:          i % 2 * 12,
+			Y:          i / 2 * 6,
+			Width:      12,
+			Height:     6,
+			Properties: w,
+		})
+	}
+	b, err := json.Marshal(body)
+	return string(b), err
+}
+
+// createDashboard creates the CloudWatch dashboard of the stack, and exports
+// its URL.
+func createDashboard(
+	ctx *pulumi.Context,
+	region string,
+	loadBalancer *elb.LoadBalancer,
+	targetGroups []lbTargetGroup,
+	cluster *ecs.Cluster,
+	services map[string]*ecs.Service,
+) error {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The ARN suffixes of the target groups and their load balancers follow
+	// the others.
+	args := []interface{}{loadBalancer.ArnSuffix, cluster.Name}
+	for _, tg := range targetGroups {
+		args = append(args, tg.loadBalancer.ArnSuffix, tg.targetGroup.ArnSuffix)
+	}
+	body := pulumi.All(args...).ApplyT(func(args []interface{}) (string, error) {
+		lb, clusterName := args[0].(string), args[1].(string)
+		suffixes := map[string][2]string{}
+		for i, tg := range targetGroups {
+			suffixes[tg.name] = [2]string{args[2+2*i].(string), args[3+2*i].(string)}
+		}
+		return dashboardBody(region, lb, suffixes, clusterName, names)
+	}).(pulumi.StringOutput)
+
+	name := awsNameInvalid.ReplaceAllString(fmt.Sprintf("%s-%s", ctx.Project(), ctx.Stack()), "-")
+	dashboard, err := cloudwatch.NewDashboard(ctx, "dashboard", &cloudwatch.DashboardArgs{
+		DashboardName: pulumi.String(name),
+		DashboardBody: body,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.Export("cloudwatchDashboardUrl", pulumi.Sprintf("https://%s.console.aws.amazon.com/cloudwatch/home?region=%s#dashboards:name=%s",
+		region, region, dashboard.DashboardName))
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/secretsmanager"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// dashboardAPIPaths are the API endpoints the dashboard UI reads from.
+var dashboardAPIPaths = []string{
+	"/api/overview",
+	"/api/version",
+	"/api/entrypoints",
+}
+
+var dashboardAPIPrefixes = []string{
+	"/api/entrypoints/",
+	"/api/http/",
+	"/api/tcp/",
+	"/api/udp/",
+}
+
+// anyOf matches any of args with matcher. Traefik v3 matchers only take a
+// single argument, so this is the syntax that works for both v2 and v3.
+func anyOf(matcher string, args []string) []string {
+	rules := make([]string, len(args))
+	for i, arg := range args {
+		rules[i] = matcher + "(`" + arg + "`)"
+	}
+	return rules
+}
+
+// dashboardRule matches only the parts of the Traefik API that api allows.
+// Everything else, notably /api/rawdata with the full dynamic configuration
+// and the /debug endpoints, is left unrouted.
+func dashboardRule(api APIOptions) string {
+	paths := dashboardAPIPaths
+	prefixes := dashboardAPIPrefixes
+	if !api.DisableDashboard {
+		prefixes = append([]string{"/dashboard/"}, prefixes...)
+	}
+	if api.RawData {
+		paths = append(paths, "/api/rawdata")
+	}
+	if api.Debug {
+		prefixes = append(prefixes, "/debug/")
+	}
+
+	return strings.Join(append(anyOf("Path", paths), anyOf("PathPrefix", prefixes)...), " || ")
+}
+
+// dashboardUsers reads the htpasswd file stored in the Secrets Manager secret
+// secretID and turns it into the user list of a basicauth middleware. The
+// result is a Pulumi secret, so the hashes are encrypted in the state.
+func dashboardUsers(ctx *pulumi.Context, secretID string, conf *stackConfig) (pulumi.StringOutput, error) {
+	if secretID == "" {
+		return pulumi.String("").ToStringOutput(), nil
+	}
+
+	secret, err := secretsmanager.LookupSecretVersion(ctx, &secretsmanager.LookupSecretVersionArgs{
+		SecretId: secretID,
+	}, conf.invokeOptions()...)
+	if err != nil {
+		return pulumi.StringOutput{}, fmt.Errorf("reading dashboard users from %s: %w", secretID, err)
+	}
+
+	var users []string
+	for _, line := range strings.Split(secret.SecretString, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			users = append(users, line)
+		}
+	}
+	if len(users) == 0 {
+		return pulumi.StringOutput{}, fmt.Errorf("secret %s holds no dashboard users", secretID)
+	}
+
+	return pulumi.ToSecret(pulumi.String(strings.Join(users, ","))).(pulumi.StringOutput), nil
+}
+
+// dashboardLabels are the docker labels of the Traefik container that route
+// its own entrypoint on port 8080 to the internal API service, behind basic
+// auth when there are users. Without a router the entrypoint serves nothing.
+func dashboardLabels(api APIOptions, routed bool, users string) map[string]string {
+	if !routed {
+		return map[string]string{"traefik.enable": "false"}
+	}
+
+	labels := map[string]string{
+		"traefik.enable": "true",
+		"traefik.http.routers.dashboard.entrypoints": "traefik",
+		"traefik.http.routers.dashboard.rule":        dashboardRule(api),
+		"traefik.http.routers.dashboard.service":     "api@internal",
+	}
+	if users != "" {
+		labels["traefik.http.middlewares.dashboard-auth.basicauth.users"] = users
+		labels["traefik.http.routers.dashboard.middlewares"] = "dashboard-auth"
+	}
+	return labels
+}
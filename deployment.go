@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// validateDeployment fills in the defaults of the rolling deployments and
+// checks the percentages.
+func validateDeployment(conf *stackConfig) error {
+	d := &conf.Deployment
+	if d.CircuitBreaker == nil {
+		d.CircuitBreaker = pulumi.BoolRef(true)
+	}
+	if d.Rollback == nil {
+		d.Rollback = pulumi.BoolRef(*d.CircuitBreaker)
+	}
+	if *d.Rollback && !*d.CircuitBreaker {
+		return fmt.Errorf("deployment.rollback requires deployment.circuitBreaker")
+	}
+	if d.MinimumHealthyPercent == nil {
+		d.MinimumHealthyPercent = pulumi.IntRef(100)
+	}
+	if d.MaximumPercent == 0 {
+		d.MaximumPercent = 200
+	}
+	if err := checkPercents("deployment", *d.MinimumHealthyPercent, d.MaximumPercent); err != nil {
+		return err
+	}
+	for service, p := range d.Services {
+		if service == "traefik-acme" {
+			return fmt.Errorf("deployment.services: the ACME task always stops before the next one starts")
+		}
+		if p.MinimumHealthyPercent == nil {
+			p.MinimumHealthyPercent = d.MinimumHealthyPercent
+		}
+		if p.MaximumPercent == 0 {
+			p.MaximumPercent = d.MaximumPercent
+		}
+		if err := checkPercents("deployment.services."+service, *p.MinimumHealthyPercent, p.MaximumPercent); err != nil {
+			return err
+		}
+		d.Services[service] = p
+	}
+	return nil
+}
+
+// checkPercents checks the deployment percentages of key.
+func checkPercents(key string, minimumHealthy, maximum int) error {
+	if minimumHealthy < 0 || minimumHealthy > 100 {
+		return fmt.Errorf("%s.minimumHealthyPercent must be between 0 and 100, got %d", key, minimumHealthy)
+	}
+	if maximum < 100 || maximum > 200 {
+		return fmt.Errorf("%s.maximumPercent must be between 100 and 200, got %d", key, maximum)
+	}
+	// Otherwise a deployment could neither stop an old task nor start a new
+	// one.
+	if minimumHealthy == 100 && maximum == 100 {
+		return fmt.Errorf("%s: minimumHealthyPercent and maximumPercent can't both be 100", key)
+	}
+	return nil
+}
+
+// circuitBreaker stops the deployments of a service whose new tasks keep
+// failing to start or to become healthy, and rolls them back.
+func (c *stackConfig) circuitBreaker() ecs.ServiceDeploymentCircuitBreakerPtrInput {
+	if !*c.Deployment.CircuitBreaker {
+		return nil
+	}
+	return ecs.ServiceDeploymentCircuitBreakerArgs{
+		Enable:   pulumi.Bool(true),
+		Rollback: pulumi.Bool(*c.Deployment.Rollback),
+	}
+}
+
+// minimumHealthyPercent is the share of the tasks of service that keep
+// running during a deployment.
+func (c *stackConfig) minimumHealthyPercent(service string) pulumi.IntPtrInput {
+	if p, ok := c.Deployment.Services[service]; ok {
+		return pulumi.Int(*p.MinimumHealthyPercent)
+	}
+	return pulumi.Int(*c.Deployment.MinimumHealthyPercent)
+}
+
+// maximumPercent is the share of the tasks of service that may run during a
+// deployment, old and new ones together.
+func (c *stackConfig) maximumPercent(service string) pulumi.IntPtrInput {
+	if p, ok := c.Deployment.Services[service]; ok {
+		return pulumi.Int(p.MaximumPercent)
+	}
+	return pulumi.Int(c.Deployment.MaximumPercent)
+}
+
+// validateHealthCheckGracePeriods checks the grace periods of the services.
+// Only the Traefik services are registered with load balancers, the apps
+// being reached through them.
+func validateHealthCheckGracePeriods(conf *stackConfig) error {
+	for service, seconds := range conf.HealthCheckGracePeriods {
+		switch service {
+		case "traefik", "traefik-canary", "traefik-internal":
+		default:
+			return fmt.Errorf("healthCheckGracePeriods.%s: only traefik, traefik-canary and traefik-internal are load-balanced", service)
+		}
+		if seconds < 0 {
+			return fmt.Errorf("healthCheckGracePeriods.%s must not be negative, got %d", service, seconds)
+		}
+	}
+	return nil
+}
+
+// healthCheckGracePeriod is how long, in seconds, the failing load balancer
+// health checks of the new tasks of service don't count, or nil if they
+// count from the start. The Traefik canary gets Traefik's unless given its
+// own.
+func (c *stackConfig) healthCheckGracePeriod(service string) pulumi.IntPtrInput {
+	seconds, ok := c.HealthCheckGracePeriods[service]
+	if !ok && service == "traefik-canary" {
+		seconds, ok = c.HealthCheckGracePeriods["traefik"]
+	}
+	if !ok {
+		return nil
+	}
+	return pulumi.Int(seconds)
+}
+
+// forceNewDeployment has the services replace their tasks whenever they are
+// updated.
+func (c *stackConfig) forceNewDeployment() pulumi.BoolPtrInput {
+	if !c.Deployment.ForceNewDeployment {
+		return nil
+	}
+	return pulumi.Bool(true)
+}
+
+// pinImages pins the images of the container definitions defs to the
+// digests their tags point at, with deployment.pinImages, so that a task
+// definition changes with the images it runs.
+func (c *stackConfig) pinImages(ctx *pulumi.Context, defs pulumi.StringInput) pulumi.StringInput {
+	if !c.Deployment.PinImages {
+		return defs
+	}
+	return defs.ToStringOutput().ApplyT(func(defs string) (string, error) {
+		var containers []map[string]interface{}
+		if err := json.Unmarshal([]byte(defs), &containers); err != nil {
+			return "", err
+		}
+		for _, container := range containers {
+			image, _ := container["image"].(string)
+			digest, err := resolveImageDigest(ctx, image, c)
+			if err != nil {
+				return "", fmt.Errorf("deployment.pinImages: %w", err)
+			}
+			container["image"] = pinImage(image, digest)
+		}
+		b, err := json.Marshal(containers)
+		return string(b), err
+	}).(pulumi.StringOutput)
+}
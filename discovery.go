@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/servicediscovery"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// validateServiceDiscovery fills in the defaults of the Cloud Map namespace.
+func validateServiceDiscovery(conf *stackConfig) error {
+	d := conf.ServiceDiscovery
+	if d.Namespace == "" {
+		d.Namespace = "internal.local"
+	}
+	if strings.HasPrefix(d.Namespace, ".") || strings.HasSuffix(d.Namespace, ".") {
+		return fmt.Errorf("serviceDiscovery.namespace must be a domain name, got %q", d.Namespace)
+	}
+	if d.TTL == 0 {
+		d.TTL = 10
+	}
+	if d.TTL < 0 {
+		return fmt.Errorf("serviceDiscovery.ttl must be positive, got %d", d.TTL)
+	}
+	return nil
+}
+
+// createServiceDiscovery creates the private DNS namespace of the VPC the
+// app services are registered in.
+func createServiceDiscovery(ctx *pulumi.Context, vpc *ec2.LookupVpcResult, conf *stackConfig) error {
+	namespace, err := servicediscovery.NewPrivateDnsNamespace(ctx, "service-discovery-namespace", &servicediscovery.PrivateDnsNamespaceArgs{
+		Name:        pulumi.String(conf.ServiceDiscovery.Namespace),
+		Description: pulumi.String("Service discovery for the apps"),
+		Vpc:         pulumi.String(vpc.Id),
+	})
+	if err != nil {
+		return err
+	}
+	conf.namespaceID = namespace.ID().ToStringOutput()
+	return nil
+}
+
+// registerService registers the tasks of service in the namespace, under
+// the name <service>.<namespace>, and returns the service registry of its
+// ECS service. Without serviceDiscovery, it registers nothing.
+func registerService(ctx *pulumi.Context, service string, conf *stackConfig) (ecs.ServiceServiceRegistriesPtrInput, error) {
+	if conf.ServiceDiscovery == nil {
+		return nil, nil
+	}
+	registry, err := servicediscovery.NewService(ctx, service+"-discovery", &servicediscovery.ServiceArgs{
+		Name: pulumi.String(service),
+		DnsConfig: servicediscovery.ServiceDnsConfigArgs{
+			NamespaceId: conf.namespaceID,
+			DnsRecords: servicediscovery.ServiceDnsConfigDnsRecordArray{
+				servicediscovery.ServiceDnsConfigDnsRecordArgs{
+					Ttl:  pulumi.Int(conf.ServiceDiscovery.TTL),
+					Type: pulumi.String("A"),
+				},
+			},
+			RoutingPolicy: pulumi.String("MULTIVALUE"),
+		},
+		// ECS reports the health of the tasks, and deregisters them when
+		// they stop.
+		HealthCheckCustomConfig: servicediscovery.ServiceHealthCheckCustomConfigArgs{
+			FailureThreshold: pulumi.Int(1),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ecs.ServiceServiceRegistriesArgs{RegistryArn: registry.Arn}, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import "fmt"
+
+// dynamicConfig is the part of Traefik's dynamic configuration this stack
+// generates, for what docker labels can't express. The Traefik container
+// writes it to generatedConfigFile, where the file provider picks it up.
+type dynamicConfig struct {
+	HTTP *dynamicHTTPConfig `yaml:"http,omitempty"`
+	TLS  *dynamicTLSConfig  `yaml:"tls,omitempty"`
+}
+
+type dynamicHTTPConfig struct {
+	Routers     map[string]dynamicRouterConfig     `yaml:"routers,omitempty"`
+	Middlewares map[string]dynamicMiddlewareConfig `yaml:"middlewares,omitempty"`
+	Services    map[string]dynamicServiceConfig    `yaml:"services,omitempty"`
+}
+
+type dynamicRouterConfig struct {
+	EntryPoints []string  `yaml:"entryPoints"`
+	Rule        string    `yaml:"rule"`
+	Middlewares []string  `yaml:"middlewares,omitempty"`
+	Service     string    `yaml:"service"`
+	TLS         *struct{} `yaml:"tls,omitempty"`
+}
+
+type dynamicMiddlewareConfig struct {
+	RedirectRegex *redirectRegexConfig `yaml:"redirectRegex,omitempty"`
+}
+
+type redirectRegexConfig struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+	Permanent   bool   `yaml:"permanent"`
+}
+
+type dynamicServiceConfig struct {
+	LoadBalancer *loadBalancerServiceConfig `yaml:"loadBalancer,omitempty"`
+	Weighted     *weightedServiceConfig     `yaml:"weighted,omitempty"`
+}
+
+type loadBalancerServiceConfig struct {
+	Servers []serverConfig `yaml:"servers"`
+}
+
+type serverConfig struct {
+	URL string `yaml:"url"`
+}
+
+type weightedServiceConfig struct {
+	Services []weightedService `yaml:"services"`
+	Sticky   *stickyConfig     `yaml:"sticky,omitempty"`
+}
+
+type stickyConfig struct {
+	Cookie StickyCookie `yaml:"cookie"`
+}
+
+type weightedService struct {
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"`
+}
+
+type dynamicTLSConfig struct {
+	Options map[string]TLSOptions `yaml:"options"`
+}
+
+// generatedConfigFile is the file in the file provider's directory the
+// generated dynamic configuration is written to.
+const generatedConfigFile = dynamicConfigPath + "/stack.yml"
+
+// traefikDynamicConfig generates the dynamic configuration of the Traefik
+// tasks routing apps.
+func traefikDynamicConfig(conf *stackConfig, apps []*App) dynamicConfig {
+	var c dynamicConfig
+
+	// Weighted services can't be defined by labels, so the routers of apps
+	// with a canary refer to one defined here.
+	for _, app := range apps {
+		if app.canary == nil || conf.offboarding(app.Name) {
+			continue
+		}
+		if c.HTTP == nil {
+			c.HTTP = &dynamicHTTPConfig{Services: map[string]dynamicServiceConfig{}}
+		}
+		weighted := &weightedServiceConfig{
+			Services: []weightedService{
+				{Name: app.stableService() + "@ecs", Weight: 100 - app.canary.weight},
+				{Name: app.canaryService() + "@ecs", Weight: app.canary.weight},
+			},
+		}
+		// Sticky sessions first stick to a version, then to a task of it.
+		if app.sticky != nil {
+			cookie := *app.sticky
+			if cookie.Name != "" {
+				cookie.Name += "_version"
+			}
+			weighted.Sticky = &stickyConfig{Cookie: cookie}
+		}
+		c.HTTP.Services[app.Name] = dynamicServiceConfig{Weighted: weighted}
+	}
+
+	// The error pages sidecar is in the same task, so Traefik doesn't need
+	// to discover it.
+	if e := conf.ErrorPages; e != nil && e.Image != "" {
+		if c.HTTP == nil {
+			c.HTTP = &dynamicHTTPConfig{Services: map[string]dynamicServiceConfig{}}
+		}
+		c.HTTP.Services[errorPagesService] = dynamicServiceConfig{
+			LoadBalancer: &loadBalancerServiceConfig{
+				Servers: []serverConfig{{URL: fmt.Sprintf("http://localhost:%d", e.Port)}},
+			},
+		}
+	}
+
+	// Redirects don't belong to any app, so their routers are defined here
+	// too. They never forward a request, which the noop service stands for.
+	for i, r := range conf.Redirects {
+		if c.HTTP == nil {
+			c.HTTP = &dynamicHTTPConfig{Services: map[string]dynamicServiceConfig{}}
+		}
+		if c.HTTP.Routers == nil {
+			c.HTTP.Routers = map[string]dynamicRouterConfig{}
+			c.HTTP.Middlewares = map[string]dynamicMiddlewareConfig{}
+		}
+		name := fmt.Sprintf("redirect-%d", i)
+		c.HTTP.Middlewares[name] = dynamicMiddlewareConfig{RedirectRegex: r.redirectRegex()}
+		router := dynamicRouterConfig{
+			EntryPoints: []string{"web"},
+			Rule:        r.rule(),
+			Middlewares: []string{name},
+			Service:     "noop@internal",
+		}
+		c.HTTP.Routers[name] = router
+		if conf.ACME != nil {
+			router.EntryPoints = []string{"websecure"}
+			router.TLS = &struct{}{}
+			c.HTTP.Routers[name+"-secure"] = router
+		}
+	}
+
+	// Options named default apply to every router without options of its
+	// own.
+	if conf.ACME != nil && conf.ACME.TLS != nil {
+		c.TLS = &dynamicTLSConfig{Options: map[string]TLSOptions{"default": *conf.ACME.TLS}}
+	}
+
+	return c
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/sns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// lambdaFunctionArnPattern matches the ARNs of Lambda functions, with an
+// optional version or alias.
+var lambdaFunctionArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:lambda:[a-z0-9-]+:\d{12}:function:[A-Za-z0-9_-]+(:[A-Za-z0-9_$-]+)?$`)
+
+// validateECSEvents checks that the events of the cluster go somewhere.
+func validateECSEvents(conf *stackConfig) error {
+	e := conf.ECSEvents
+	if e.FunctionArn != "" && !lambdaFunctionArnPattern.MatchString(e.FunctionArn) {
+		return fmt.Errorf("ecsEvents.functionArn must be the ARN of a Lambda function, got %q", e.FunctionArn)
+	}
+	if conf.Alerts == nil && e.FunctionArn == "" {
+		return fmt.Errorf("ecsEvents needs alerts or ecsEvents.functionArn to send the events to")
+	}
+	return nil
+}
+
+// ecsEventPatterns are the event patterns of the rules of the cluster's
+// events, by rule: tasks stopped by a failure rather than by a deployment or
+// scaling in, failed deployments, and the warnings and errors of the
+// services, e.g. tasks that can't be placed.
+func ecsEventPatterns(clusterArn, servicePrefix string) map[string]interface{} {
+	return map[string]interface{}{
+		"ecs-task-stopped": map[string]interface{}{
+			"source":      []string{"aws.ecs"},
+			"detail-type": []string{"ECS Task State Change"},
+			"detail": map[string]interface{}{
+				"clusterArn": []string{clusterArn},
+				"lastStatus": []string{"STOPPED"},
+				"stopCode":   []string{"EssentialContainerExited", "TaskFailedToStart"},
+			},
+		},
+		"ecs-deployment-failed": map[string]interface{}{
+			"source":      []string{"aws.ecs"},
+			"detail-type": []string{"ECS Deployment State Change"},
+			"resources":   []interface{}{map[string]string{"prefix": servicePrefix}},
+			"detail": map[string]interface{}{
+				"eventName": []string{"SERVICE_DEPLOYMENT_FAILED"},
+			},
+		},
+		"ecs-service-action": map[string]interface{}{
+			"source":      []string{"aws.ecs"},
+			"detail-type": []string{"ECS Service Action"},
+			"detail": map[string]interface{}{
+				"clusterArn": []string{clusterArn},
+				"eventType":  []string{"WARN", "ERROR"},
+			},
+		},
+	}
+}
+
+// alertTopicPolicy is the policy of the alert topic: the default one, with
+// which the account's CloudWatch alarms publish to it, and EventBridge may
+// publish the events of the account's rules.
+func alertTopicPolicy(account, topicArn string) (string, error) {
+	owner := PolicyStatement{
+		Sid:       "owner",
+		Effect:    "Allow",
+		Principal: map[string][]string{"AWS": {"*"}},
+		Action: []string{
+			"sns:GetTopicAttributes",
+			"sns:SetTopicAttributes",
+			"sns:AddPermission",
+			"sns:RemovePermission",
+			"sns:DeleteTopic",
+			"sns:Subscribe",
+			"sns:ListSubscriptionsByTopic",
+			"sns:Publish",
+		},
+		Resource:  []string{topicArn},
+		Condition: map[string]map[string][]string{"StringEquals": {"AWS:SourceOwner": {account}}},
+	}
+	events := PolicyStatement{
+		Sid:       "events",
+		Effect:    "Allow",
+		Principal: map[string][]string{"Service": {"events.amazonaws.com"}},
+		Action:    []string{"sns:Publish"},
+		Resource:  []string{topicArn},
+		Condition: map[string]map[string][]string{"StringEquals": {"aws:SourceAccount": {account}}},
+	}
+	return NewPolicyDocument(owner, events).JSON()
+}
+
+// createECSEvents creates the rules of the events of cluster, which send
+// them to the alert topic and to ecsEvents.functionArn.
+func createECSEvents(ctx *pulumi.Context, cluster *ecs.Cluster, conf *stackConfig) error {
+	e := conf.ECSEvents
+	identity, err := aws.GetCallerIdentity(ctx, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+	region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+
+	if conf.Alerts != nil {
+		policy := conf.alertTopicArn.ApplyT(func(arn string) (string, error) {
+			return alertTopicPolicy(identity.AccountId, arn)
+		}).(pulumi.StringOutput)
+		_, err = sns.NewTopicPolicy(ctx, "alerts-policy", &sns.TopicPolicyArgs{
+			Arn:    conf.alertTopicArn,
+			Policy: policy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	patterns := pulumi.All(cluster.Arn, cluster.Name).ApplyT(func(args []interface{}) (map[string]string, error) {
+		clusterArn, clusterName := args[0].(string), args[1].(string)
+		servicePrefix := fmt.Sprintf("arn:aws:ecs:%s:%s:service/%s/", region.Name, identity.AccountId, clusterName)
+		patterns := map[string]string{}
+		for name, pattern := range ecsEventPatterns(clusterArn, servicePrefix) {
+			b, err := json.Marshal(pattern)
+			if err != nil {
+				return nil, err
+			}
+			patterns[name] = string(b)
+		}
+		return patterns, nil
+	}).(pulumi.StringMapOutput)
+
+	descriptions := map[string]string{
+		"ecs-task-stopped":      "Tasks of the cluster stopped by a failure",
+		"ecs-deployment-failed": "Failed deployments of the services of the cluster",
+		"ecs-service-action":    "Warnings and errors of the services of the cluster",
+	}
+	for _, name := range []string{"ecs-task-stopped", "ecs-deployment-failed", "ecs-service-action"} {
+		rule, err := cloudwatch.NewEventRule(ctx, name, &cloudwatch.EventRuleArgs{
+			Description:  pulumi.String(descriptions[name]),
+			EventPattern: patterns.MapIndex(pulumi.String(name)),
+			Tags:         conf.resourceTags(),
+		})
+		if err != nil {
+			return err
+		}
+		if conf.Alerts != nil {
+			_, err = cloudwatch.NewEventTarget(ctx, name+"-alerts", &cloudwatch.EventTargetArgs{
+				Rule: rule.Name,
+				Arn:  conf.alertTopicArn,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if e.FunctionArn != "" {
+			_, err = lambda.NewPermission(ctx, name+"-invoke", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  pulumi.String(e.FunctionArn),
+				Principal: pulumi.String("events.amazonaws.com"),
+				SourceArn: rule.Arn,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = cloudwatch.NewEventTarget(ctx, name+"-function", &cloudwatch.EventTargetArgs{
+				Rule: rule.Name,
+				Arn:  pulumi.String(e.FunctionArn),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// traefikTarget is a target group the Traefik service registers port of its
+// tasks with, in addition to the web and dashboard ones.
+type traefikTarget struct {
+	tg   *elb.TargetGroup
+	port int
+}
+
+// needsNetworkLoadBalancer reports whether any traffic bypasses the ALB,
+// which only speaks HTTP.
+func (c *stackConfig) needsNetworkLoadBalancer() bool {
+	if c.ACME != nil {
+		return true
+	}
+	for _, ep := range c.EntryPoints {
+		if ep.Protocol != "http" {
+			return true
+		}
+	}
+	return false
+}
+
+// createNetworkLoadBalancer creates the network load balancer that forwards
+// TCP and UDP as is to Traefik: TLS for ACME and the custom tcp and udp
+// entrypoints.
+func createNetworkLoadBalancer(ctx *pulumi.Context, subnet *ec2.GetSubnetIdsResult) (*elb.LoadBalancer, error) {
+	return elb.NewLoadBalancer(ctx, "network-lb", &elb.LoadBalancerArgs{
+		LoadBalancerType: pulumi.String("network"),
+		Subnets:          toPulumiStringArray(subnet.Ids),
+	})
+}
+
+// networkHealthCheck checks the ping endpoint of the health entrypoint, like
+// the ALB target groups do.
+func networkHealthCheck(conf *stackConfig) elb.TargetGroupHealthCheckArgs {
+	return elb.TargetGroupHealthCheckArgs{
+		Protocol: pulumi.String("HTTP"),
+		Port:     pulumi.Sprintf("%d", conf.HealthPort),
+		Path:     pulumi.String("/ping"),
+		Matcher:  pulumi.String("200-399"),
+	}
+}
+
+// createEntryPoints creates a listener and target group for every custom
+// entrypoint: http ones on the public ALB, tcp and udp ones on networkLb.
+func createEntryPoints(
+	ctx *pulumi.Context,
+	vpc *ec2.LookupVpcResult,
+	webLb *elb.LoadBalancer,
+	networkLb *elb.LoadBalancer,
+	conf *stackConfig,
+) ([]traefikTarget, error) {
+	var targets []traefikTarget
+	for _, ep := range conf.EntryPoints {
+		var tg *elb.TargetGroup
+		var err error
+		lb := networkLb
+		if ep.Protocol == "http" {
+			lb = webLb
+			tg, err = newTraefikTargetGroup(ctx, "traefik-"+ep.Name+"-tg", "traefik-"+ep.Name, ep.Port, vpc, conf)
+		} else {
+			tg, err = elb.NewTargetGroup(ctx, "traefik-"+ep.Name+"-tg", &elb.TargetGroupArgs{
+				Name:                conf.physicalName("traefik-" + ep.Name),
+				DeregistrationDelay: pulumi.Int(conf.DeregistrationDelay),
+				Port:                pulumi.Int(ep.Port),
+				Protocol:            pulumi.String(strings.ToUpper(ep.Protocol)),
+				TargetType:          pulumi.String("ip"),
+				VpcId:               pulumi.String(vpc.Id),
+				HealthCheck:         networkHealthCheck(conf),
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = elb.NewListener(ctx, "traefik-"+ep.Name+"-listener", &elb.ListenerArgs{
+			LoadBalancerArn: lb.Arn,
+			Port:            pulumi.Int(ep.Port),
+			Protocol:        pulumi.String(strings.ToUpper(ep.Protocol)),
+			DefaultActions: elb.ListenerDefaultActionArray{
+				elb.ListenerDefaultActionArgs{
+					Type:           pulumi.String("forward"),
+					TargetGroupArn: tg.Arn,
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, traefikTarget{tg: tg, port: ep.Port})
+	}
+
+	return targets, nil
+}
+
+// entryPointAddress is the address of ep in Traefik's static configuration.
+func entryPointAddress(ep EntryPointOptions) string {
+	if ep.Protocol == "udp" {
+		return fmt.Sprintf(":%d/udp", ep.Port)
+	}
+	return fmt.Sprintf(":%d", ep.Port)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// errorPagesService is the Traefik service of the error pages sidecar,
+// defined by the generated dynamic configuration.
+const errorPagesService = "error-pages"
+
+// validateErrorPages checks the error pages and fills in the defaults. The
+// sidecar shares the network of the Traefik container, so its port must not
+// be one Traefik listens on.
+func validateErrorPages(conf *stackConfig) error {
+	e := conf.ErrorPages
+	if (e.Image == "") == (e.Service == "") {
+		return fmt.Errorf("errorPages needs either an image or a service")
+	}
+	if len(e.Status) == 0 {
+		e.Status = []string{"500-599"}
+	}
+	if e.Query == "" {
+		e.Query = "/{status}.html"
+	}
+	if e.Image == "" {
+		return nil
+	}
+
+	if e.Port == 0 {
+		return fmt.Errorf("errorPages.port is required with an image")
+	}
+	taken := []int{80, 443, 8080, conf.HealthPort}
+	if m := conf.Traefik.Metrics; m.Prometheus {
+		taken = append(taken, m.Port)
+	}
+	for _, ep := range conf.EntryPoints {
+		taken = append(taken, ep.Port)
+	}
+	for _, port := range taken {
+		if e.Port == port {
+			return fmt.Errorf("errorPages.port %d is already used by Traefik", e.Port)
+		}
+	}
+	return nil
+}
+
+// errorPagesMiddleware replaces the error responses of an app with the page
+// for their status.
+func (c *stackConfig) errorPagesMiddleware() Middleware {
+	service := c.ErrorPages.Service
+	if service == "" {
+		service = errorPagesService + "@file"
+	}
+	return Middleware{Kind: "errors", Options: map[string]string{
+		"status":  strings.Join(c.ErrorPages.Status, ","),
+		"service": service,
+		"query":   c.ErrorPages.Query,
+	}}
+}
+
+// errorPagesContainer is the sidecar of the Traefik tasks serving the error
+// pages to Traefik over localhost. Traefik keeps serving without it, with
+// the original errors.
+func errorPagesContainer(e *errorPagesConfig) containerDefinition {
+	return containerDefinition{
+		Name:      "error-pages",
+		Image:     e.Image,
+		Essential: false,
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// executes reports whether ECS Exec is enabled for service.
+func (c *stackConfig) executes(service string) bool {
+	if c.ExecuteCommand == nil {
+		return false
+	}
+	for _, name := range c.ExecuteCommand.Services {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
+// enableExecuteCommand lets operators open a shell in the containers of
+// service with ECS Exec.
+func (c *stackConfig) enableExecuteCommand(service string) pulumi.BoolPtrInput {
+	return pulumi.Bool(c.executes(service))
+}
+
+// appTaskRole is the task role of service, a task without a role of its own,
+// which needs one for ECS Exec, to send its logs with FireLens, or if needs,
+// to mount volumes or send traces.
+func (c *stackConfig) appTaskRole(service string, needs bool) pulumi.StringPtrInput {
+	if !c.executes(service) && !needs && c.FireLens == nil {
+		return nil
+	}
+	return c.appRoleArn
+}
+
+// execKmsKey is the key of the ECS Exec sessions and their logs,
+// executeCommand.kmsKeyArn or else the stack's key, or nil without either.
+func (c *stackConfig) execKmsKey() pulumi.StringPtrInput {
+	if c.ExecuteCommand.KmsKeyArn != "" {
+		return pulumi.String(c.ExecuteCommand.KmsKeyArn)
+	}
+	return c.kmsKey()
+}
+
+// createExecConfiguration creates the log group ECS Exec sessions are logged
+// to, and returns the cluster configuration that logs them there. The
+// sessions are encrypted with executeCommand.kmsKeyArn or the stack's key if
+// any, and so is the log group unless logs configures its key.
+func createExecConfiguration(ctx *pulumi.Context, conf *stackConfig) (ecs.ClusterConfigurationPtrInput, *cloudwatch.LogGroup, error) {
+	e := conf.ExecuteCommand
+	key := conf.execKmsKey()
+	logKey := conf.logsKmsKey()
+	if e.KmsKeyArn != "" {
+		logKey = key
+	}
+	args := conf.logGroupArgs("executeCommand", e.LogRetention, logKey)
+	logGroup, err := cloudwatch.NewLogGroup(ctx, "exec-logs", args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	execConf := ecs.ClusterConfigurationExecuteCommandConfigurationArgs{
+		Logging: pulumi.String("OVERRIDE"),
+		LogConfiguration: ecs.ClusterConfigurationExecuteCommandConfigurationLogConfigurationArgs{
+			CloudWatchLogGroupName:      logGroup.Name,
+			CloudWatchEncryptionEnabled: pulumi.Bool(args.KmsKeyId != nil),
+		},
+		KmsKeyId: key,
+	}
+	return ecs.ClusterConfigurationArgs{ExecuteCommandConfiguration: execConf}, logGroup, nil
+}
+
+// createExecPolicy lets the tasks of traefikRole, appRole and the apps' own
+// roles open ECS Exec sessions and log them to logGroup.
+func createExecPolicy(ctx *pulumi.Context, logGroup *cloudwatch.LogGroup, traefikRole *iam.Role, appRole *iam.Role, apps []*App, conf *stackConfig) error {
+	key := pulumi.String("").ToStringOutput()
+	if k := conf.execKmsKey(); k != nil {
+		key = k.ToStringPtrOutput().Elem()
+	}
+	policy := pulumi.All(logGroup.Arn, key).ApplyT(func(args []interface{}) (string, error) {
+		logGroupArn, key := args[0].(string), args[1].(string)
+		statements := []PolicyStatement{
+			Allow([]string{
+				"ssmmessages:CreateControlChannel",
+				"ssmmessages:CreateDataChannel",
+				"ssmmessages:OpenControlChannel",
+				"ssmmessages:OpenDataChannel",
+			}, "*"),
+			Allow([]string{"logs:DescribeLogGroups"}, "*"),
+			Allow([]string{
+				"logs:CreateLogStream",
+				"logs:DescribeLogStreams",
+				"logs:PutLogEvents",
+			}, logGroupArn+":*"),
+		}
+		if key != "" {
+			statements = append(statements, Allow([]string{"kms:Decrypt"}, key))
+		}
+		return NewPolicyDocument(statements...).JSON()
+	}).(pulumi.StringOutput)
+
+	execPolicy, err := iam.NewPolicy(ctx, "exec-policy", &iam.PolicyArgs{
+		Description: pulumi.String("ECS Exec sessions"),
+		NamePrefix:  conf.policyNamePrefix(""),
+		Path:        conf.iamPath(),
+		Policy:      policy,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = iam.NewRolePolicyAttachment(ctx, "exec-traefik-policy", &iam.RolePolicyAttachmentArgs{
+		Role:      traefikRole.Name,
+		PolicyArn: execPolicy.Arn,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicyAttachment(ctx, "exec-tasks-policy", &iam.RolePolicyAttachmentArgs{
+		Role:      appRole.Name,
+		PolicyArn: execPolicy.Arn,
+	})
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if app.role == nil {
+			continue
+		}
+		_, err = iam.NewRolePolicyAttachment(ctx, app.Name+"-exec-policy", &iam.RolePolicyAttachmentArgs{
+			Role:      app.role.Name,
+			PolicyArn: execPolicy.Arn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExecServices checks that the services of executeCommand.services
+// exist.
+func checkExecServices(services map[string]*ecs.Service, conf *stackConfig) error {
+	if conf.ExecuteCommand == nil {
+		return nil
+	}
+	for _, name := range conf.ExecuteCommand.Services {
+		if services[name] == nil {
+			return fmt.Errorf("executeCommand: unknown service %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ecrRegistryPattern matches the hosts of private ECR registries, capturing
+// the account and the region.
+var ecrRegistryPattern = regexp.MustCompile(`^(\d{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com$`)
+
+// stackImages are the images of every container the stack runs.
+func (c *stackConfig) stackImages(apps []*App) []string {
+	images := []string{c.Traefik.Image}
+	if c.TraefikCanary != nil {
+		images = append(images, c.TraefikCanary.Image)
+	}
+	if c.sharedACME() {
+		images = append(images, certsDumperImage)
+	}
+	if e := c.ErrorPages; e != nil && e.Image != "" {
+		images = append(images, e.Image)
+	}
+	if c.needsCollector() || usesTracing(apps) {
+		images = append(images, collectorImage)
+	}
+	if c.FireLens != nil {
+		images = append(images, c.FireLens.Image)
+	}
+	if c.ForwardAuth != nil {
+		images = append(images, c.ForwardAuth.Image)
+	}
+	for _, app := range apps {
+		images = append(images, app.Image)
+		if app.canary != nil {
+			images = append(images, app.canary.image)
+		}
+	}
+	return images
+}
+
+// ecrRepositoryArns are the ARNs of the private ECR repositories of images.
+// Images from other registries need no permissions to be pulled.
+func ecrRepositoryArns(images []string) []string {
+	seen := map[string]bool{}
+	var arns []string
+	for _, image := range images {
+		host, repo, _ := splitImage(image)
+		m := ecrRegistryPattern.FindStringSubmatch(host)
+		if m == nil {
+			continue
+		}
+		// Images pinned to a digest split at the digest's colon.
+		if i := strings.Index(repo, "@"); i >= 0 {
+			repo = repo[:i]
+		}
+		arn := fmt.Sprintf("arn:aws:ecr:%s:%s:repository/%s", m[2], m[1], repo)
+		if !seen[arn] {
+			seen[arn] = true
+			arns = append(arns, arn)
+		}
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// createExecutionPolicy lets the task execution role pull the stack's images
+// from ECR and write to the log groups of the containers, instead of the
+// AmazonECSTaskExecutionRolePolicy that allows both on every resource of the
+// account. The secrets each have a policy of their own.
+func createExecutionPolicy(ctx *pulumi.Context, ecsRole *iam.Role, logGroups []*cloudwatch.LogGroup, apps []*App, conf *stackConfig) error {
+	repositories := ecrRepositoryArns(conf.stackImages(apps))
+
+	var logGroupArns []interface{}
+	for _, logGroup := range logGroups {
+		logGroupArns = append(logGroupArns, logGroup.Arn)
+	}
+	if len(repositories) == 0 && len(logGroupArns) == 0 {
+		return nil
+	}
+
+	policy := pulumi.All(logGroupArns...).ApplyT(func(arns []interface{}) (string, error) {
+		var statements []PolicyStatement
+		if len(repositories) > 0 {
+			statements = append(statements,
+				Allow([]string{"ecr:GetAuthorizationToken"}, "*"),
+				Allow([]string{
+					"ecr:BatchCheckLayerAvailability",
+					"ecr:BatchGetImage",
+					"ecr:GetDownloadUrlForLayer",
+				}, repositories...),
+			)
+		}
+		if len(arns) > 0 {
+			var streams []string
+			for _, arn := range arns {
+				streams = append(streams, arn.(string)+":*")
+			}
+			statements = append(statements, Allow([]string{"logs:CreateLogStream", "logs:PutLogEvents"}, streams...))
+		}
+		return NewPolicyDocument(statements...).JSON()
+	}).(pulumi.StringOutput)
+
+	_, err := iam.NewRolePolicy(ctx, "ecs-execution-policy", &iam.RolePolicyArgs{
+		Role:   ecsRole.ID(),
+		Policy: policy,
+	})
+	return err
+}
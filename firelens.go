@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// fireLensImage is the Fluent Bit image of the log routers by default.
+const fireLensImage = "public.ecr.aws/aws-observability/aws-for-fluent-bit:stable"
+
+// validateFireLens checks the destination of the logs and fills in the
+// defaults.
+func validateFireLens(conf *stackConfig) error {
+	f := conf.FireLens
+	if f.Image == "" {
+		f.Image = fireLensImage
+	}
+	switch f.Destination {
+	case "", "cloudwatch":
+		f.Destination = "cloudwatch"
+		if f.LogRetention == 0 {
+			f.LogRetention = conf.Logs.Retention
+		}
+	case "firehose":
+		if f.DeliveryStream == "" {
+			return fmt.Errorf("fireLens.deliveryStream is required with destination firehose")
+		}
+	case "opensearch":
+		if f.OpenSearchDomain != nil {
+			if f.OpenSearchHost != "" || f.OpenSearchDomainArn != "" {
+				return fmt.Errorf("fireLens.openSearchDomain creates a domain, and can't be used with fireLens.openSearchHost or fireLens.openSearchDomainArn")
+			}
+			if err := validateOpenSearchDomain(conf); err != nil {
+				return err
+			}
+		} else if f.OpenSearchHost == "" || f.OpenSearchDomainArn == "" {
+			return fmt.Errorf("fireLens.openSearchHost and fireLens.openSearchDomainArn, or fireLens.openSearchDomain, are required with destination opensearch")
+		}
+		if f.OpenSearchIndex == "" {
+			f.OpenSearchIndex = "ecs"
+		}
+	default:
+		return fmt.Errorf("fireLens.destination must be cloudwatch, firehose or opensearch, got %q", f.Destination)
+	}
+	if f.OpenSearchDomain != nil && f.Destination != "opensearch" {
+		return fmt.Errorf("fireLens.openSearchDomain needs destination opensearch, got %q", f.Destination)
+	}
+	// The access logs are written to stdout, which the log routers read.
+	if f.AccessLog {
+		conf.Traefik.AccessLog = true
+	}
+	return nil
+}
+
+// fireLensLogGroup is the log group FireLens sends the logs to with the
+// cloudwatch destination. It is named up front, as the container
+// definitions refer to it.
+func fireLensLogGroup(ctx *pulumi.Context) string {
+	return fmt.Sprintf("/ecs/%s-%s", ctx.Project(), ctx.Stack())
+}
+
+// createFireLens creates what the destination of the logs needs, and lets
+// the tasks of traefikRole, appRole and the apps' own roles send logs to it.
+func createFireLens(ctx *pulumi.Context, traefikRole *iam.Role, appRole *iam.Role, apps []*App, conf *stackConfig) error {
+	f := conf.FireLens
+	region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+
+	var statement PolicyStatement
+	switch f.Destination {
+	case "cloudwatch":
+		name := fireLensLogGroup(ctx)
+		args := conf.logGroupArgs("fireLens", f.LogRetention, conf.logsKmsKey())
+		args.Name = pulumi.String(name)
+		_, err := cloudwatch.NewLogGroup(ctx, "firelens-logs", args)
+		if err != nil {
+			return err
+		}
+		conf.fireLensOptions = map[string]string{
+			"Name":              "cloudwatch_logs",
+			"region":            region.Name,
+			"log_group_name":    name,
+			"auto_create_group": "false",
+		}
+		statement = Allow([]string{"logs:CreateLogStream", "logs:DescribeLogStreams", "logs:PutLogEvents"},
+			fmt.Sprintf("arn:aws:logs:%s:*:log-group:%s:*", region.Name, name))
+	case "firehose":
+		conf.fireLensOptions = map[string]string{
+			"Name":            "kinesis_firehose",
+			"region":          region.Name,
+			"delivery_stream": f.DeliveryStream,
+		}
+		statement = Allow([]string{"firehose:PutRecordBatch"},
+			fmt.Sprintf("arn:aws:firehose:%s:*:deliverystream/%s", region.Name, f.DeliveryStream))
+	case "opensearch":
+		if f.OpenSearchDomain != nil {
+			if err := createOpenSearchDomain(ctx, region.Name, conf); err != nil {
+				return err
+			}
+		}
+		conf.fireLensOptions = map[string]string{
+			"Name":               "opensearch",
+			"Host":               f.OpenSearchHost,
+			"Port":               "443",
+			"Index":              f.OpenSearchIndex,
+			"Trace_Error":        "On",
+			"Suppress_Type_Name": "On",
+			"AWS_Auth":           "On",
+			"AWS_Region":         region.Name,
+			"tls":                "On",
+		}
+		statement = Allow([]string{"es:ESHttpPost", "es:ESHttpPut"}, f.OpenSearchDomainArn+"/*")
+	}
+
+	policy, err := NewPolicyDocument(statement).JSON()
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicy(ctx, "firelens-traefik-policy", &iam.RolePolicyArgs{
+		Role:   traefikRole.ID(),
+		Policy: pulumi.String(policy),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicy(ctx, "firelens-app-policy", &iam.RolePolicyArgs{
+		Role:   appRole.ID(),
+		Policy: pulumi.String(policy),
+	})
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if app.role == nil {
+			continue
+		}
+		_, err = iam.NewRolePolicy(ctx, app.Name+"-firelens-policy", &iam.RolePolicyArgs{
+			Role:   app.role.ID(),
+			Policy: pulumi.String(policy),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logConfiguration routes the logs of container through the log router of
+// its task with fireLens, or else sends them to the log group of service.
+func (c *stackConfig) logConfiguration(service, container string) *logConfiguration {
+	if c.FireLens == nil {
+		return c.awslogsConfiguration(service, container)
+	}
+	options := map[string]string{}
+	for k, v := range c.fireLensOptions {
+		options[k] = v
+	}
+	if c.FireLens.Destination == "cloudwatch" {
+		options["log_stream_prefix"] = container + "/"
+	}
+	return &logConfiguration{LogDriver: "awsfirelens", Options: options}
+}
+
+// logRouterContainer is the Fluent Bit container FireLens sends the logs of
+// the other containers of a task of service to. Its own logs go to the log
+// group of service.
+func (c *stackConfig) logRouterContainer(service string) containerDefinition {
+	return containerDefinition{
+		Name:                  "log-router",
+		Image:                 c.FireLens.Image,
+		Essential:             true,
+		MemoryReservation:     50,
+		FirelensConfiguration: &firelensConfiguration{Type: "fluentbit"},
+		LogConfiguration:      c.awslogsConfiguration(service, "log-router"),
+	}
+}
+
+// withLogging has the containers of defs without a log configuration log
+// through a log router added to them with fireLens, or else to the log group
+// of service.
+func (c *stackConfig) withLogging(service string, defs []containerDefinition) []containerDefinition {
+	for i := range defs {
+		if defs[i].LogConfiguration == nil {
+			defs[i].LogConfiguration = c.logConfiguration(service, defs[i].Name)
+		}
+	}
+	if c.FireLens == nil {
+		return defs
+	}
+	return append(defs, c.logRouterContainer(service))
+}
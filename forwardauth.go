@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// forwardAuthService is the name of the authentication service, as an ECS
+// service and as a Traefik service.
+const forwardAuthService = "forward-auth"
+
+// forwardAuthPort is the port of the auth entrypoint, where Traefik sends
+// the checks of the stack's authentication service. It only listens within
+// the Traefik task and routes them on to the service: ECS services have no
+// addresses of their own, but Traefik already knows those of the tasks.
+const forwardAuthPort = 8084
+
+// validateForwardAuth checks the authentication service and fills in the
+// defaults.
+func validateForwardAuth(conf *stackConfig) error {
+	f := conf.ForwardAuth
+	if f.Image == "" || f.Port == 0 {
+		return fmt.Errorf("forwardAuth needs an image and a port")
+	}
+	if f.Path == "" {
+		f.Path = "/oauth2/auth"
+	}
+	if f.PathPrefix == "" {
+		f.PathPrefix = "/oauth2/"
+	}
+	if !strings.HasPrefix(f.Path, "/") || !strings.HasPrefix(f.PathPrefix, "/") {
+		return fmt.Errorf("forwardAuth.path and forwardAuth.pathPrefix must start with /")
+	}
+
+	taken := []int{conf.HealthPort, conf.Traefik.Metrics.Port}
+	for _, ep := range conf.EntryPoints {
+		taken = append(taken, ep.Port)
+	}
+	if e := conf.ErrorPages; e != nil {
+		taken = append(taken, e.Port)
+	}
+	for _, port := range taken {
+		if port == forwardAuthPort {
+			return fmt.Errorf("port %d is taken by the forwardAuth entrypoint", port)
+		}
+	}
+	return nil
+}
+
+// ForwardAuth lets requests through only if address, an authentication
+// service's URL, answers a copy of them with a 2xx status. Otherwise the
+// service's answer, e.g. a redirect to a sign-in page, is returned instead.
+// responseHeaders are copied from the answer to the request.
+func ForwardAuth(address string, responseHeaders ...string) Middleware {
+	options := map[string]string{
+		"address": address,
+		// Requests reach Traefik from the ALB, whose X-Forwarded headers
+		// tell the service the original host and scheme.
+		"trustforwardheader": "true",
+	}
+	if len(responseHeaders) > 0 {
+		options["authresponseheaders"] = strings.Join(responseHeaders, ",")
+	}
+	return Middleware{Kind: "forwardauth", Options: options}
+}
+
+// forwardAuthMiddleware checks requests with the stack's authentication
+// service.
+func (c *stackConfig) forwardAuthMiddleware() Middleware {
+	address := fmt.Sprintf("http://127.0.0.1:%d%s", forwardAuthPort, c.ForwardAuth.Path)
+	return ForwardAuth(address, c.ForwardAuth.ResponseHeaders...)
+}
+
+// forwardAuthLabels route the sign-in pages and callbacks of the
+// authentication service on every host, ahead of the apps, and the checks
+// coming in through the auth entrypoint.
+func forwardAuthLabels(conf *stackConfig) map[string]string {
+	f := conf.ForwardAuth
+	entryPoints := "web"
+	if conf.ACME != nil {
+		entryPoints += ",websecure"
+	}
+	labels := map[string]string{
+		"traefik.enable": "true",
+		"traefik.http.services." + forwardAuthService + ".loadbalancer.server.port": fmt.Sprint(f.Port),
+
+		"traefik.http.routers." + forwardAuthService + ".rule":        fmt.Sprintf("PathPrefix(`%s`)", f.PathPrefix),
+		"traefik.http.routers." + forwardAuthService + ".entrypoints": entryPoints,
+		"traefik.http.routers." + forwardAuthService + ".priority":    "10000",
+		"traefik.http.routers." + forwardAuthService + ".service":     forwardAuthService,
+
+		"traefik.http.routers." + forwardAuthService + "-check.rule":        "PathPrefix(`/`)",
+		"traefik.http.routers." + forwardAuthService + "-check.entrypoints": "auth",
+		"traefik.http.routers." + forwardAuthService + "-check.service":     forwardAuthService,
+	}
+	for k, v := range conf.Traefik.ConstraintLabels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// forwardAuthContainerDef is the container definition of the authentication
+// service.
+func forwardAuthContainerDef(conf *stackConfig) (string, error) {
+	f := conf.ForwardAuth
+
+	var environment []keyValuePair
+	var secrets []secret
+	for name, value := range f.Environment {
+		environment = append(environment, keyValuePair{Name: name, Value: value})
+	}
+	for name, arn := range f.Secrets {
+		secrets = append(secrets, secret{Name: name, ValueFrom: arn})
+	}
+	// Sorted, so that the task definition only changes with them.
+	sort.Slice(environment, func(i, j int) bool { return environment[i].Name < environment[j].Name })
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+
+	return marshalContainers(conf.withContainerOptions(conf.withLogging(forwardAuthService, []containerDefinition{{
+		Name:         forwardAuthService,
+		Image:        f.Image,
+		Essential:    true,
+		PortMappings: []portMapping{tcpPort(f.Port)},
+		Environment:  environment,
+		Secrets:      secrets,
+		DockerLabels: forwardAuthLabels(conf),
+	}})))
+}
+
+// createForwardAuth runs the authentication service in a security group
+// only Traefik can reach it through, and lets the task execution role read
+// its secrets.
+func createForwardAuth(
+	ctx *pulumi.Context,
+	vpc *ec2.LookupVpcResult,
+	subnet *ec2.GetSubnetIdsResult,
+	traefikSg *ec2.SecurityGroup,
+	cluster *ecs.Cluster,
+	ecsRole *iam.Role,
+	conf *stackConfig,
+) (*ecs.TaskDefinition, *ecs.Service, error) {
+	f := conf.ForwardAuth
+
+	// The service talks to its identity provider over the internet.
+	sg, err := ec2.NewSecurityGroup(ctx, "forward-auth-sg", &ec2.SecurityGroupArgs{
+		VpcId:       pulumi.String(vpc.Id),
+		Description: pulumi.String("Allow authentication checks from traefik"),
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:       pulumi.String("tcp"),
+				FromPort:       pulumi.Int(f.Port),
+				ToPort:         pulumi.Int(f.Port),
+				SecurityGroups: pulumi.StringArray{traefikSg.ID().ToStringOutput()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(f.Secrets) > 0 {
+		var arns []string
+		for _, arn := range f.Secrets {
+			arns = append(arns, arn)
+		}
+		sort.Strings(arns)
+		policy, err := NewPolicyDocument(Allow([]string{"secretsmanager:GetSecretValue"}, arns...)).JSON()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = iam.NewRolePolicy(ctx, "forward-auth-secrets-policy", &iam.RolePolicyArgs{
+			Role:   ecsRole.ID(),
+			Policy: pulumi.String(policy),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	containerDef, err := forwardAuthContainerDef(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	containerDefs, err := conf.taskContainerDefinitions(ctx, forwardAuthService, pulumi.String(containerDef))
+	if err != nil {
+		return nil, nil, err
+	}
+	task, err := ecs.NewTaskDefinition(ctx, "forward-auth-task", &ecs.TaskDefinitionArgs{
+		Family:                  pulumi.String(forwardAuthService),
+		ContainerDefinitions:    containerDefs,
+		Cpu:                     conf.taskCPU(forwardAuthService),
+		Memory:                  conf.taskMemory(forwardAuthService),
+		NetworkMode:             pulumi.String("awsvpc"),
+		RequiresCompatibilities: conf.compatibilities(),
+		RuntimePlatform:         conf.RuntimePlatform.args(),
+		EphemeralStorage:        conf.ephemeralStorage(forwardAuthService),
+		ExecutionRoleArn:        ecsRole.Arn,
+		TaskRoleArn:             conf.appTaskRole(forwardAuthService, false),
+		Tags:                    conf.resourceTags(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service, err := ecs.NewService(ctx, "forward-auth-service", &ecs.ServiceArgs{
+		Name: pulumi.String(forwardAuthService),
+
+		Cluster:        cluster.Arn,
+		TaskDefinition: task.Arn,
+
+		DesiredCount:                    pulumi.Int(conf.desiredCount(forwardAuthService)),
+		LaunchType:                      conf.launchType(),
+		PlatformVersion:                 conf.platformVersion(),
+		CapacityProviderStrategies:      conf.capacityProviderStrategies(),
+		DeploymentMinimumHealthyPercent: conf.minimumHealthyPercent(forwardAuthService),
+		DeploymentMaximumPercent:        conf.maximumPercent(forwardAuthService),
+		DeploymentCircuitBreaker:        conf.circuitBreaker(),
+		ForceNewDeployment:              conf.forceNewDeployment(),
+		EnableExecuteCommand:            conf.enableExecuteCommand(forwardAuthService),
+		PlacementConstraints:            conf.placementConstraints(forwardAuthService),
+		OrderedPlacementStrategies:      conf.placementStrategies(forwardAuthService),
+		PropagateTags:                   conf.propagateTags(),
+		EnableEcsManagedTags:            pulumi.Bool(true),
+		Tags:                            conf.resourceTags(),
+
+		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
+			AssignPublicIp: conf.assignPublicIP(),
+			Subnets:        conf.taskSubnets(subnet),
+			SecurityGroups: pulumi.StringArray{sg.ID().ToStringOutput()},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return task, service, nil
+}
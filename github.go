@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// githubOIDCHost issues the OIDC tokens of the GitHub Actions jobs.
+	githubOIDCHost = "token.actions.githubusercontent.com"
+	// githubOIDCAudience is the audience of the tokens
+	// aws-actions/configure-aws-credentials asks for.
+	githubOIDCAudience = "sts.amazonaws.com"
+)
+
+// githubOIDCThumbprints are the thumbprints of the certificate chains of the
+// token endpoint. AWS trusts its certificate authority, but IAM still
+// requires them.
+var githubOIDCThumbprints = []string{
+	"6938fd4d98bab03faadb97b34396831e3780aea1",
+	"1c58a3a8518e8759bf075b76b750d4f2df264fcd",
+}
+
+var (
+	// repositoryPattern matches GitHub repositories, as owner/name.
+	repositoryPattern = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9_.-]+$`)
+	// oidcProviderArnPattern matches the ARN of the GitHub OIDC provider
+	// of an account.
+	oidcProviderArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:oidc-provider/` + regexp.QuoteMeta(githubOIDCHost) + `$`)
+)
+
+// validateGitHubActions checks the repository and the policies of the
+// deployment role, and fills in the default branch.
+func validateGitHubActions(conf *stackConfig) error {
+	g := conf.GitHubActions
+	if !repositoryPattern.MatchString(g.Repository) {
+		return fmt.Errorf("githubActions.repository must be owner/name, got %q", g.Repository)
+	}
+	if len(g.Branches) == 0 && len(g.Environments) == 0 {
+		g.Branches = []string{"main"}
+	}
+	for _, ref := range append(g.Branches, g.Environments...) {
+		if ref == "" {
+			return fmt.Errorf("githubActions: branches and environments can't be empty")
+		}
+	}
+	if g.ProviderArn != "" && !oidcProviderArnPattern.MatchString(g.ProviderArn) {
+		return fmt.Errorf("githubActions.providerArn must be the ARN of the %s OIDC provider, got %q", githubOIDCHost, g.ProviderArn)
+	}
+	if len(g.PolicyArns) == 0 {
+		return fmt.Errorf("githubActions.policyArns needs the managed policies that let the deployment role run pulumi up")
+	}
+	for _, arn := range g.PolicyArns {
+		if !permissionsBoundaryPattern.MatchString(arn) {
+			return fmt.Errorf("githubActions.policyArns: %q is not the ARN of a managed policy", arn)
+		}
+	}
+	return nil
+}
+
+// subjects are the subjects of the tokens of the jobs that may assume
+// the deployment role: those run on the branches, and those deploying to the
+// environments of the repository.
+func (g *githubActionsConfig) subjects() []string {
+	var subjects []string
+	for _, branch := range g.Branches {
+		subjects = append(subjects, fmt.Sprintf("repo:%s:ref:refs/heads/%s", g.Repository, branch))
+	}
+	for _, environment := range g.Environments {
+		subjects = append(subjects, fmt.Sprintf("repo:%s:environment:%s", g.Repository, environment))
+	}
+	return subjects
+}
+
+// createGitHubDeployRole creates the GitHub OIDC provider, unless
+// githubActions.providerArn refers to the account's, and the role the
+// workflows of the repository deploy the stack with.
+func createGitHubDeployRole(ctx *pulumi.Context, conf *stackConfig) error {
+	g := conf.GitHubActions
+	providerArn := pulumi.String(g.ProviderArn).ToStringOutput()
+	if g.ProviderArn == "" {
+		provider, err := iam.NewOpenIdConnectProvider(ctx, "github-oidc", &iam.OpenIdConnectProviderArgs{
+			Url:             pulumi.String("https://" + githubOIDCHost),
+			ClientIdLists:   pulumi.ToStringArray([]string{githubOIDCAudience}),
+			ThumbprintLists: pulumi.ToStringArray(githubOIDCThumbprints),
+			Tags:            conf.resourceTags(),
+		})
+		if err != nil {
+			return err
+		}
+		providerArn = provider.Arn
+	}
+
+	trust := providerArn.ApplyT(func(arn string) (string, error) {
+		return NewPolicyDocument(PolicyStatement{
+			Effect:    "Allow",
+			Principal: map[string][]string{"Federated": {arn}},
+			Action:    []string{"sts:AssumeRoleWithWebIdentity"},
+			Condition: map[string]map[string][]string{
+				"StringEquals": {githubOIDCHost + ":aud": {githubOIDCAudience}},
+				"StringLike":   {githubOIDCHost + ":sub": g.subjects()},
+			},
+		}).JSON()
+	}).(pulumi.StringOutput)
+	role, err := iam.NewRole(ctx, "github-deploy-role", &iam.RoleArgs{
+		Description:         pulumi.Sprintf("Deploys %s/%s from GitHub Actions workflows of %s", ctx.Project(), ctx.Stack(), g.Repository),
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    trust,
+		Tags:                conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	for i, arn := range g.PolicyArns {
+		_, err = iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("github-deploy-policy-%d", i), &iam.RolePolicyAttachmentArgs{
+			Role:      role.Name,
+			PolicyArn: pulumi.String(arn),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	ctx.Export("githubDeployRoleArn", role.Arn)
+	return nil
+}
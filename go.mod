@@ -5,4 +5,5 @@ go 1.16
 require (
 	github.com/pulumi/pulumi-aws/sdk/v5 v5.0.0
 	github.com/pulumi/pulumi/sdk/v3 v3.25.0
+	gopkg.in/yaml.v2 v2.2.8
 )
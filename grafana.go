@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudformation"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// grafanaProvisioner creates or updates the data sources and the dashboard
+// of the event in a Grafana workspace, with an API key that lives for the
+// invocation only.
+const grafanaProvisioner = `import json
+import urllib.error
+import urllib.request
+
+import boto3
+
+grafana = boto3.client("grafana")
+
+
+def call(url, key, method, path, body=None):
+    data = json.dumps(body).encode() if body is not None else None
+    request = urllib.request.Request(url + path, data=data, method=method, headers={
+        "Authorization": "Bearer " + key,
+        "Content-Type": "application/json",
+    })
+    with urllib.request.urlopen(request) as response:
+        return json.load(response)
+
+
+def handler(event, context):
+    workspace, url = event["workspaceId"], "https://" + event["endpoint"]
+    name = "pulumi-" + context.aws_request_id
+    key = grafana.create_workspace_api_key(
+        workspaceId=workspace, keyName=name, keyRole="ADMIN", secondsToLive=300)["key"]
+    try:
+        for source in event["dataSources"]:
+            try:
+                call(url, key, "GET", "/api/datasources/uid/" + source["uid"])
+                call(url, key, "PUT", "/api/datasources/uid/" + source["uid"], source)
+            except urllib.error.HTTPError as e:
+                if e.code != 404:
+                    raise
+                call(url, key, "POST", "/api/datasources", source)
+        call(url, key, "POST", "/api/dashboards/db", {"dashboard": event["dashboard"], "overwrite": True})
+    finally:
+        grafana.delete_workspace_api_key(workspaceId=workspace, keyName=name)
+`
+
+// validateManagedGrafana checks how the users of the workspace sign in, and
+// fills in its default.
+func validateManagedGrafana(conf *stackConfig) error {
+	g := conf.ManagedGrafana
+	switch g.Authentication {
+	case "":
+		g.Authentication = "AWS_SSO"
+	case "AWS_SSO", "SAML":
+	default:
+		return fmt.Errorf("managedGrafana.authentication must be AWS_SSO or SAML, got %q", g.Authentication)
+	}
+	return nil
+}
+
+// grafanaDataSources are the data sources of the workspace: CloudWatch, and
+// the Prometheus workspace at prometheusEndpoint with managedPrometheus.
+// Both sign their requests with the role of the workspace.
+func grafanaDataSources(region, prometheusEndpoint string) []map[string]interface{} {
+	sources := []map[string]interface{}{{
+		"uid":    "cloudwatch",
+		"name":   "CloudWatch",
+		"type":   "cloudwatch",
+		"access": "proxy",
+		"jsonData": map[string]interface{}{
+			"authType":      "ec2",
+			"defaultRegion": region,
+		},
+	}}
+	if prometheusEndpoint != "" {
+		sources = append(sources, map[string]interface{}{
+			"uid":       "prometheus",
+			"name":      "Amazon Managed Prometheus",
+			"type":      "prometheus",
+			"access":    "proxy",
+			"url":       prometheusEndpoint,
+			"isDefault": true,
+			"jsonData": map[string]interface{}{
+				"httpMethod":    "POST",
+				"sigV4Auth":     true,
+				"sigV4AuthType": "ec2",
+				"sigV4Region":   region,
+			},
+		})
+	}
+	return sources
+}
+
+// grafanaDashboard is the starter dashboard of the workspace: the requests
+// and 5xx responses of loadBalancer and the CPU and memory of services from
+// CloudWatch, and with prometheus the requests and latency of the Traefik
+// services from their metrics.
+func grafanaDashboard(ctx *pulumi.Context, loadBalancer, cluster string, services []string, prometheus bool) map[string]interface{} {
+	cloudwatch := map[string]string{"type": "cloudwatch", "uid": "cloudwatch"}
+	metricTarget := func(refID, namespace, metric, stat string, dimensions map[string]string) map[string]interface{} {
+		return map[string]interface{}{
+			"refId":      refID,
+			"datasource": cloudwatch,
+			"queryMode":  "Metrics",
+			"region":     "default",
+			"namespace":  namespace,
+			"metricName": metric,
+			"statistic":  stat,
+			"dimensions": dimensions,
+			"matchExact": true,
+		}
+	}
+	lb := map[string]string{"LoadBalancer": loadBalancer}
+	var cpu, memory []interface{}
+	for i, service := range services {
+		dimensions := map[string]string{"ClusterName": cluster, "ServiceName": service}
+		refID := fmt.Sprintf("S%d", i)
+		cpu = append(cpu, metricTarget(refID, "AWS/ECS", "CPUUtilization", "Average", dimensions))
+		memory = append(memory, metricTarget(refID, "AWS/ECS", "MemoryUtilization", "Average", dimensions))
+	}
+
+	type panel struct {
+		title      string
+		datasource map[string]string
+		unit       string
+		targets    []interface{}
+	}
+	panels := []panel{
+		{"Load balancer requests", cloudwatch, "short", []interface{}{
+			metricTarget("A", "AWS/ApplicationELB", "RequestCount", "Sum", lb),
+		}},
+		{"Load balancer 5xx responses", cloudwatch, "short", []interface{}{
+			metricTarget("A", "AWS/ApplicationELB", "HTTPCode_ELB_5XX_Count", "Sum", lb),
+			metricTarget("B", "AWS/ApplicationELB", "HTTPCode_Target_5XX_Count", "Sum", lb),
+		}},
+		{"Service CPU utilization", cloudwatch, "percent", cpu},
+		{"Service memory utilization", cloudwatch, "percent", memory},
+	}
+	if prometheus {
+		source := map[string]string{"type": "prometheus", "uid": "prometheus"}
+		panels = append(panels,
+			panel{"Traefik requests by service", source, "reqps", []interface{}{map[string]interface{}{
+				"refId":        "A",
+				"datasource":   source,
+				"expr":         "sum by (service) (rate(traefik_service_requests_total[5m]))",
+				"legendFormat": "{{service}}",
+			}}},
+			panel{"Traefik p90 latency by service", source, "s", []interface{}{map[string]interface{}{
+				"refId":        "A",
+				"datasource":   source,
+				"expr":         "histogram_quantile(0.9, sum by (le, service) (rate(traefik_service_request_duration_seconds_bucket[5m])))",
+				"legendFormat": "{{service}}",
+			}}},
+		)
+	}
+
+	var rendered []interface{}
+	for i, p := range panels {
+		rendered = append(rendered, map[string]interface{}{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       p.title,
+			"datasource":  p.datasource,
+			"fieldConfig": map[string]interface{}{"defaults": map[string]string{"unit": p.unit}},
+			"gridPos":     map[string]int{"x": i % 2 * 12, "y": i / 2 * 8, "w": 12, "h": 8},
+			"targets":     p.targets,
+		})
+	}
+	return map[string]interface{}{
+		"uid":           "traefik-ecs",
+		"title":         fmt.Sprintf("Traefik on ECS (%s/%s)", ctx.Project(), ctx.Stack()),
+		"schemaVersion": 36,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"panels":        rendered,
+	}
+}
+
+// createGrafanaWorkspace creates the Grafana workspace with its data sources
+// and starter dashboard, and exports its URL. The provider has no Grafana
+// resources, so the workspace is a CloudFormation stack of its own, and a
+// function provisions the data sources and the dashboard through the
+// Grafana API.
+func createGrafanaWorkspace(
+	ctx *pulumi.Context,
+	loadBalancer *elb.LoadBalancer,
+	cluster *ecs.Cluster,
+	services map[string]*ecs.Service,
+	conf *stackConfig,
+) error {
+	identity, err := aws.GetCallerIdentity(ctx, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+	region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+
+	grafanaTrust, err := assumeRolePolicy("grafana.amazonaws.com")
+	if err != nil {
+		return err
+	}
+	role, err := iam.NewRole(ctx, "grafana-role", &iam.RoleArgs{
+		Description:         pulumi.Sprintf("Reads the metrics of %s/%s from Grafana", ctx.Project(), ctx.Stack()),
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    grafanaTrust,
+		Tags:                conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	dataSources := []string{"CLOUDWATCH"}
+	policies := map[string]string{"cloudwatch": "arn:aws:iam::aws:policy/CloudWatchReadOnlyAccess"}
+	if conf.ManagedPrometheus != nil {
+		dataSources = append(dataSources, "PROMETHEUS")
+		policies["prometheus"] = "arn:aws:iam::aws:policy/AmazonPrometheusQueryAccess"
+	}
+	for name, arn := range policies {
+		_, err = iam.NewRolePolicyAttachment(ctx, "grafana-"+name+"-policy", &iam.RolePolicyAttachmentArgs{
+			Role:      role.Name,
+			PolicyArn: pulumi.String(arn),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	template, err := json.Marshal(map[string]interface{}{
+		"Parameters": map[string]interface{}{
+			"RoleArn": map[string]string{"Type": "String"},
+		},
+		"Resources": map[string]interface{}{
+			"Workspace": map[string]interface{}{
+				"Type": "AWS::Grafana::Workspace",
+				"Properties": map[string]interface{}{
+					"Name":                    awsNameInvalid.ReplaceAllString(fmt.Sprintf("%s-%s", ctx.Project(), ctx.Stack()), "-"),
+					"AccountAccessType":       "CURRENT_ACCOUNT",
+					"AuthenticationProviders": []string{conf.ManagedGrafana.Authentication},
+					"PermissionType":          "CUSTOMER_MANAGED",
+					"RoleArn":                 map[string]string{"Ref": "RoleArn"},
+					"DataSources":             dataSources,
+				},
+			},
+		},
+		"Outputs": map[string]interface{}{
+			"WorkspaceId": map[string]interface{}{"Value": map[string]string{"Ref": "Workspace"}},
+			"Endpoint":    map[string]interface{}{"Value": map[string][]string{"Fn::GetAtt": {"Workspace", "Endpoint"}}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	stack, err := cloudformation.NewStack(ctx, "grafana", &cloudformation.StackArgs{
+		TemplateBody: pulumi.String(string(template)),
+		Parameters:   pulumi.StringMap{"RoleArn": role.Arn},
+		Tags:         conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	workspaceID := stack.Outputs.MapIndex(pulumi.String("WorkspaceId"))
+	endpoint := stack.Outputs.MapIndex(pulumi.String("Endpoint"))
+
+	// The function only needs to hand out keys of the workspace to itself.
+	lambdaTrust, err := assumeRolePolicy("lambda.amazonaws.com")
+	if err != nil {
+		return err
+	}
+	provisionerRole, err := iam.NewRole(ctx, "grafana-provisioner-role", &iam.RoleArgs{
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    lambdaTrust,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicyAttachment(ctx, "grafana-provisioner-logs", &iam.RolePolicyAttachmentArgs{
+		Role:      provisionerRole.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"),
+	})
+	if err != nil {
+		return err
+	}
+	keysPolicy := workspaceID.ApplyT(func(id string) (string, error) {
+		arn := fmt.Sprintf("arn:aws:grafana:%s:%s:/workspaces/%s", region.Name, identity.AccountId, id)
+		return NewPolicyDocument(Allow([]string{"grafana:CreateWorkspaceApiKey", "grafana:DeleteWorkspaceApiKey"}, arn)).JSON()
+	}).(pulumi.StringOutput)
+	provisionerPolicy, err := iam.NewRolePolicy(ctx, "grafana-provisioner-policy", &iam.RolePolicyArgs{
+		Role:   provisionerRole.ID(),
+		Policy: keysPolicy,
+	})
+	if err != nil {
+		return err
+	}
+	fn, err := lambda.NewFunction(ctx, "grafana-provisioner", &lambda.FunctionArgs{
+		Description: pulumi.String("Provisions the data sources and the dashboard of the Grafana workspace"),
+		Runtime:     pulumi.String(lambdaRuntime),
+		Handler:     pulumi.String("index.handler"),
+		Role:        provisionerRole.Arn,
+		Timeout:     pulumi.Int(60),
+		Code: pulumi.NewAssetArchive(map[string]interface{}{
+			"index.py": pulumi.NewStringAsset(grafanaProvisioner),
+		}),
+	}, pulumi.DependsOn([]pulumi.Resource{provisionerPolicy}))
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	prometheusEndpoint := pulumi.String("").ToStringOutput()
+	if conf.ManagedPrometheus != nil {
+		prometheusEndpoint = conf.prometheusEndpoint
+	}
+	input := pulumi.All(workspaceID, endpoint, prometheusEndpoint, loadBalancer.ArnSuffix, cluster.Name).ApplyT(func(args []interface{}) (string, error) {
+		prometheus := args[2].(string)
+		b, err := json.Marshal(map[string]interface{}{
+			"workspaceId": args[0].(string),
+			"endpoint":    args[1].(string),
+			"dataSources": grafanaDataSources(region.Name, prometheus),
+			"dashboard":   grafanaDashboard(ctx, args[3].(string), args[4].(string), names, prometheus != ""),
+		})
+		return string(b), err
+	}).(pulumi.StringOutput)
+	_, err = lambda.NewInvocation(ctx, "grafana-provisioning", &lambda.InvocationArgs{
+		FunctionName: fn.Name,
+		Input:        input,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Export("grafanaUrl", pulumi.Sprintf("https://%s", endpoint))
+	return nil
+}
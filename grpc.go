@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// usesGRPC reports whether any of apps serves gRPC.
+func usesGRPC(apps []*App) bool {
+	for _, app := range apps {
+		if app.grpc {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGRPC checks that gRPC requests can reach Traefik. Clients only
+// speak HTTP/2 over TLS, which the ALB terminates with certificateArn, or
+// passes through to Traefik with ACME.
+func validateGRPC(apps []*App, conf *stackConfig) error {
+	for _, app := range apps {
+		if app.grpc && len(conf.CertificateArns) == 0 && conf.ACME == nil {
+			return fmt.Errorf("app %s: gRPC needs certificateArn or acme", app.Name)
+		}
+	}
+	return nil
+}
+
+// createGRPCTargetGroup creates the target group the HTTPS listener forwards
+// gRPC requests to. Only target groups of protocol version GRPC get HTTP/2
+// with trailers from the ALB, and those only get gRPC requests, so the
+// other requests keep going to the web target group.
+func createGRPCTargetGroup(ctx *pulumi.Context, vpc *ec2.LookupVpcResult, conf *stackConfig) (*elb.TargetGroup, error) {
+	return elb.NewTargetGroup(ctx, "traefik-grpc-tg", &elb.TargetGroupArgs{
+		Name:                       conf.physicalName("traefik-grpc"),
+		LoadBalancingAlgorithmType: pulumi.String(conf.LoadBalancingAlgorithm),
+		DeregistrationDelay:        pulumi.Int(conf.DeregistrationDelay),
+		SlowStart:                  pulumi.Int(conf.SlowStart),
+		Port:                       pulumi.Int(80),
+		Protocol:                   pulumi.String("HTTP"),
+		ProtocolVersion:            pulumi.String("GRPC"),
+		TargetType:                 pulumi.String("ip"),
+		VpcId:                      pulumi.String(vpc.Id),
+		// The health check is a gRPC call too, but the ping endpoint isn't a
+		// gRPC service and answers without a gRPC status. Any status counts,
+		// so a task is healthy as long as Traefik answers.
+		HealthCheck: elb.TargetGroupHealthCheckArgs{
+			Port:    pulumi.Sprintf("%d", conf.HealthPort),
+			Path:    pulumi.String("/ping"),
+			Matcher: pulumi.String("0-99"),
+		},
+	})
+}
+
+// createGRPCListenerRule forwards the requests of the HTTPS listener with a
+// gRPC content type to grpcTg.
+func createGRPCListenerRule(ctx *pulumi.Context, listener *elb.Listener, grpcTg *elb.TargetGroup) error {
+	_, err := elb.NewListenerRule(ctx, "traefik-grpc-rule", &elb.ListenerRuleArgs{
+		ListenerArn: listener.Arn,
+		Priority:    pulumi.Int(1),
+		Conditions: elb.ListenerRuleConditionArray{
+			elb.ListenerRuleConditionArgs{
+				HttpHeader: elb.ListenerRuleConditionHttpHeaderArgs{
+					HttpHeaderName: pulumi.String("Content-Type"),
+					Values:         pulumi.StringArray{pulumi.String("application/grpc*")},
+				},
+			},
+		},
+		Actions: elb.ListenerRuleActionArray{
+			elb.ListenerRuleActionArgs{
+				Type:           pulumi.String("forward"),
+				TargetGroupArn: grpcTg.Arn,
+			},
+		},
+	})
+	return err
+}
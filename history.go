@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// deploymentManifest describes what a deployment actually ran: the container
+// definitions of every task family and, per container, the image pinned to
+// the digest it resolved to at deploy time.
+type deploymentManifest struct {
+	ContainerDefinitions map[string]string            `json:"containerDefinitions"`
+	Images               map[string]map[string]string `json:"images"`
+}
+
+// The manifest lives in a single SSM parameter. Parameter Store keeps every
+// version of it, and the version number is what we hand out as deployment id.
+func manifestParameterName(ctx *pulumi.Context) string {
+	return fmt.Sprintf("/%s/%s/deployment-manifest", ctx.Project(), ctx.Stack())
+}
+
+// recordManifest stores the resolved manifest once the resources in deps have
+// been deployed successfully.
+func recordManifest(
+	ctx *pulumi.Context,
+	containerDefs map[string]pulumi.StringOutput,
+	deps []pulumi.Resource,
+	conf *stackConfig,
+) (*ssm.Parameter, error) {
+	var families []string
+	for family := range containerDefs {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	var defs []interface{}
+	for _, family := range families {
+		defs = append(defs, containerDefs[family])
+	}
+
+	manifest := pulumi.All(defs...).ApplyT(func(defs []interface{}) (string, error) {
+		m := deploymentManifest{
+			ContainerDefinitions: map[string]string{},
+			Images:               map[string]map[string]string{},
+		}
+		for i, family := range families {
+			def := defs[i].(string)
+
+			var containers []struct {
+				Name  string `json:"name"`
+				Image string `json:"image"`
+			}
+			if err := json.Unmarshal([]byte(def), &containers); err != nil {
+				return "", fmt.Errorf("parsing %s container definitions: %w", family, err)
+			}
+
+			m.ContainerDefinitions[family] = def
+			m.Images[family] = map[string]string{}
+			for _, c := range containers {
+				digest, err := resolveImageDigest(ctx, c.Image, conf)
+				if err != nil {
+					return "", err
+				}
+				m.Images[family][c.Name] = pinImage(c.Image, digest)
+			}
+		}
+
+		b, err := json.Marshal(m)
+		return string(b), err
+	}).(pulumi.StringOutput)
+
+	return ssm.NewParameter(ctx, "deployment-manifest", &ssm.ParameterArgs{
+		Name:        pulumi.String(manifestParameterName(ctx)),
+		Description: pulumi.String("Resolved container definitions and image digests of the last deployment"),
+		Type:        pulumi.String("String"),
+		Tier:        pulumi.String("Intelligent-Tiering"),
+		Value:       manifest,
+	}, pulumi.DependsOn(deps))
+}
+
+// loadManifest reads back the manifest recorded by deployment id.
+func loadManifest(ctx *pulumi.Context, id string, conf *stackConfig) (*deploymentManifest, error) {
+	param, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{
+		Name: manifestParameterName(ctx) + ":" + id,
+	}, conf.invokeOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("looking up deployment %s: %w", id, err)
+	}
+
+	var m deploymentManifest
+	if err := json.Unmarshal([]byte(param.Value), &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest of deployment %s: %w", id, err)
+	}
+	return &m, nil
+}
+
+// containerDefinitions returns the recorded container definitions of family
+// with every image replaced by its pinned digest, so a rollback brings back
+// the exact images even when their tags have since moved. It reports false
+// if the deployment had no such task family.
+func (m *deploymentManifest) containerDefinitions(family string) (string, bool, error) {
+	def, ok := m.ContainerDefinitions[family]
+	if !ok {
+		return "", false, nil
+	}
+
+	var containers []map[string]interface{}
+	if err := json.Unmarshal([]byte(def), &containers); err != nil {
+		return "", false, fmt.Errorf("parsing %s container definitions: %w", family, err)
+	}
+	for _, c := range containers {
+		name, _ := c["name"].(string)
+		if image, ok := m.Images[family][name]; ok {
+			c["image"] = image
+		}
+	}
+
+	b, err := json.Marshal(containers)
+	return string(b), true, err
+}
+
+// taskContainerDefinitions are the container definitions of the task
+// definition of family: defs or, with rollbackTo, the ones the deployment
+// recorded. A family the deployment didn't have, such as one added since,
+// keeps defs.
+func (c *stackConfig) taskContainerDefinitions(ctx *pulumi.Context, family string, defs pulumi.StringInput) (pulumi.StringInput, error) {
+	if c.rollback != nil {
+		recorded, ok, err := c.rollback.containerDefinitions(family)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			defs = pulumi.String(recorded)
+		} else {
+			ctx.Log.Warn(fmt.Sprintf("deployment %s has no task family %s, which keeps its current container definitions", c.RollbackTo, family), nil)
+		}
+	}
+	return c.pinImages(ctx, c.withOpenSearchHost(defs)), nil
+}
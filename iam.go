@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// PolicyDocument is an IAM policy document. The stack builds its policies
+// from these rather than from JSON strings, so that a malformed statement
+// fails the preview instead of the deployment.
+type PolicyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a statement of a PolicyDocument. Trust policies name a
+// Principal and no Resource, key policies both, the other policies only a
+// Resource.
+type PolicyStatement struct {
+	Sid       string                         `json:"Sid,omitempty"`
+	Effect    string                         `json:"Effect"`
+	Principal map[string][]string            `json:"Principal,omitempty"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource,omitempty"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+// actionPattern matches IAM actions, such as ecs:DescribeTasks or s3:Get*.
+var actionPattern = regexp.MustCompile(`^[a-z0-9-]+:[A-Za-z0-9*]+$`)
+
+// NewPolicyDocument returns a policy document of the current version with
+// statements.
+func NewPolicyDocument(statements ...PolicyStatement) PolicyDocument {
+	return PolicyDocument{Version: "2012-10-17", Statement: statements}
+}
+
+// Allow is a statement allowing actions on resources.
+func Allow(actions []string, resources ...string) PolicyStatement {
+	return PolicyStatement{Effect: "Allow", Action: actions, Resource: resources}
+}
+
+// assumeRolePolicy is the trust policy of a role the AWS service service,
+// such as ecs-tasks.amazonaws.com, assumes.
+func assumeRolePolicy(service string) (pulumi.StringInput, error) {
+	policy, err := NewPolicyDocument(PolicyStatement{
+		Effect:    "Allow",
+		Principal: map[string][]string{"Service": {service}},
+		Action:    []string{"sts:AssumeRole"},
+	}).JSON()
+	return pulumi.String(policy), err
+}
+
+// validate checks the statements of d.
+func (d PolicyDocument) validate() error {
+	if len(d.Statement) == 0 {
+		return fmt.Errorf("policy has no statements")
+	}
+	for i, s := range d.Statement {
+		if s.Effect != "Allow" && s.Effect != "Deny" {
+			return fmt.Errorf("policy statement %d: the effect must be Allow or Deny, got %q", i, s.Effect)
+		}
+		if len(s.Action) == 0 {
+			return fmt.Errorf("policy statement %d has no actions", i)
+		}
+		for _, action := range s.Action {
+			if action != "*" && !actionPattern.MatchString(action) {
+				return fmt.Errorf("policy statement %d: malformed action %q", i, action)
+			}
+		}
+		if len(s.Principal) == 0 && len(s.Resource) == 0 {
+			return fmt.Errorf("policy statement %d needs a principal or resources", i)
+		}
+		for _, resource := range s.Resource {
+			if resource == "" {
+				return fmt.Errorf("policy statement %d has an empty resource", i)
+			}
+		}
+	}
+	return nil
+}
+
+// JSON checks d and renders it for IAM.
+func (d PolicyDocument) JSON() (string, error) {
+	if err := d.validate(); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(d)
+	return string(b), err
+}
+
+// permissionsBoundaryPattern matches the ARNs of managed policies.
+var permissionsBoundaryPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(aws|\d{12}):policy/.+$`)
+
+// permissionsBoundary bounds the permissions of the roles the stack creates,
+// or is nil without permissionsBoundary.
+func (c *stackConfig) permissionsBoundary() pulumi.StringPtrInput {
+	if c.PermissionsBoundary == "" {
+		return nil
+	}
+	return pulumi.String(c.PermissionsBoundary)
+}
+
+var (
+	// iamPathPattern matches IAM paths, such as /service-roles/.
+	iamPathPattern = regexp.MustCompile(`^/([\w+=,.@-]+/)*$`)
+	// iamPrefixPattern matches the prefixes of the names of roles and
+	// policies, leaving room for the suffix Terraform generates.
+	iamPrefixPattern = regexp.MustCompile(`^[\w+=,.@-]{1,38}$`)
+)
+
+// validateIAM checks the path and name prefixes of iam.
+func validateIAM(conf *stackConfig) error {
+	i := conf.IAM
+	if i.Path != "" && (len(i.Path) > 512 || !iamPathPattern.MatchString(i.Path)) {
+		return fmt.Errorf("iam.path must start and end with / and have letters, numbers and +=,.@_- in between, got %q", i.Path)
+	}
+	for key, prefix := range map[string]string{"rolePrefix": i.RolePrefix, "policyPrefix": i.PolicyPrefix} {
+		if prefix != "" && !iamPrefixPattern.MatchString(prefix) {
+			return fmt.Errorf("iam.%s must be up to 38 letters, numbers and +=,.@_- characters, got %q", key, prefix)
+		}
+	}
+	return nil
+}
+
+// iamPath is the path of the roles, policies and instance profiles of the
+// stack, or nil for / without iam.path.
+func (c *stackConfig) iamPath() pulumi.StringPtrInput {
+	if c.IAM.Path == "" {
+		return nil
+	}
+	return pulumi.String(c.IAM.Path)
+}
+
+// roleName is the name of a role named name, such as one namePrefix names,
+// prefixed with iam.rolePrefix. It is nil for the roles named by
+// roleNamePrefix, given an empty name.
+func (c *stackConfig) roleName(name string) pulumi.StringPtrInput {
+	if name == "" {
+		return nil
+	}
+	return pulumi.String(c.IAM.RolePrefix + name)
+}
+
+// roleNamePrefix has the name of a role generated from iam.rolePrefix, unless
+// it is named, or nil for Pulumi to generate it from the resource name.
+func (c *stackConfig) roleNamePrefix(name string) pulumi.StringPtrInput {
+	if name != "" || c.IAM.RolePrefix == "" {
+		return nil
+	}
+	return pulumi.String(c.IAM.RolePrefix)
+}
+
+// policyName is roleName for managed policies, with iam.policyPrefix.
+func (c *stackConfig) policyName(name string) pulumi.StringPtrInput {
+	if name == "" {
+		return nil
+	}
+	return pulumi.String(c.IAM.PolicyPrefix + name)
+}
+
+// policyNamePrefix is roleNamePrefix for managed policies, with
+// iam.policyPrefix.
+func (c *stackConfig) policyNamePrefix(name string) pulumi.StringPtrInput {
+	if name != "" || c.IAM.PolicyPrefix == "" {
+		return nil
+	}
+	return pulumi.String(c.IAM.PolicyPrefix)
+}
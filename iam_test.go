@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyDocumentJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		statements []PolicyStatement
+		want       string
+		err        string
+	}{
+		{
+			name:       "allow",
+			statements: []PolicyStatement{Allow([]string{"ssm:GetParameters"}, "arn:aws:ssm:eu-west-1:123456789012:parameter/traefik/*")},
+			want:       `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["ssm:GetParameters"],"Resource":["arn:aws:ssm:eu-west-1:123456789012:parameter/traefik/*"]}]}`,
+		},
+		{
+			name: "sid and condition",
+			statements: []PolicyStatement{func() PolicyStatement {
+				s := Allow([]string{"ecs:ListTasks"}, "*")
+				s.Sid = "listTasks"
+				s.Condition = map[string]map[string][]string{"ArnEquals": {"ecs:cluster": {"arn:aws:ecs:eu-west-1:123456789012:cluster/main"}}}
+				return s
+			}()},
+			want: `{"Version":"2012-10-17","Statement":[{"Sid":"listTasks","Effect":"Allow","Action":["ecs:ListTasks"],"Resource":["*"],"Condition":{"ArnEquals":{"ecs:cluster":["arn:aws:ecs:eu-west-1:123456789012:cluster/main"]}}}]}`,
+		},
+		{
+			name: "trust policy",
+			statements: []PolicyStatement{{
+				Effect:    "Allow",
+				Principal: map[string][]string{"Service": {"ecs-tasks.amazonaws.com"}},
+				Action:    []string{"sts:AssumeRole"},
+			}},
+			want: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":["ecs-tasks.amazonaws.com"]},"Action":["sts:AssumeRole"]}]}`,
+		},
+		{
+			name: "no statements",
+			err:  "policy has no statements",
+		},
+		{
+			name:       "no actions",
+			statements: []PolicyStatement{Allow(nil, "*")},
+			err:        "policy statement 0 has no actions",
+		},
+		{
+			name:       "malformed action",
+			statements: []PolicyStatement{Allow([]string{"GetParameters"}, "*")},
+			err:        `policy statement 0: malformed action "GetParameters"`,
+		},
+		{
+			name:       "no resources",
+			statements: []PolicyStatement{Allow([]string{"s3:GetObject"})},
+			err:        "policy statement 0 needs a principal or resources",
+		},
+		{
+			name:       "empty resource",
+			statements: []PolicyStatement{Allow([]string{"s3:GetObject"}, "*"), Allow([]string{"s3:PutObject"}, "")},
+			err:        "policy statement 1 has an empty resource",
+		},
+		{
+			name:       "effect",
+			statements: []PolicyStatement{{Effect: "allow", Action: []string{"s3:GetObject"}, Resource: []string{"*"}}},
+			err:        `the effect must be Allow or Deny, got "allow"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPolicyDocument(tt.statements...).JSON()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// The containers of internal apps carry the internal label. The internal
+// Traefik only routes those, and the public one never does.
+const (
+	internalLabel      = "proxy"
+	internalLabelValue = "internal"
+)
+
+// internalConfig is the configuration of the internal Traefik. It shares the
+// Traefik options of the public one, but only serves plain HTTP on the web
+// entrypoint of the internal load balancer, and routes the internal apps.
+func (c *stackConfig) internalConfig() *stackConfig {
+	internal := *c
+	internal.TraefikCanary = nil
+	internal.CertificateArns = nil
+	internal.ACME = nil
+	internal.EntryPoints = nil
+	internal.Redirects = nil
+	internal.ForwardAuth = nil
+
+	internal.Traefik.DesiredCount = c.InternalTraefik.DesiredCount
+	internal.Traefik.FileProvider = false
+	internal.Traefik.Metrics.Listener = false
+	internal.Traefik.excludedLabels = nil
+	internal.Traefik.ConstraintLabels = map[string]string{internalLabel: internalLabelValue}
+	for k, v := range c.Traefik.ConstraintLabels {
+		internal.Traefik.ConstraintLabels[k] = v
+	}
+	return &internal
+}
+
+// internalProxy is the load balancer of the internal Traefik, created ahead
+// of it so that internal apps can be routed on its host.
+type internalProxy struct {
+	lb *elb.LoadBalancer
+	tg *elb.TargetGroup
+	sg *ec2.SecurityGroup
+}
+
+// createInternalLoadBalancer creates the internal load balancer, reachable
+// from the VPC and the allowed ranges, and the security group that only lets
+// it reach the internal Traefik.
+func createInternalLoadBalancer(
+	ctx *pulumi.Context,
+	vpc *ec2.LookupVpcResult,
+	subnet *ec2.GetSubnetIdsResult,
+	conf *stackConfig,
+) (*internalProxy, error) {
+	egress := ec2.SecurityGroupEgressArray{
+		ec2.SecurityGroupEgressArgs{
+			Protocol:   pulumi.String("-1"),
+			FromPort:   pulumi.Int(0),
+			ToPort:     pulumi.Int(0),
+			CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+		},
+	}
+
+	cidrs := pulumi.StringArray{pulumi.String(vpc.CidrBlock)}
+	for _, cidr := range conf.InternalTraefik.AllowedCidrs {
+		cidrs = append(cidrs, pulumi.String(cidr))
+	}
+	lbSg, err := ec2.NewSecurityGroup(ctx, "internal-lb-sg", &ec2.SecurityGroupArgs{
+		VpcId:       pulumi.String(vpc.Id),
+		Description: pulumi.String("Allow http traffic from the VPC"),
+		Egress:      egress,
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(80),
+				ToPort:     pulumi.Int(80),
+				CidrBlocks: cidrs,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ingress ec2.SecurityGroupIngressArray
+	for _, port := range []int{80, conf.HealthPort} {
+		ingress = append(ingress, ec2.SecurityGroupIngressArgs{
+			Protocol:       pulumi.String("tcp"),
+			FromPort:       pulumi.Int(port),
+			ToPort:         pulumi.Int(port),
+			SecurityGroups: pulumi.StringArray{lbSg.ID().ToStringOutput()},
+		})
+	}
+	sg, err := ec2.NewSecurityGroup(ctx, "traefik-internal-sg", &ec2.SecurityGroupArgs{
+		VpcId:       pulumi.String(vpc.Id),
+		Description: pulumi.String("Allow http traffic from the internal ALB"),
+		Egress:      egress,
+		Ingress:     ingress,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var idleTimeout pulumi.IntPtrInput
+	if conf.Timeouts != nil {
+		idleTimeout = pulumi.Int(conf.Timeouts.Idle)
+	}
+	lb, err := elb.NewLoadBalancer(ctx, "internal-lb", &elb.LoadBalancerArgs{
+		Internal:       pulumi.Bool(true),
+		Subnets:        toPulumiStringArray(subnet.Ids),
+		SecurityGroups: pulumi.StringArray{lbSg.ID().ToStringOutput()},
+		IdleTimeout:    idleTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tg, err := newTraefikTargetGroup(ctx, "traefik-internal-tg", "traefik-internal", 80, vpc, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elb.NewListener(ctx, "internal-listener", &elb.ListenerArgs{
+		LoadBalancerArn: lb.Arn,
+		Port:            pulumi.Int(80),
+		DefaultActions: elb.ListenerDefaultActionArray{
+			elb.ListenerDefaultActionArgs{
+				Type:           pulumi.String("forward"),
+				TargetGroupArn: tg.Arn,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &internalProxy{lb: lb, tg: tg, sg: sg}, nil
+}
+
+// createInternalTraefik runs the internal Traefik behind the internal load
+// balancer.
+func createInternalTraefik(
+	ctx *pulumi.Context,
+	subnet *ec2.GetSubnetIdsResult,
+	internal *internalProxy,
+	cluster *ecs.Cluster,
+	containerDef pulumi.StringOutput,
+	ecsRole *iam.Role,
+	traefikRole *iam.Role,
+	conf *stackConfig,
+) (*ecs.TaskDefinition, *ecs.Service, error) {
+	containerDefs, err := conf.taskContainerDefinitions(ctx, "traefik-internal", containerDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	task, err := ecs.NewTaskDefinition(ctx, "traefik-internal-task", &ecs.TaskDefinitionArgs{
+		Family:                  pulumi.String("traefik-internal"),
+		ContainerDefinitions:    containerDefs,
+		Cpu:                     conf.taskCPU("traefik-internal"),
+		Memory:                  conf.taskMemory("traefik-internal"),
+		NetworkMode:             pulumi.String("awsvpc"),
+		RequiresCompatibilities: conf.compatibilities(),
+		RuntimePlatform:         conf.traefikPlatform().args(),
+		EphemeralStorage:        conf.ephemeralStorage("traefik-internal"),
+		ExecutionRoleArn:        ecsRole.Arn,
+		TaskRoleArn:             traefikRole.Arn,
+		Tags:                    conf.resourceTags(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service, err := ecs.NewService(ctx, "traefik-internal-service", &ecs.ServiceArgs{
+		Name: pulumi.String("traefik-internal"),
+
+		Cluster:        cluster.Arn,
+		TaskDefinition: task.Arn,
+
+		DesiredCount:                    pulumi.Int(conf.InternalTraefik.DesiredCount),
+		LaunchType:                      conf.launchType(),
+		PlatformVersion:                 conf.platformVersion(),
+		CapacityProviderStrategies:      conf.capacityProviderStrategies(),
+		DeploymentMinimumHealthyPercent: conf.minimumHealthyPercent("traefik-internal"),
+		DeploymentMaximumPercent:        conf.maximumPercent("traefik-internal"),
+		DeploymentCircuitBreaker:        conf.circuitBreaker(),
+		ForceNewDeployment:              conf.forceNewDeployment(),
+		EnableExecuteCommand:            conf.enableExecuteCommand("traefik-internal"),
+		PlacementConstraints:            conf.placementConstraints("traefik-internal"),
+		OrderedPlacementStrategies:      conf.placementStrategies("traefik-internal"),
+		PropagateTags:                   conf.propagateTags(),
+		EnableEcsManagedTags:            pulumi.Bool(true),
+		Tags:                            conf.resourceTags(),
+		HealthCheckGracePeriodSeconds:   conf.healthCheckGracePeriod("traefik-internal"),
+
+		LoadBalancers: ecs.ServiceLoadBalancerArray{
+			ecs.ServiceLoadBalancerArgs{
+				TargetGroupArn: internal.tg.Arn,
+				ContainerName:  pulumi.String("traefik"),
+				ContainerPort:  pulumi.Int(80),
+			},
+		},
+
+		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
+			AssignPublicIp: conf.assignPublicIP(),
+			Subnets:        conf.taskSubnets(subnet),
+			SecurityGroups: pulumi.StringArray{internal.sg.ID().ToStringOutput()},
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{internal.tg}))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return task, service, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/kms"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// kmsKeyArnPattern matches the ARNs of KMS keys, not those of their aliases.
+var kmsKeyArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:kms:[a-z0-9-]+:\d{12}:key/.+$`)
+
+// validateKMS checks that the stack either creates its key or uses an
+// existing one, and fills in the deletion window.
+func validateKMS(conf *stackConfig) error {
+	k := conf.KMS
+	if k.Create == (k.KeyArn != "") {
+		return fmt.Errorf("kms needs either create or the keyArn of an existing key")
+	}
+	if k.KeyArn != "" {
+		if !kmsKeyArnPattern.MatchString(k.KeyArn) {
+			return fmt.Errorf("kms.keyArn must be the ARN of a key, got %q", k.KeyArn)
+		}
+		if k.DeletionWindow != 0 {
+			return fmt.Errorf("kms.deletionWindow can't be set for an existing key")
+		}
+		return nil
+	}
+	if k.DeletionWindow == 0 {
+		k.DeletionWindow = 30
+	}
+	if k.DeletionWindow < 7 || k.DeletionWindow > 30 {
+		return fmt.Errorf("kms.deletionWindow must be between 7 and 30 days, got %d", k.DeletionWindow)
+	}
+	return nil
+}
+
+// keyPolicy is the key policy of the stack's key. The account's IAM policies
+// grant the roles of the stack its use for secrets and ECS Exec, and
+// CloudWatch Logs may use it for the log groups of the account.
+func keyPolicy(account, region string) (string, error) {
+	root := PolicyStatement{
+		Sid:       "account",
+		Effect:    "Allow",
+		Principal: map[string][]string{"AWS": {fmt.Sprintf("arn:aws:iam::%s:root", account)}},
+		Action:    []string{"kms:*"},
+		Resource:  []string{"*"},
+	}
+	logs := PolicyStatement{
+		Sid:       "logs",
+		Effect:    "Allow",
+		Principal: map[string][]string{"Service": {fmt.Sprintf("logs.%s.amazonaws.com", region)}},
+		Action: []string{
+			"kms:Encrypt*",
+			"kms:Decrypt*",
+			"kms:ReEncrypt*",
+			"kms:GenerateDataKey*",
+			"kms:Describe*",
+		},
+		Resource: []string{"*"},
+		Condition: map[string]map[string][]string{
+			"ArnLike": {"kms:EncryptionContext:aws:logs:arn": {fmt.Sprintf("arn:aws:logs:%s:%s:log-group:*", region, account)}},
+		},
+	}
+	return NewPolicyDocument(root, logs).JSON()
+}
+
+// createKMSKey creates the stack's key with its alias, or takes the existing
+// one of kms.keyArn.
+func createKMSKey(ctx *pulumi.Context, conf *stackConfig) error {
+	k := conf.KMS
+	if k.KeyArn != "" {
+		conf.kmsKeyArn = pulumi.String(k.KeyArn).ToStringOutput()
+		return nil
+	}
+
+	identity, err := aws.GetCallerIdentity(ctx, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+	region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+	policy, err := keyPolicy(identity.AccountId, region.Name)
+	if err != nil {
+		return err
+	}
+
+	key, err := kms.NewKey(ctx, "stack-key", &kms.KeyArgs{
+		Description:          pulumi.Sprintf("Logs, secrets and ECS Exec sessions of %s/%s", ctx.Project(), ctx.Stack()),
+		DeletionWindowInDays: pulumi.Int(k.DeletionWindow),
+		EnableKeyRotation:    pulumi.Bool(true),
+		Policy:               pulumi.String(policy),
+		Tags:                 conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = kms.NewAlias(ctx, "stack-key-alias", &kms.AliasArgs{
+		Name:        pulumi.Sprintf("alias/%s-%s", ctx.Project(), ctx.Stack()),
+		TargetKeyId: key.KeyId,
+	})
+	if err != nil {
+		return err
+	}
+	conf.kmsKeyArn = key.Arn
+	ctx.Export("kmsKeyArn", key.Arn)
+	return nil
+}
+
+// kmsKey is the key the log groups and the secrets of the stack are encrypted
+// with, or nil without kms.
+func (c *stackConfig) kmsKey() pulumi.StringPtrInput {
+	if c.KMS == nil {
+		return nil
+	}
+	return c.kmsKeyArn
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// ecsOptimizedAMIs are the public parameters with the latest ECS-optimized
+// Amazon Linux 2 AMIs of the region, by CPU architecture.
+var ecsOptimizedAMIs = map[string]string{
+	"X86_64": "/aws/service/ecs/optimized-ami/amazon-linux-2/recommended/image_id",
+	"ARM64":  "/aws/service/ecs/optimized-ami/amazon-linux-2/arm64/recommended/image_id",
+}
+
+// ecsGPUOptimizedAMI is the public parameter with the latest ECS GPU-optimized
+// Amazon Linux 2 AMI of the region, which comes with the NVIDIA drivers and
+// container runtime. It is only built for X86_64.
+const ecsGPUOptimizedAMI = "/aws/service/ecs/optimized-ami/amazon-linux-2/gpu/recommended/image_id"
+
+// bottlerocketAMIs are the public parameters with the latest Bottlerocket
+// AMIs for ECS of the region, by CPU architecture.
+var bottlerocketAMIs = map[string]string{
+	"X86_64": "/aws/service/bottlerocket/aws-ecs-2/x86_64/latest/image_id",
+	"ARM64":  "/aws/service/bottlerocket/aws-ecs-2/arm64/latest/image_id",
+}
+
+// bottlerocketGPUAMIs are those of the Bottlerocket variant with the NVIDIA
+// drivers.
+var bottlerocketGPUAMIs = map[string]string{
+	"X86_64": "/aws/service/bottlerocket/aws-ecs-2-nvidia/x86_64/latest/image_id",
+	"ARM64":  "/aws/service/bottlerocket/aws-ecs-2-nvidia/arm64/latest/image_id",
+}
+
+// platformVersionPattern matches pinned Fargate platform versions.
+var platformVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// compatibilities are the launch types the task definitions are valid for.
+func (c *stackConfig) compatibilities() pulumi.StringArray {
+	return pulumi.StringArray{pulumi.String(c.LaunchType)}
+}
+
+// launchType is the launch type of the services on Fargate. On EC2, they use
+// the capacity provider instead.
+func (c *stackConfig) launchType() pulumi.StringPtrInput {
+	if c.LaunchType == "EC2" {
+		return nil
+	}
+	return pulumi.String(c.LaunchType)
+}
+
+// platformVersion is the Fargate platform version of the services. Tasks on
+// EC2 have none.
+func (c *stackConfig) platformVersion() pulumi.StringPtrInput {
+	if c.LaunchType == "EC2" {
+		return nil
+	}
+	return pulumi.String(c.PlatformVersion)
+}
+
+// capacityProviderStrategies place the tasks of the services on the EC2
+// capacity provider.
+func (c *stackConfig) capacityProviderStrategies() ecs.ServiceCapacityProviderStrategyArrayInput {
+	if c.LaunchType != "EC2" {
+		return nil
+	}
+	return ecs.ServiceCapacityProviderStrategyArray{
+		ecs.ServiceCapacityProviderStrategyArgs{
+			CapacityProvider: c.capacityProvider,
+			Weight:           pulumi.Int(1),
+		},
+	}
+}
+
+// assignPublicIP tells whether the tasks get public IPs, which they need on
+// Fargate to pull images from the default VPC's public subnets. Tasks on
+// EC2 can't have one.
+func (c *stackConfig) assignPublicIP() pulumi.BoolPtrInput {
+	return pulumi.Bool(c.LaunchType != "EC2")
+}
+
+// taskSubnets are the subnets of the tasks. On EC2, they go into the subnets
+// of the instances, which route to the internet through a NAT gateway.
+func (c *stackConfig) taskSubnets(subnet *ec2.GetSubnetIdsResult) pulumi.StringArrayInput {
+	if c.LaunchType == "EC2" {
+		return toPulumiStringArray(c.EC2.SubnetIds)
+	}
+	return toPulumiStringArray(subnet.Ids)
+}
+
+// createEC2Capacity runs ECS-optimized instances in an Auto Scaling group
+// that a capacity provider of cluster scales with the tasks placed on it,
+// and makes it the capacity provider of the services.
+func createEC2Capacity(ctx *pulumi.Context, vpc *ec2.LookupVpcResult, cluster *ecs.Cluster, conf *stackConfig) error {
+	ami, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{Name: conf.amiParameter()}, conf.invokeOptions()...)
+	if err != nil {
+		return fmt.Errorf("looking up the %s AMI: %w", conf.EC2.OS, err)
+	}
+
+	ec2Trust, err := assumeRolePolicy("ec2.amazonaws.com")
+	if err != nil {
+		return err
+	}
+	instanceRole, err := iam.NewRole(ctx, "ecs-instance-role", &iam.RoleArgs{
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    ec2Trust,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicyAttachment(ctx, "ecs-instance-policy", &iam.RolePolicyAttachmentArgs{
+		Role:      instanceRole.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/service-role/AmazonEC2ContainerServiceforEC2Role"),
+	})
+	if err != nil {
+		return err
+	}
+	// Bottlerocket has no SSH, operators reach its control container with
+	// Session Manager.
+	if conf.EC2.OS == "bottlerocket" {
+		_, err = iam.NewRolePolicyAttachment(ctx, "ecs-instance-ssm-policy", &iam.RolePolicyAttachmentArgs{
+			Role:      instanceRole.Name,
+			PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	profile, err := iam.NewInstanceProfile(ctx, "ecs-instance-profile", &iam.InstanceProfileArgs{
+		Role: instanceRole.Name,
+		Path: conf.iamPath(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The tasks have network interfaces and security groups of their own,
+	// so the instances only talk to ECS.
+	instanceSg, err := ec2.NewSecurityGroup(ctx, "ecs-instance-sg", &ec2.SecurityGroupArgs{
+		VpcId:       pulumi.String(vpc.Id),
+		Description: pulumi.String("ECS container instances"),
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	userData := cluster.Name.ApplyT(func(name string) string {
+		return base64.StdEncoding.EncodeToString([]byte(conf.instanceUserData(name)))
+	}).(pulumi.StringOutput)
+
+	template, err := ec2.NewLaunchTemplate(ctx, "ecs-launch-template", &ec2.LaunchTemplateArgs{
+		ImageId:      pulumi.String(ami.Value),
+		InstanceType: pulumi.String(conf.EC2.InstanceType),
+		IamInstanceProfile: ec2.LaunchTemplateIamInstanceProfileArgs{
+			Arn: profile.Arn,
+		},
+		VpcSecurityGroupIds: pulumi.StringArray{instanceSg.ID()},
+		UserData:            userData,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The capacity provider sets the desired capacity, and only manages
+	// groups tagged as managed by ECS.
+	group, err := autoscaling.NewGroup(ctx, "ecs-asg", &autoscaling.GroupArgs{
+		VpcZoneIdentifiers: toPulumiStringArray(conf.EC2.SubnetIds),
+		MinSize:            pulumi.Int(conf.EC2.MinSize),
+		MaxSize:            pulumi.Int(conf.EC2.MaxSize),
+		LaunchTemplate: autoscaling.GroupLaunchTemplateArgs{
+			Id:      template.ID(),
+			Version: pulumi.String("$Latest"),
+		},
+		Tags: autoscaling.GroupTagArray{
+			autoscaling.GroupTagArgs{
+				Key:               pulumi.String("AmazonECSManaged"),
+				Value:             pulumi.String("true"),
+				PropagateAtLaunch: pulumi.Bool(true),
+			},
+		},
+	}, pulumi.IgnoreChanges([]string{"desiredCapacity"}))
+	if err != nil {
+		return err
+	}
+
+	provider, err := ecs.NewCapacityProvider(ctx, "ecs-capacity-provider", &ecs.CapacityProviderArgs{
+		AutoScalingGroupProvider: ecs.CapacityProviderAutoScalingGroupProviderArgs{
+			AutoScalingGroupArn: group.Arn,
+			ManagedScaling: ecs.CapacityProviderAutoScalingGroupProviderManagedScalingArgs{
+				Status:         pulumi.String("ENABLED"),
+				TargetCapacity: pulumi.Int(conf.EC2.TargetCapacity),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	providers, err := ecs.NewClusterCapacityProviders(ctx, "ecs-cluster-capacity-providers", &ecs.ClusterCapacityProvidersArgs{
+		ClusterName:       cluster.Name,
+		CapacityProviders: pulumi.StringArray{provider.Name},
+		DefaultCapacityProviderStrategies: ecs.ClusterCapacityProvidersDefaultCapacityProviderStrategyArray{
+			ecs.ClusterCapacityProvidersDefaultCapacityProviderStrategyArgs{
+				CapacityProvider: provider.Name,
+				Weight:           pulumi.Int(1),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Going through the association makes the services wait for it.
+	conf.capacityProvider = providers.CapacityProviders.Index(pulumi.Int(0))
+	return nil
+}
+
+// amiParameter is the public parameter with the latest AMI of the instances.
+func (c *stackConfig) amiParameter() string {
+	arch := c.instanceArchitecture()
+	switch {
+	case c.EC2.OS == "bottlerocket" && c.EC2.GPU:
+		return bottlerocketGPUAMIs[arch]
+	case c.EC2.OS == "bottlerocket":
+		return bottlerocketAMIs[arch]
+	case c.EC2.GPU:
+		return ecsGPUOptimizedAMI
+	}
+	return ecsOptimizedAMIs[arch]
+}
+
+// instanceUserData joins the instances to the cluster named cluster, with a
+// shell script on Amazon Linux and with TOML settings on Bottlerocket.
+func (c *stackConfig) instanceUserData(cluster string) string {
+	if c.EC2.OS == "bottlerocket" {
+		return fmt.Sprintf("[settings.ecs]\ncluster = %q\n", cluster)
+	}
+	return fmt.Sprintf("#!/bin/bash\necho ECS_CLUSTER=%s >> /etc/ecs/ecs.config\n", cluster)
+}
+
+// validateLaunchType checks the Fargate platform version, reads the EC2
+// options of the EC2 launch type and fills in the defaults.
+func validateLaunchType(cfg *config.Config, conf *stackConfig) error {
+	switch conf.LaunchType {
+	case "":
+		conf.LaunchType = "FARGATE"
+	case "FARGATE", "EC2":
+	default:
+		return fmt.Errorf("launchType must be FARGATE or EC2, got %s", conf.LaunchType)
+	}
+
+	if conf.LaunchType == "EC2" && conf.PlatformVersion != "" {
+		return fmt.Errorf("platformVersion requires launchType FARGATE")
+	}
+	if conf.PlatformVersion == "" {
+		conf.PlatformVersion = "LATEST"
+	}
+	if conf.PlatformVersion != "LATEST" && !platformVersionPattern.MatchString(conf.PlatformVersion) {
+		return fmt.Errorf("platformVersion must be LATEST or a version such as 1.4.0, got %q", conf.PlatformVersion)
+	}
+
+	if err := cfg.GetObject("ec2", &conf.EC2); err != nil {
+		return err
+	}
+	if conf.LaunchType != "EC2" {
+		if conf.EC2 != nil {
+			return fmt.Errorf("ec2 requires launchType EC2")
+		}
+		return nil
+	}
+
+	e := conf.EC2
+	if e == nil || len(e.SubnetIds) == 0 {
+		return fmt.Errorf("launchType EC2 requires ec2.subnetIds")
+	}
+	switch e.OS {
+	case "":
+		e.OS = "amazon-linux-2"
+	case "amazon-linux-2", "bottlerocket":
+	default:
+		return fmt.Errorf("ec2.os must be amazon-linux-2 or bottlerocket, got %q", e.OS)
+	}
+	if e.GPU && e.OS == "amazon-linux-2" && conf.instanceArchitecture() != "X86_64" {
+		return fmt.Errorf("ec2.gpu: the GPU-optimized Amazon Linux 2 AMI is only built for X86_64")
+	}
+	if e.InstanceType == "" {
+		e.InstanceType = "t3.medium"
+		if e.GPU {
+			e.InstanceType = "g4dn.xlarge"
+		}
+	}
+	if e.MinSize == 0 {
+		e.MinSize = 1
+	}
+	if e.MaxSize == 0 {
+		e.MaxSize = 4
+	}
+	if e.MinSize < 0 || e.MaxSize < e.MinSize {
+		return fmt.Errorf("ec2.maxSize must be at least ec2.minSize, got %d and %d", e.MaxSize, e.MinSize)
+	}
+	if e.TargetCapacity == 0 {
+		e.TargetCapacity = 100
+	}
+	if e.TargetCapacity < 1 || e.TargetCapacity > 100 {
+		return fmt.Errorf("ec2.targetCapacity must be between 1 and 100, got %d", e.TargetCapacity)
+	}
+	return nil
+}
+
+// WithGPUs reserves count GPUs of the instance for each of the app's tasks,
+// and those of its canary. It requires the EC2 launch type with ec2.gpu.
+func (a *App) WithGPUs(count int) *App {
+	a.gpus = count
+	return a
+}
+
+// validateGPUs checks that the apps requesting GPUs run on GPU instances.
+func validateGPUs(apps []*App, conf *stackConfig) error {
+	for _, app := range apps {
+		if app.gpus < 0 {
+			return fmt.Errorf("app %s: the GPU count must not be negative, got %d", app.Name, app.gpus)
+		}
+		if app.gpus > 0 && (conf.LaunchType != "EC2" || !conf.EC2.GPU) {
+			return fmt.Errorf("app %s: GPUs require launchType EC2 with ec2.gpu", app.Name)
+		}
+	}
+	return nil
+}
+
+// resourceRequirements are the GPUs of the app's container definition.
+func (a *App) resourceRequirements() []resourceRequirement {
+	if a.gpus == 0 {
+		return nil
+	}
+	return []resourceRequirement{{Type: "GPU", Value: fmt.Sprint(a.gpus)}}
+}
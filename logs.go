@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// validLogRetention reports whether CloudWatch Logs keeps logs for days.
+func validLogRetention(days int) bool {
+	for _, d := range logRetentionDays {
+		if days == d {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLogs checks the stack-wide and the per log group retention and
+// keys, and fills in the default retention.
+func validateLogs(conf *stackConfig) error {
+	l := &conf.Logs
+	if l.Retention == 0 {
+		l.Retention = 30
+	}
+	if !validLogRetention(l.Retention) {
+		return fmt.Errorf("logs.retention must be one of %v days, got %d", logRetentionDays, l.Retention)
+	}
+	if l.KmsKeyArn != "" && !kmsKeyArnPattern.MatchString(l.KmsKeyArn) {
+		return fmt.Errorf("logs.kmsKeyArn must be the ARN of a key, got %q", l.KmsKeyArn)
+	}
+	for name, g := range l.Groups {
+		if g.Retention != 0 && !validLogRetention(g.Retention) {
+			return fmt.Errorf("logs.groups.%s.retention must be one of %v days, got %d", name, logRetentionDays, g.Retention)
+		}
+		if g.KmsKeyArn != "" && !kmsKeyArnPattern.MatchString(g.KmsKeyArn) {
+			return fmt.Errorf("logs.groups.%s.kmsKeyArn must be the ARN of a key, got %q", name, g.KmsKeyArn)
+		}
+	}
+	return nil
+}
+
+// validateLogGroups checks that logs.groups names services of the stack or
+// its other log groups.
+func validateLogGroups(apps []*App, conf *stackConfig) error {
+	known := map[string]bool{"accessLog": true, "executeCommand": true, "fireLens": true}
+	for _, service := range conf.stackServices(apps) {
+		known[service] = true
+	}
+	for name := range conf.Logs.Groups {
+		if !known[name] {
+			return fmt.Errorf("logs.groups.%s is neither a service of the stack nor accessLog, executeCommand or fireLens", name)
+		}
+	}
+	return nil
+}
+
+// logsKmsKey is the key the log groups are encrypted with by default,
+// logs.kmsKeyArn or else the stack's key, or nil without either.
+func (c *stackConfig) logsKmsKey() pulumi.StringPtrInput {
+	if c.Logs.KmsKeyArn != "" {
+		return pulumi.String(c.Logs.KmsKeyArn)
+	}
+	return c.kmsKey()
+}
+
+// logGroupArgs are the retention and the key of the log group named group in
+// logs.groups, which default to retention and key.
+func (c *stackConfig) logGroupArgs(group string, retention int, key pulumi.StringPtrInput) *cloudwatch.LogGroupArgs {
+	args := &cloudwatch.LogGroupArgs{
+		RetentionInDays: pulumi.Int(retention),
+		KmsKeyId:        key,
+	}
+	g := c.Logs.Groups[group]
+	if g.Retention != 0 {
+		args.RetentionInDays = pulumi.Int(g.Retention)
+	}
+	if g.KmsKeyArn != "" {
+		args.KmsKeyId = pulumi.String(g.KmsKeyArn)
+	}
+	return args
+}
+
+// stackServices are the names of the ECS services of the stack.
+func (c *stackConfig) stackServices(apps []*App) []string {
+	services := []string{"traefik"}
+	if c.TraefikCanary != nil {
+		services = append(services, "traefik-canary")
+	}
+	if c.InternalTraefik != nil {
+		services = append(services, "traefik-internal")
+	}
+	if c.ForwardAuth != nil {
+		services = append(services, forwardAuthService)
+	}
+	if c.sharedACME() {
+		services = append(services, "traefik-acme")
+	}
+	for _, app := range apps {
+		services = append(services, app.Name)
+		if app.canary != nil {
+			services = append(services, app.canaryService())
+		}
+	}
+	return services
+}
+
+// serviceLogGroup is the log group of the containers of service. It is named
+// up front, as the container definitions refer to it.
+func serviceLogGroup(ctx *pulumi.Context, service string) string {
+	return fmt.Sprintf("/ecs/%s-%s/%s", ctx.Project(), ctx.Stack(), service)
+}
+
+// createLogGroups creates the log group of every service of the stack, which
+// the containers of its tasks send their logs to with the awslogs driver.
+func createLogGroups(ctx *pulumi.Context, apps []*App, conf *stackConfig) ([]*cloudwatch.LogGroup, error) {
+	region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	conf.logRegion = region.Name
+
+	conf.logGroups = map[string]string{}
+	var logGroups []*cloudwatch.LogGroup
+	for _, service := range conf.stackServices(apps) {
+		name := serviceLogGroup(ctx, service)
+		args := conf.logGroupArgs(service, conf.Logs.Retention, conf.logsKmsKey())
+		args.Name = pulumi.String(name)
+		logGroup, err := cloudwatch.NewLogGroup(ctx, service+"-log-group", args)
+		if err != nil {
+			return nil, err
+		}
+		conf.logGroups[service] = name
+		logGroups = append(logGroups, logGroup)
+	}
+	return logGroups, nil
+}
+
+// awslogsConfiguration sends the logs of container to the log group of
+// service, in streams prefixed with the container's name.
+func (c *stackConfig) awslogsConfiguration(service, container string) *logConfiguration {
+	return &logConfiguration{
+		LogDriver: "awslogs",
+		Options: map[string]string{
+			"awslogs-group":         c.logGroups[service],
+			"awslogs-region":        c.logRegion,
+			"awslogs-stream-prefix": container,
+		},
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"strings"
 
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/efs"
 	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
@@ -21,44 +24,91 @@ func toPulumiStringArray(a []string) pulumi.StringArrayInput {
 
 func main() {
 	pulumi.Run(func(ctx *pulumi.Context) error {
+		conf, err := loadConfig(ctx)
+		if err != nil {
+			return err
+		}
+
+		if conf.AssumeRole != nil {
+			err = createProvider(ctx, conf)
+			if err != nil {
+				return err
+			}
+		}
+		if len(conf.DefaultTags) > 0 {
+			err = registerDefaultTags(ctx, conf)
+			if err != nil {
+				return err
+			}
+		}
 
 		/* NETWORKING */
-		vpc, subnet, err := getNetwork(ctx)
+		vpc, subnet, err := getNetwork(ctx, conf)
 		if err != nil {
 			return err
 		}
 
-		webSg, traefikSg, containerSg, err := createSecurityGroups(ctx, vpc)
+		webSg, dashboardSg, traefikSg, err := createSecurityGroups(ctx, vpc, conf)
 		if err != nil {
 			return err
 		}
 
+		if conf.KMS != nil {
+			err = createKMSKey(ctx, conf)
+			if err != nil {
+				return err
+			}
+		}
+
 		/* ECS */
-		cluster, err := createCluster(ctx)
+		cluster, execLogs, err := createCluster(ctx, conf)
 		if err != nil {
 			return err
 		}
+		if conf.LaunchType == "EC2" {
+			err = createEC2Capacity(ctx, vpc, cluster, conf)
+			if err != nil {
+				return err
+			}
+		}
+		if conf.ServiceDiscovery != nil {
+			err = createServiceDiscovery(ctx, vpc, conf)
+			if err != nil {
+				return err
+			}
+		}
 
 		/* IAM */
-		ecsRole, traefikRole, err := createIAMRoles(ctx)
+		ecsRole, traefikRole, err := createIAMRoles(ctx, conf)
 		if err != nil {
 			return err
 		}
 
-		traefikPolicy, err := createPolicies(ctx)
-		if err != nil {
-			return err
+		if conf.GitHubActions != nil {
+			err = createGitHubDeployRole(ctx, conf)
+			if err != nil {
+				return err
+			}
 		}
 
-		// Policy Attachements
-		_, err = iam.NewRolePolicyAttachment(ctx, "ecs-policy", &iam.RolePolicyAttachmentArgs{
-			Role:      ecsRole.Name,
-			PolicyArn: pulumi.String("arn:aws:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy"),
-		})
+		traefikPolicy, err := createPolicies(ctx, cluster, conf)
 		if err != nil {
 			return err
 		}
 
+		// Policy Attachements. With strictIam, the execution role gets a
+		// policy scoped to the stack's images and log groups instead, once
+		// they are known.
+		if !conf.StrictIAM {
+			_, err = iam.NewRolePolicyAttachment(ctx, "ecs-policy", &iam.RolePolicyAttachmentArgs{
+				Role:      ecsRole.Name,
+				PolicyArn: pulumi.String("arn:aws:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy"),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		_, err = iam.NewRolePolicyAttachment(ctx, "traefil-exec-policy", &iam.RolePolicyAttachmentArgs{
 			Role:      traefikRole.Name,
 			PolicyArn: traefikPolicy.Arn,
@@ -67,67 +117,600 @@ func main() {
 			return err
 		}
 
+		if conf.tracesToXRay() {
+			err = createTracingPolicy(ctx, traefikRole)
+			if err != nil {
+				return err
+			}
+		}
+		if conf.ManagedPrometheus != nil {
+			err = createPrometheusWorkspace(ctx, traefikRole, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		/* APPS */
+
+		// Every app runs as a service of its own that Traefik routes to. Apps
+		// add Traefik middlewares with options like
+		// NewApp("whoami").WithRateLimit(100, 50). whoami is the example,
+		// which skipWhoami leaves out.
+		var apps []*App
+		if !conf.SkipWhoami {
+			whoami := NewApp("whoami")
+			whoami.Image = "containous/whoami:v1.5.0"
+			apps = append(apps, whoami)
+		}
+
+		err = registerApps(apps, conf)
+		if err != nil {
+			return err
+		}
+
+		// The apps share a security group that lets Traefik reach them.
+		var containerSg *ec2.SecurityGroup
+		if len(apps) > 0 {
+			containerSg, err = createContainerSecurityGroup(ctx, vpc, traefikSg)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = validateGRPC(apps, conf)
+		if err != nil {
+			return err
+		}
+		err = validateTargetGroups(apps, conf)
+		if err != nil {
+			return err
+		}
+		err = validateAppPlatforms(apps, conf)
+		if err != nil {
+			return err
+		}
+		err = validateVolumes(apps)
+		if err != nil {
+			return err
+		}
+		err = validateAppContainerOptions(apps, conf)
+		if err != nil {
+			return err
+		}
+		err = validateGPUs(apps, conf)
+		if err != nil {
+			return err
+		}
+		err = validateTaskPolicies(apps)
+		if err != nil {
+			return err
+		}
+		err = validateLogGroups(apps, conf)
+		if err != nil {
+			return err
+		}
+		if conf.Synthetics != nil {
+			err = validateSynthetics(apps, conf)
+			if err != nil {
+				return err
+			}
+		}
+		err = validateStackSecrets(apps, conf)
+		if err != nil {
+			return err
+		}
+		err = validateAppParameters(apps, conf)
+		if err != nil {
+			return err
+		}
+		err = createSecrets(ctx, ecsRole, conf)
+		if err != nil {
+			return err
+		}
+		err = createParameters(ctx, ecsRole, conf)
+		if err != nil {
+			return err
+		}
+
+		err = createAppTaskRoles(ctx, apps, conf)
+		if err != nil {
+			return err
+		}
+
+		// The tasks of apps and other services without a task role of their
+		// own share one for ECS Exec, volumes, FireLens and tracing.
+		volumes, appStorage := usesVolumes(apps)
+		tracing := usesTracing(apps)
+		if conf.ExecuteCommand != nil || volumes || conf.FireLens != nil || tracing {
+			appRole, err := createAppRole(ctx, conf)
+			if err != nil {
+				return err
+			}
+			if conf.FireLens != nil {
+				err = createFireLens(ctx, traefikRole, appRole, apps, conf)
+				if err != nil {
+					return err
+				}
+			}
+			if conf.ExecuteCommand != nil {
+				err = createExecPolicy(ctx, execLogs, traefikRole, appRole, apps, conf)
+				if err != nil {
+					return err
+				}
+			}
+			if volumes {
+				var storage *efs.FileSystem
+				var mountTargets []pulumi.Resource
+				if appStorage {
+					storage, mountTargets, err = createAppStorage(ctx, vpc, subnet, containerSg)
+					if err != nil {
+						return err
+					}
+					ctx.Export("appStorage", storage.ID())
+				}
+				for _, app := range apps {
+					app.taskVolumes, err = createAppVolumes(ctx, app, storage, mountTargets)
+					if err != nil {
+						return err
+					}
+				}
+				err = createAppStoragePolicies(ctx, appRole, storage, apps)
+				if err != nil {
+					return err
+				}
+				ctx.Export("appSecurityGroup", containerSg.ID())
+			}
+			if tracing {
+				err = createAppTracingPolicies(ctx, appRole, apps)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
 		/* LOAD BALANCING */
 
 		// Create a load balancer to listen for HTTP traffic on port 80.
+		var idleTimeout pulumi.IntPtrInput
+		if conf.Timeouts != nil {
+			idleTimeout = pulumi.Int(conf.Timeouts.Idle)
+		}
 		webLb, err := elb.NewLoadBalancer(ctx, "web-lb", &elb.LoadBalancerArgs{
 			Subnets:        toPulumiStringArray(subnet.Ids),
 			SecurityGroups: pulumi.StringArray{webSg.ID().ToStringOutput()},
+			IdleTimeout:    idleTimeout,
 		})
 		if err != nil {
 			return err
 		}
 
+		// Keep the dashboard off the internet behind an internal load balancer.
+		dashboardLb := webLb
+		if conf.InternalDashboard {
+			dashboardLb, err = elb.NewLoadBalancer(ctx, "dashboard-lb", &elb.LoadBalancerArgs{
+				Internal:       pulumi.Bool(true),
+				Subnets:        toPulumiStringArray(subnet.Ids),
+				SecurityGroups: pulumi.StringArray{dashboardSg.ID().ToStringOutput()},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		// Target Groups
 
-		traefikTg, traefikAPITg, err := createTargetGroups(ctx, vpc)
+		traefikTg, traefikAPITg, err := createTargetGroups(ctx, vpc, conf)
 		if err != nil {
 			return err
 		}
 
+		// A canary Traefik gets its own target group on the public listeners.
+		var canaryTg *elb.TargetGroup
+		if conf.TraefikCanary != nil {
+			canaryTg, err = newTraefikTargetGroup(ctx, "traefik-canary-tg", "traefik-canary", 80, vpc, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		// gRPC apps behind the HTTPS listener get a target group of their own.
+		var grpcTg *elb.TargetGroup
+		if usesGRPC(apps) && len(conf.CertificateArns) > 0 {
+			grpcTg, err = createGRPCTargetGroup(ctx, vpc, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Internal apps get a Traefik of their own behind an internal load
+		// balancer.
+		var internal *internalProxy
+		if conf.InternalTraefik != nil {
+			internal, err = createInternalLoadBalancer(ctx, vpc, subnet, conf)
+			if err != nil {
+				return err
+			}
+		}
+		appLb := func(app *App) *elb.LoadBalancer {
+			if app.internal {
+				return internal.lb
+			}
+			return webLb
+		}
+
 		// Listeners
-		err = createListeners(ctx, webLb, traefikTg, traefikAPITg)
+		err = createListeners(ctx, webLb, dashboardLb, traefikTg, traefikAPITg, canaryTg, grpcTg, conf)
+		if err != nil {
+			return err
+		}
+
+		// Further target groups of the Traefik service
+		var traefikTargets []traefikTarget
+		if grpcTg != nil {
+			traefikTargets = append(traefikTargets, traefikTarget{tg: grpcTg, port: 80})
+		}
+
+		// Scrapers that can't discover the Traefik tasks go through the
+		// internal load balancer instead.
+		if m := conf.Traefik.Metrics; m.Prometheus && m.Listener {
+			metricsTg, err := createMetricsListener(ctx, dashboardLb, vpc, conf)
+			if err != nil {
+				return err
+			}
+			traefikTargets = append(traefikTargets, traefikTarget{tg: metricsTg, port: m.Port})
+		}
+
+		// TLS for ACME and the tcp and udp entrypoints pass through a network
+		// load balancer.
+		var networkLb *elb.LoadBalancer
+		if conf.needsNetworkLoadBalancer() {
+			networkLb, err = createNetworkLoadBalancer(ctx, subnet)
+			if err != nil {
+				return err
+			}
+		}
+
+		if conf.ACME != nil {
+			tlsTg, err := createTLSListener(ctx, networkLb, vpc, conf)
+			if err != nil {
+				return err
+			}
+			traefikTargets = append(traefikTargets, traefikTarget{tg: tlsTg, port: 443})
+
+			err = createACMEPolicy(ctx, traefikRole, conf.ACME)
+			if err != nil {
+				return err
+			}
+		}
+
+		entryPointTargets, err := createEntryPoints(ctx, vpc, webLb, networkLb, conf)
 		if err != nil {
 			return err
 		}
+		traefikTargets = append(traefikTargets, entryPointTargets...)
+
+		/* STORAGE */
+
+		// Certificates and the file provider's dynamic configuration live on EFS.
+		var traefikVolumes ecs.TaskDefinitionVolumeArray
+		if mounts := conf.traefikMounts(); len(mounts) > 0 {
+			storage, volumes, err := createTraefikStorage(ctx, vpc, subnet, traefikSg, traefikRole, mounts)
+			if err != nil {
+				return err
+			}
+			traefikVolumes = volumes
+			ctx.Export("traefikStorage", storage.ID())
+		}
+		// The certificates the readers' sidecars dump stay within the task.
+		if conf.sharedACME() {
+			traefikVolumes = append(traefikVolumes, ecs.TaskDefinitionVolumeArgs{
+				Name: pulumi.String(generatedConfigVolume),
+			})
+		}
 
 		//	Container Definitions
 
-		whoamiContainerDef, traefikContainerDef := createContainerDefs(ctx, webLb, cluster)
+		region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+		if err != nil {
+			return err
+		}
+
+		var accessLogGroup *cloudwatch.LogGroup
+		if conf.Traefik.AccessLog && (conf.FireLens == nil || !conf.FireLens.AccessLog) {
+			accessLogGroup, err = cloudwatch.NewLogGroup(ctx, "traefik-logs",
+				conf.logGroupArgs("accessLog", conf.Traefik.AccessLogRetention, conf.logsKmsKey()))
+			if err != nil {
+				return err
+			}
+		}
+		logGroups, err := createLogGroups(ctx, apps, conf)
+		if err != nil {
+			return err
+		}
+		if conf.StrictIAM {
+			if accessLogGroup != nil {
+				logGroups = append(logGroups, accessLogGroup)
+			}
+			err = createExecutionPolicy(ctx, ecsRole, logGroups, apps, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		users, err := dashboardUsers(ctx, conf.Traefik.API.AuthSecret, conf)
+		if err != nil {
+			return err
+		}
+
+		traefikConf, err := createTraefikConfig(ctx, cluster, region.Name, conf.Traefik.AccessLog, ecsRole, traefikRole, apps, conf)
+		if err != nil {
+			return err
+		}
+		traefikContainerDefs := func(service, image string, role acmeRole) pulumi.StringOutput {
+			return traefikContainerDefinition(service, region.Name, accessLogGroup, traefikConf, users, conf, image, role)
+		}
+
+		traefikContainerDef := traefikContainerDefs("traefik", conf.Traefik.Image, conf.servingACMERole())
+
+		// Re-apply a recorded deployment instead of the generated definitions
+		if conf.RollbackTo != "" {
+			conf.rollback, err = loadManifest(ctx, conf.RollbackTo, conf)
+			if err != nil {
+				return err
+			}
+		}
 
 		// Task Definitions
 
-		whoamiTask, traefikTask, err := createTaskDefinitions(ctx, whoamiContainerDef, traefikContainerDef, ecsRole, traefikRole)
+		traefikTask, err := createTraefikTask(ctx, traefikContainerDef, traefikVolumes, ecsRole, traefikRole, conf)
 		if err != nil {
 			return err
 		}
 
 		// Services
 
-		err = createServices(ctx,
-			subnet,                 // Neworking
-			containerSg, traefikSg, // Security
-			traefikTg, traefikAPITg, // Load Balancing
-			cluster, whoamiTask, traefikTask, // ECS
+		traefikService, err := createTraefikService(ctx,
+			subnet,                                  // Neworking
+			traefikSg,                               // Security
+			traefikTg, traefikAPITg, traefikTargets, // Load Balancing
+			cluster, traefikTask, // ECS
+			conf,
 		)
 		if err != nil {
 			return err
 		}
 
+		services := map[string]*ecs.Service{"traefik": traefikService}
+		tasks := map[string]*ecs.TaskDefinition{"traefik": traefikTask}
+
+		// App services
+		traefikSgs := []*ec2.SecurityGroup{traefikSg}
+		if internal != nil {
+			traefikSgs = append(traefikSgs, internal.sg)
+		}
+		err = createAppIngress(ctx, containerSg, traefikSgs, apps)
+		if err != nil {
+			return err
+		}
+		err = createAppSecretsPolicy(ctx, ecsRole, apps)
+		if err != nil {
+			return err
+		}
+		for _, app := range apps {
+			containerDef := createAppContainerDef(appLb(app), app, conf, false)
+			task, service, err := createApp(ctx, subnet, containerSg, cluster, app, containerDef, ecsRole, conf)
+			if err != nil {
+				return err
+			}
+			services[app.Name] = service
+			tasks[app.Name] = task
+
+			if app.canary != nil {
+				canaryContainerDef := createAppContainerDef(appLb(app), app, conf, true)
+				canaryTask, canaryService, err := createAppCanary(ctx,
+					subnet, containerSg, cluster, app,
+					canaryContainerDef, ecsRole, conf,
+				)
+				if err != nil {
+					return err
+				}
+				services[app.canaryService()] = canaryService
+				tasks[app.canaryService()] = canaryTask
+			}
+		}
+
+		if conf.TraefikCanary != nil {
+			canaryContainerDef := traefikContainerDefs("traefik-canary", conf.TraefikCanary.Image, conf.servingACMERole())
+			canaryTask, canaryService, err := createTraefikCanary(ctx,
+				subnet, traefikSg, canaryTg, cluster,
+				canaryContainerDef, traefikVolumes, ecsRole, traefikRole, conf,
+			)
+			if err != nil {
+				return err
+			}
+			services["traefik-canary"] = canaryService
+			tasks["traefik-canary"] = canaryTask
+		}
+
+		if internal != nil {
+			internalContainerDef := traefikContainerDefinition("traefik-internal", region.Name, accessLogGroup, traefikConf.internal, users, conf.internalConfig(), conf.Traefik.Image, acmeResolver)
+			internalTask, internalService, err := createInternalTraefik(ctx,
+				subnet, internal, cluster,
+				internalContainerDef, ecsRole, traefikRole, conf,
+			)
+			if err != nil {
+				return err
+			}
+			services["traefik-internal"] = internalService
+			tasks["traefik-internal"] = internalTask
+		}
+
+		if conf.ForwardAuth != nil {
+			authTask, authService, err := createForwardAuth(ctx, vpc, subnet, traefikSg, cluster, ecsRole, conf)
+			if err != nil {
+				return err
+			}
+			services[forwardAuthService] = authService
+			tasks[forwardAuthService] = authTask
+		}
+
+		if conf.sharedACME() {
+			issuerContainerDef := traefikContainerDefs("traefik-acme", conf.Traefik.Image, acmeIssuer)
+			issuerTask, issuerService, err := createACMEIssuer(ctx,
+				subnet, traefikSg, cluster,
+				issuerContainerDef, traefikVolumes, ecsRole, traefikRole, conf,
+			)
+			if err != nil {
+				return err
+			}
+			services["traefik-acme"] = issuerService
+			tasks["traefik-acme"] = issuerTask
+		}
+
+		err = checkExecServices(services, conf)
+		if err != nil {
+			return err
+		}
+		err = checkTaskServices(services, conf)
+		if err != nil {
+			return err
+		}
+
+		if len(conf.ScheduledScaling) > 0 {
+			err = createScheduledScaling(ctx, cluster, services, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		if conf.ImageRefresh.Enabled {
+			err = createImageRefresh(ctx, cluster, services, conf.ImageRefresh, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		if conf.GlobalAccelerator {
+			ports := []int{80}
+			if len(conf.CertificateArns) > 0 {
+				ports = append(ports, 443)
+			}
+			acceleratorIps, err := createAccelerator(ctx, webLb, region.Name, ports)
+			if err != nil {
+				return err
+			}
+			ctx.Export("acceleratorIps", acceleratorIps)
+		}
+
+		/* MONITORING */
+
+		if conf.Alerts != nil {
+			err = createAlertTopic(ctx, conf)
+			if err != nil {
+				return err
+			}
+		}
+		if conf.ECSEvents != nil {
+			err = createECSEvents(ctx, cluster, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		lbTargetGroups := []lbTargetGroup{
+			{"traefik", webLb, traefikTg},
+			{"traefikapi", dashboardLb, traefikAPITg},
+		}
+		if canaryTg != nil {
+			lbTargetGroups = append(lbTargetGroups, lbTargetGroup{"traefik-canary", webLb, canaryTg})
+		}
+		if conf.Monitoring {
+			err = createAnomalyAlarms(ctx, lbTargetGroups, conf.AnomalyBandWidth, conf)
+			if err != nil {
+				return err
+			}
+		}
+		if internal != nil {
+			lbTargetGroups = append(lbTargetGroups, lbTargetGroup{"traefik-internal", internal.lb, internal.tg})
+		}
+		if conf.Alarms != nil {
+			err = createAlarms(ctx, webLb, lbTargetGroups, cluster, services, conf)
+			if err != nil {
+				return err
+			}
+		}
+		if conf.CloudWatchDashboard {
+			err = createDashboard(ctx, region.Name, webLb, lbTargetGroups, cluster, services)
+			if err != nil {
+				return err
+			}
+		}
+		if conf.ManagedGrafana != nil {
+			err = createGrafanaWorkspace(ctx, webLb, cluster, services, conf)
+			if err != nil {
+				return err
+			}
+		}
+		if conf.Synthetics != nil {
+			err = createCanary(ctx, webLb, apps, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(conf.SLOs) > 0 {
+			err = createSLOAlarms(ctx, accessLogGroup, conf.SLOs, conf)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Deployment history
+
+		if conf.DeploymentHistory {
+			containerDefs := map[string]pulumi.StringOutput{}
+			var deployed []pulumi.Resource
+			for family, task := range tasks {
+				containerDefs[family] = task.ContainerDefinitions
+				deployed = append(deployed, services[family])
+			}
+			_, err = recordManifest(ctx, containerDefs, deployed, conf)
+			if err != nil {
+				return err
+			}
+			ctx.Export("manifestParameter", pulumi.String(manifestParameterName(ctx)))
+		}
+
 		// Export the resulting web address.
 		ctx.Export("url", webLb.DnsName)
+		ctx.Export("clusterName", cluster.Name)
+		// Services of other clusters have to let Traefik in.
+		if len(conf.Traefik.Clusters) > 0 || conf.Traefik.AutoDiscoverClusters {
+			ctx.Export("traefikSecurityGroup", traefikSg.ID())
+		}
+		ctx.Export("dashboardUrl", pulumi.Sprintf("%s://%s:%d/dashboard/", conf.dashboardScheme(), dashboardLb.DnsName, dashboardPort))
+		if networkLb != nil {
+			ctx.Export("networkDnsName", networkLb.DnsName)
+		}
+		if conf.ACME != nil {
+			ctx.Export("tlsDnsName", networkLb.DnsName)
+		}
+		if internal != nil {
+			ctx.Export("internalDnsName", internal.lb.DnsName)
+			ctx.Export("internalTraefikSecurityGroup", internal.sg.ID())
+		}
 		return nil
 	})
 }
 
 // Read back the default VPC and public subnets, which we will use.
-func getNetwork(ctx *pulumi.Context) (*ec2.LookupVpcResult, *ec2.GetSubnetIdsResult, error) {
+func getNetwork(ctx *pulumi.Context, conf *stackConfig) (*ec2.LookupVpcResult, *ec2.GetSubnetIdsResult, error) {
 	t := true
-	vpc, err := ec2.LookupVpc(ctx, &ec2.LookupVpcArgs{Default: &t})
+	vpc, err := ec2.LookupVpc(ctx, &ec2.LookupVpcArgs{Default: &t}, conf.invokeOptions()...)
 	if err != nil {
 		return &ec2.LookupVpcResult{}, &ec2.GetSubnetIdsResult{}, err
 	}
-	subnet, err := ec2.GetSubnetIds(ctx, &ec2.GetSubnetIdsArgs{VpcId: vpc.Id})
+	subnet, err := ec2.GetSubnetIds(ctx, &ec2.GetSubnetIdsArgs{VpcId: vpc.Id}, conf.invokeOptions()...)
 	if err != nil {
 		return &ec2.LookupVpcResult{}, &ec2.GetSubnetIdsResult{}, err
 	}
@@ -135,7 +718,7 @@ func getNetwork(ctx *pulumi.Context) (*ec2.LookupVpcResult, *ec2.GetSubnetIdsRes
 	return vpc, subnet, nil
 }
 
-func createSecurityGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult) (
+func createSecurityGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult, conf *stackConfig) (
 	*ec2.SecurityGroup,
 	*ec2.SecurityGroup,
 	*ec2.SecurityGroup,
@@ -143,6 +726,41 @@ func createSecurityGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult) (
 ) {
 
 	// Create a SecurityGroup that permits HTTP ingress and unrestricted egress.
+	webIngress := ec2.SecurityGroupIngressArray{
+		ec2.SecurityGroupIngressArgs{
+			Protocol:   pulumi.String("tcp"),
+			FromPort:   pulumi.Int(80),
+			ToPort:     pulumi.Int(80),
+			CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+		},
+	}
+	if len(conf.CertificateArns) > 0 {
+		webIngress = append(webIngress, ec2.SecurityGroupIngressArgs{
+			Protocol:   pulumi.String("tcp"),
+			FromPort:   pulumi.Int(443),
+			ToPort:     pulumi.Int(443),
+			CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+		})
+	}
+	for _, ep := range conf.EntryPoints {
+		if ep.Protocol == "http" {
+			webIngress = append(webIngress, ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(ep.Port),
+				ToPort:     pulumi.Int(ep.Port),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			})
+		}
+	}
+	if !conf.InternalDashboard {
+		webIngress = append(webIngress, ec2.SecurityGroupIngressArgs{
+			Protocol:   pulumi.String("tcp"),
+			FromPort:   pulumi.Int(8080),
+			ToPort:     pulumi.Int(8080),
+			CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+		})
+	}
+
 	webSg, err := ec2.NewSecurityGroup(ctx, "web-sg", &ec2.SecurityGroupArgs{
 		VpcId: pulumi.String(vpc.Id),
 		Egress: ec2.SecurityGroupEgressArray{
@@ -153,23 +771,135 @@ func createSecurityGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult) (
 				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
 			},
 		},
-		Ingress: ec2.SecurityGroupIngressArray{
-			ec2.SecurityGroupIngressArgs{
-				Protocol:   pulumi.String("tcp"),
-				FromPort:   pulumi.Int(80),
-				ToPort:     pulumi.Int(80),
-				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-			},
+		Ingress: webIngress,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The dashboard is served from the public ALB unless an internal one is
+	// requested, in which case only the VPC and the allowed ranges reach it.
+	dashboardSg := webSg
+	dashboardIngress := ec2.SecurityGroupIngressArgs{
+		Protocol:       pulumi.String("tcp"),
+		FromPort:       pulumi.Int(8080),
+		ToPort:         pulumi.Int(8080),
+		CidrBlocks:     pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+		SecurityGroups: pulumi.StringArray{webSg.ID().ToStringOutput()},
+	}
+	if conf.InternalDashboard {
+		dashboardLbIngress := ec2.SecurityGroupIngressArray{
 			ec2.SecurityGroupIngressArgs{
 				Protocol:   pulumi.String("tcp"),
 				FromPort:   pulumi.Int(8080),
 				ToPort:     pulumi.Int(8080),
-				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+				CidrBlocks: toPulumiStringArray(append([]string{vpc.CidrBlock}, conf.DashboardAllowedCidrs...)),
+			},
+		}
+		if m := conf.Traefik.Metrics; m.Prometheus && m.Listener {
+			dashboardLbIngress = append(dashboardLbIngress, ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(m.Port),
+				ToPort:     pulumi.Int(m.Port),
+				CidrBlocks: pulumi.StringArray{pulumi.String(vpc.CidrBlock)},
+			})
+		}
+
+		dashboardSg, err = ec2.NewSecurityGroup(ctx, "dashboard-sg", &ec2.SecurityGroupArgs{
+			VpcId:       pulumi.String(vpc.Id),
+			Description: pulumi.String("Allow dashboard traffic from the VPC"),
+			Egress: ec2.SecurityGroupEgressArray{
+				ec2.SecurityGroupEgressArgs{
+					Protocol:   pulumi.String("-1"),
+					FromPort:   pulumi.Int(0),
+					ToPort:     pulumi.Int(0),
+					CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+				},
 			},
+			Ingress: dashboardLbIngress,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		dashboardIngress = ec2.SecurityGroupIngressArgs{
+			Protocol:       pulumi.String("tcp"),
+			FromPort:       pulumi.Int(8080),
+			ToPort:         pulumi.Int(8080),
+			SecurityGroups: pulumi.StringArray{dashboardSg.ID().ToStringOutput()},
+		}
+	}
+
+	// The health entrypoint is only reachable by the load balancers' health checks.
+	lbSgs := pulumi.StringArray{webSg.ID().ToStringOutput()}
+	if dashboardSg != webSg {
+		lbSgs = append(lbSgs, dashboardSg.ID().ToStringOutput())
+	}
+	healthIngress := ec2.SecurityGroupIngressArgs{
+		Protocol:       pulumi.String("tcp"),
+		FromPort:       pulumi.Int(conf.HealthPort),
+		ToPort:         pulumi.Int(conf.HealthPort),
+		SecurityGroups: lbSgs,
+	}
+
+	traefikIngress := ec2.SecurityGroupIngressArray{
+		ec2.SecurityGroupIngressArgs{
+			Protocol:       pulumi.String("tcp"),
+			FromPort:       pulumi.Int(80),
+			ToPort:         pulumi.Int(80),
+			CidrBlocks:     pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			SecurityGroups: pulumi.StringArray{webSg.ID().ToStringOutput()},
 		},
-	})
-	if err != nil {
-		return nil, nil, nil, err
+		dashboardIngress,
+		healthIngress,
+	}
+	// Prometheus scrapers in the VPC, and the internal load balancer when it
+	// has a metrics listener, may reach the metrics entrypoint.
+	if m := conf.Traefik.Metrics; m.Prometheus {
+		metricsIngress := ec2.SecurityGroupIngressArgs{
+			Protocol:   pulumi.String("tcp"),
+			FromPort:   pulumi.Int(m.Port),
+			ToPort:     pulumi.Int(m.Port),
+			CidrBlocks: pulumi.StringArray{pulumi.String(vpc.CidrBlock)},
+		}
+		if m.Listener {
+			metricsIngress.SecurityGroups = pulumi.StringArray{dashboardSg.ID().ToStringOutput()}
+		}
+		traefikIngress = append(traefikIngress, metricsIngress)
+	}
+	// The network load balancer has no security group; its TCP traffic and
+	// health checks come from its VPC addresses. UDP always keeps the
+	// client's address, so those ports are open to everyone.
+	if conf.needsNetworkLoadBalancer() {
+		networkPorts := []int{conf.HealthPort}
+		if conf.ACME != nil {
+			networkPorts = append(networkPorts, 443)
+		}
+		for _, port := range networkPorts {
+			traefikIngress = append(traefikIngress, ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(port),
+				ToPort:     pulumi.Int(port),
+				CidrBlocks: pulumi.StringArray{pulumi.String(vpc.CidrBlock)},
+			})
+		}
+	}
+	for _, ep := range conf.EntryPoints {
+		ingress := ec2.SecurityGroupIngressArgs{
+			Protocol:   pulumi.String(ep.Protocol),
+			FromPort:   pulumi.Int(ep.Port),
+			ToPort:     pulumi.Int(ep.Port),
+			CidrBlocks: pulumi.StringArray{pulumi.String(vpc.CidrBlock)},
+		}
+		switch ep.Protocol {
+		case "http":
+			ingress.Protocol = pulumi.String("tcp")
+			ingress.CidrBlocks = nil
+			ingress.SecurityGroups = pulumi.StringArray{webSg.ID().ToStringOutput()}
+		case "udp":
+			ingress.CidrBlocks = pulumi.StringArray{pulumi.String("0.0.0.0/0")}
+		}
+		traefikIngress = append(traefikIngress, ingress)
 	}
 
 	// allow traffic from ALB
@@ -184,29 +914,19 @@ func createSecurityGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult) (
 				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
 			},
 		},
-		Ingress: ec2.SecurityGroupIngressArray{
-			ec2.SecurityGroupIngressArgs{
-				Protocol:       pulumi.String("tcp"),
-				FromPort:       pulumi.Int(80),
-				ToPort:         pulumi.Int(80),
-				CidrBlocks:     pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				SecurityGroups: pulumi.StringArray{webSg.ID().ToStringOutput()},
-			},
-			ec2.SecurityGroupIngressArgs{
-				Protocol:       pulumi.String("tcp"),
-				FromPort:       pulumi.Int(8080),
-				ToPort:         pulumi.Int(8080),
-				CidrBlocks:     pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				SecurityGroups: pulumi.StringArray{webSg.ID().ToStringOutput()},
-			},
-		},
+		Ingress: traefikIngress,
 	})
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	// allow traffic from Traefik
-	containerSg, err := ec2.NewSecurityGroup(ctx, "container-sg", &ec2.SecurityGroupArgs{
+	return webSg, dashboardSg, traefikSg, nil
+}
+
+// createContainerSecurityGroup creates the security group of the apps, which
+// lets traffic from Traefik in.
+func createContainerSecurityGroup(ctx *pulumi.Context, vpc *ec2.LookupVpcResult, traefikSg *ec2.SecurityGroup) (*ec2.SecurityGroup, error) {
+	return ec2.NewSecurityGroup(ctx, "container-sg", &ec2.SecurityGroupArgs{
 		VpcId:       pulumi.String(vpc.Id),
 		Description: pulumi.String("Allow traffic from traefik"),
 		Egress: ec2.SecurityGroupEgressArray{
@@ -227,32 +947,20 @@ func createSecurityGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult) (
 			},
 		},
 	})
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	return webSg, traefikSg, containerSg, nil
 }
 
-func createCluster(ctx *pulumi.Context) (*ecs.Cluster, error) {
-	// Create an ECS cluster to run a container-based service.
-	return ecs.NewCluster(ctx, "traefik-cluster-demo", nil)
-}
+func createIAMRoles(ctx *pulumi.Context, conf *stackConfig) (*iam.Role, *iam.Role, error) {
+	tasksTrust, err := assumeRolePolicy("ecs-tasks.amazonaws.com")
+	if err != nil {
+		return nil, nil, err
+	}
 
-func createIAMRoles(ctx *pulumi.Context) (*iam.Role, *iam.Role, error) {
 	// Create an IAM role that can be used by our service's task.
 	ecsRole, err := iam.NewRole(ctx, "ecs-role", &iam.RoleArgs{
-		AssumeRolePolicy: pulumi.String(`{
-		"Version": "2008-10-17",
-		"Statement": [{
-			"Sid": "",
-			"Effect": "Allow",
-			"Principal": {
-				"Service": "ecs-tasks.amazonaws.com"
-			},
-			"Action": "sts:AssumeRole"
-		}]
-	}`),
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    tasksTrust,
 	})
 	if err != nil {
 		return nil, nil, err
@@ -260,18 +968,11 @@ func createIAMRoles(ctx *pulumi.Context) (*iam.Role, *iam.Role, error) {
 
 	// Create an IAM role that can be used by our service's task.
 	traefikRole, err := iam.NewRole(ctx, "task-role", &iam.RoleArgs{
-		Name: pulumi.String("traefik"),
-		AssumeRolePolicy: pulumi.String(`{
-		"Version": "2008-10-17",
-		"Statement": [{
-			"Sid": "",
-			"Effect": "Allow",
-			"Principal": {
-				"Service": "ecs-tasks.amazonaws.com"
-			},
-			"Action": "sts:AssumeRole"
-		}]
-	}`),
+		Name:                conf.roleName(conf.givenName("traefik")),
+		NamePrefix:          conf.roleNamePrefix(conf.givenName("traefik")),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    tasksTrust,
 	})
 	if err != nil {
 		return nil, nil, err
@@ -280,58 +981,119 @@ func createIAMRoles(ctx *pulumi.Context) (*iam.Role, *iam.Role, error) {
 	return ecsRole, traefikRole, nil
 }
 
-func createPolicies(ctx *pulumi.Context) (*iam.Policy, error) {
-	return iam.NewPolicy(ctx, "TraefikECSPolicy", &iam.PolicyArgs{
-		Name: pulumi.String("traefik_policy"),
-		Policy: pulumi.String(`{
-			"Version": "2012-10-17",
-			"Statement": [
-				{
-					"Effect": "Allow",
-					"Action": [
-						"ecs:ListClusters",
-						"ecs:DescribeClusters",
-						"ecs:ListTasks",
-						"ecs:DescribeTasks",
-						"ecs:DescribeContainerInstances",
-						"ecs:DescribeTaskDefinition",
-						"ec2:DescribeInstances"
-					],
-					"Sid": "main",
-					"Resource": "*"
-				}
-			]
-		}`),
+// createAppRole creates the task role of the tasks without one of their own.
+func createAppRole(ctx *pulumi.Context, conf *stackConfig) (*iam.Role, error) {
+	tasksTrust, err := assumeRolePolicy("ecs-tasks.amazonaws.com")
+	if err != nil {
+		return nil, err
+	}
+	appRole, err := iam.NewRole(ctx, "app-task-role", &iam.RoleArgs{
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    tasksTrust,
 	})
+	if err != nil {
+		return nil, err
+	}
+	conf.appRoleArn = appRole.Arn
+	return appRole, nil
 }
 
-func createTargetGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult) (*elb.TargetGroup, *elb.TargetGroup, error) {
-	traefikTg, err := elb.NewTargetGroup(ctx, "traefik-tg", &elb.TargetGroupArgs{
-		Name:       pulumi.String("traefik"),
-		Port:       pulumi.Int(80),
-		Protocol:   pulumi.String("HTTP"),
-		TargetType: pulumi.String("ip"),
-		VpcId:      pulumi.String(vpc.Id),
-		HealthCheck: elb.TargetGroupHealthCheckArgs{
-			Path:    pulumi.String("/"),
-			Matcher: pulumi.String("200-202,404"),
-		},
+// createPolicies creates the policy the ECS provider discovers services with.
+// It is scoped to cluster and those of traefik.clusters, where the ECS API
+// allows it, and covers every cluster with traefik.autoDiscoverClusters.
+func createPolicies(ctx *pulumi.Context, cluster *ecs.Cluster, conf *stackConfig) (*iam.Policy, error) {
+	policy := cluster.Arn.ApplyT(func(clusterArn string) (string, error) {
+		statements, err := traefikPolicyStatements(clusterArn, conf.Traefik)
+		if err != nil {
+			return "", err
+		}
+		return NewPolicyDocument(statements...).JSON()
+	}).(pulumi.StringOutput)
+
+	return iam.NewPolicy(ctx, "TraefikECSPolicy", &iam.PolicyArgs{
+		Name:       conf.policyName(conf.givenName("traefik-policy")),
+		NamePrefix: conf.policyNamePrefix(conf.givenName("traefik-policy")),
+		Path:       conf.iamPath(),
+		Policy:     policy,
 	})
+}
+
+// traefikPolicyStatements allow the ECS provider to discover the services
+// of the cluster clusterArn and of traefik.clusters. Listing the clusters
+// and reading task definitions and instances can't be scoped.
+func traefikPolicyStatements(clusterArn string, traefik TraefikOptions) ([]PolicyStatement, error) {
+	if traefik.AutoDiscoverClusters {
+		statement := Allow([]string{
+			"ecs:ListClusters",
+			"ecs:DescribeClusters",
+			"ecs:ListTasks",
+			"ecs:DescribeTasks",
+			"ecs:DescribeContainerInstances",
+			"ecs:DescribeTaskDefinition",
+			"ec2:DescribeInstances",
+		}, "*")
+		statement.Sid = "main"
+		return []PolicyStatement{statement}, nil
+	}
+
+	// Clusters named rather than given by ARN are in the stack's region
+	// and account.
+	scope, _, ok := splitClusterArn(clusterArn)
+	if !ok {
+		return nil, fmt.Errorf("%q is not the ARN of an ECS cluster", clusterArn)
+	}
+	prefix := scope + ":cluster/"
+	clusterArns := []string{clusterArn}
+	for _, c := range traefik.Clusters {
+		if !strings.HasPrefix(c, "arn:") {
+			c = prefix + c
+		}
+		clusterArns = append(clusterArns, c)
+	}
+	var taskArns []string
+	for _, arn := range clusterArns {
+		scope, name, ok := splitClusterArn(arn)
+		if !ok {
+			return nil, fmt.Errorf("traefik.clusters: %q is not the ARN of an ECS cluster", arn)
+		}
+		taskArns = append(taskArns,
+			fmt.Sprintf("%s:task/%s/*", scope, name),
+			fmt.Sprintf("%s:container-instance/%s/*", scope, name),
+		)
+	}
+
+	statements := []PolicyStatement{
+		Allow([]string{"ecs:ListClusters", "ecs:DescribeTaskDefinition", "ec2:DescribeInstances"}, "*"),
+		Allow([]string{"ecs:DescribeClusters"}, clusterArns...),
+		Allow([]string{"ecs:ListTasks"}, "*"),
+		Allow([]string{"ecs:DescribeTasks", "ecs:DescribeContainerInstances"}, taskArns...),
+	}
+	statements[2].Condition = map[string]map[string][]string{"ArnEquals": {"ecs:cluster": clusterArns}}
+	for i, sid := range []string{"main", "clusters", "listTasks", "tasks"} {
+		statements[i].Sid = sid
+	}
+	return statements, nil
+}
+
+// splitClusterArn splits the ARN of an ECS cluster into the part before
+// :cluster/, which the ARNs of its tasks and instances share, and its name.
+func splitClusterArn(arn string) (scope, name string, ok bool) {
+	i := strings.Index(arn, ":cluster/")
+	if i < 0 {
+		return "", "", false
+	}
+	return arn[:i], arn[i+len(":cluster/"):], true
+}
+
+func createTargetGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult, conf *stackConfig) (*elb.TargetGroup, *elb.TargetGroup, error) {
+	traefikTg, err := newTraefikTargetGroup(ctx, "traefik-tg", "traefik", 80, vpc, conf)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	traefikAPITg, err := elb.NewTargetGroup(ctx, "traefikapi-tg", &elb.TargetGroupArgs{
-		Name:       pulumi.String("traefikapi"),
-		Port:       pulumi.Int(8080),
-		Protocol:   pulumi.String("HTTP"),
-		TargetType: pulumi.String("ip"),
-		VpcId:      pulumi.String(vpc.Id),
-		HealthCheck: elb.TargetGroupHealthCheckArgs{
-			Path:    pulumi.String("/"),
-			Matcher: pulumi.String("200-202,300-302"),
-		},
-	})
+	traefikAPITg, err := newTraefikTargetGroup(ctx, "traefikapi-tg", "traefikapi", 8080, vpc, conf)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -339,200 +1101,284 @@ func createTargetGroups(ctx *pulumi.Context, vpc *ec2.LookupVpcResult) (*elb.Tar
 	return traefikTg, traefikAPITg, nil
 }
 
+// newTraefikTargetGroup creates a target group for port of the Traefik tasks.
+func newTraefikTargetGroup(
+	ctx *pulumi.Context,
+	resourceName, name string,
+	port int,
+	vpc *ec2.LookupVpcResult,
+	conf *stackConfig,
+) (*elb.TargetGroup, error) {
+	return elb.NewTargetGroup(ctx, resourceName, &elb.TargetGroupArgs{
+		Name:                       conf.physicalName(name),
+		LoadBalancingAlgorithmType: pulumi.String(conf.LoadBalancingAlgorithm),
+		DeregistrationDelay:        pulumi.Int(conf.DeregistrationDelay),
+		SlowStart:                  pulumi.Int(conf.SlowStart),
+		Port:                       pulumi.Int(port),
+		Protocol:                   pulumi.String("HTTP"),
+		TargetType:                 pulumi.String("ip"),
+		VpcId:                      pulumi.String(vpc.Id),
+		// All target groups point at Traefik tasks, so all of them check the
+		// ping endpoint of the health entrypoint. Unlike a request to `/`,
+		// this doesn't depend on which routers happen to exist.
+		HealthCheck: elb.TargetGroupHealthCheckArgs{
+			Port:    pulumi.Sprintf("%d", conf.HealthPort),
+			Path:    pulumi.String("/ping"),
+			Matcher: pulumi.String("200"),
+		},
+	})
+}
+
+// dashboardPort is the port of the dashboard listener.
+const dashboardPort = 8080
+
 func createListeners(
 	ctx *pulumi.Context,
 	loadBalancer *elb.LoadBalancer,
+	dashboardLoadBalancer *elb.LoadBalancer,
 	traefikTg *elb.TargetGroup,
 	traefikAPITg *elb.TargetGroup,
+	canaryTg *elb.TargetGroup,
+	grpcTg *elb.TargetGroup,
+	conf *stackConfig,
 ) error {
+	edgeAction := edgeForwardAction(traefikTg, canaryTg, conf)
+
 	_, err := elb.NewListener(ctx, "traefik-listener", &elb.ListenerArgs{
 		LoadBalancerArn: loadBalancer.Arn,
 		Port:            pulumi.Int(80),
+		DefaultActions:  elb.ListenerDefaultActionArray{edgeAction},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The dashboard's basic auth credentials only travel over TLS when the
+	// stack has a certificate.
+	dashboardArgs := &elb.ListenerArgs{
+		LoadBalancerArn: dashboardLoadBalancer.Arn,
+		Port:            pulumi.Int(dashboardPort),
 		DefaultActions: elb.ListenerDefaultActionArray{
 			elb.ListenerDefaultActionArgs{
 				Type:           pulumi.String("forward"),
-				TargetGroupArn: traefikTg.Arn,
+				TargetGroupArn: traefikAPITg.Arn,
 			},
 		},
-	})
+	}
+	if conf.dashboardScheme() == "https" {
+		dashboardArgs.Protocol = pulumi.String("HTTPS")
+		dashboardArgs.SslPolicy = pulumi.String(conf.SslPolicy)
+		dashboardArgs.CertificateArn = pulumi.String(conf.CertificateArns[0])
+	}
+	_, err = elb.NewListener(ctx, "web-listener", dashboardArgs)
 	if err != nil {
 		return err
 	}
 
-	_, err = elb.NewListener(ctx, "web-listener", &elb.ListenerArgs{
+	// TLS is terminated at the ALB with existing ACM certificates; the first
+	// is the default, the others are selected through SNI.
+	if len(conf.CertificateArns) > 0 {
+		httpsListener, err := elb.NewListener(ctx, "traefik-https-listener", &elb.ListenerArgs{
+			LoadBalancerArn: loadBalancer.Arn,
+			Port:            pulumi.Int(443),
+			Protocol:        pulumi.String("HTTPS"),
+			SslPolicy:       pulumi.String(conf.SslPolicy),
+			CertificateArn:  pulumi.String(conf.CertificateArns[0]),
+			DefaultActions:  elb.ListenerDefaultActionArray{edgeAction},
+		})
+		if err != nil {
+			return err
+		}
+
+		// The gRPC requests bypass a Traefik canary.
+		if grpcTg != nil {
+			err = createGRPCListenerRule(ctx, httpsListener, grpcTg)
+			if err != nil {
+				return err
+			}
+		}
+
+		for i, arn := range conf.CertificateArns[1:] {
+			_, err = elb.NewListenerCertificate(ctx, fmt.Sprintf("traefik-https-certificate-%d", i+1), &elb.ListenerCertificateArgs{
+				ListenerArn:    httpsListener.Arn,
+				CertificateArn: pulumi.String(arn),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// createMetricsListener forwards the metrics port of the internal load
+// balancer to the metrics entrypoint of the Traefik tasks.
+func createMetricsListener(
+	ctx *pulumi.Context,
+	loadBalancer *elb.LoadBalancer,
+	vpc *ec2.LookupVpcResult,
+	conf *stackConfig,
+) (*elb.TargetGroup, error) {
+	port := conf.Traefik.Metrics.Port
+	metricsTg, err := newTraefikTargetGroup(ctx, "traefik-metrics-tg", "traefik-metrics", port, vpc, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elb.NewListener(ctx, "traefik-metrics-listener", &elb.ListenerArgs{
 		LoadBalancerArn: loadBalancer.Arn,
-		Port:            pulumi.Int(8080),
+		Port:            pulumi.Int(port),
 		DefaultActions: elb.ListenerDefaultActionArray{
 			elb.ListenerDefaultActionArgs{
 				Type:           pulumi.String("forward"),
-				TargetGroupArn: traefikAPITg.Arn,
+				TargetGroupArn: metricsTg.Arn,
 			},
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return metricsTg, nil
 }
 
-func createContainerDefs(ctx *pulumi.Context, loadBalancer *elb.LoadBalancer, cluster *ecs.Cluster) (pulumi.StringOutput, pulumi.StringOutput) {
-	whoamiContainerDef := loadBalancer.DnsName.ApplyT(func(dnsName string) (string, error) {
-		def := `[{
-				"name": "whoami",
-				"image": "containous/whoami:v1.5.0",
-				"portMappings": [{
-					"containerPort": 80,
-					"hostPort": 80,
-					"protocol": "tcp"
-				}],
-				"dockerLabels": {
-					"traefik.enable": "true",
-					"traefik.http.routers.whoami.rule": "` + fmt.Sprintf("Host(`%s`)", dnsName) + `"
-				}
-			}]`
-		return def, nil
-	}).(pulumi.StringOutput)
+// createAppContainerDef generates the container definitions of app, or of
+// its canary, routed by the host name of loadBalancer.
+func createAppContainerDef(loadBalancer *elb.LoadBalancer, app *App, conf *stackConfig, canary bool) pulumi.StringOutput {
+	image, service := app.Image, app.Name
+	if canary {
+		image, service = app.canary.image, app.canaryService()
+	}
 
-	traefikContainerDef := cluster.Name.ApplyT(func(name string) (string, error) {
-		fmtstr := `[{
-			"name": "traefik",
-			"image": "traefik:v2.7",
-			"essential" : true,
-			"entryPoint": ["traefik", "--providers.ecs.clusters", %q, "--log.level", "DEBUG", "--providers.ecs.region", "eu-central-1", "--api.insecure"],
-			"portMappings": [
-				{
-					"containerPort": 80,
-					"hostPort": 80,
-					"protocol": "tcp"
-				},
-				{
-					"containerPort": 8080,
-					"hostPort": 8080,
-					"protocol": "tcp"
-				}
-			],
-			"Environment": [
-				{
-					"name": "AWS_ACCESS_KEY_ID",
-					"value": %q
-				}
-			],
-			"Secrets": [
-				{
-					"name": "AWS_SECRET_ACCESS_KEY",
-					"valuefrom": %q
-				}
-			]
-		}]`
-		def := fmt.Sprintf(fmtstr, name, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY_ARN"))
-		return def, nil
+	return pulumi.All(loadBalancer.DnsName, app.stackSecretArns(conf)).ApplyT(func(args []interface{}) (string, error) {
+		dnsName, stackSecrets := args[0].(string), args[1].(map[string]string)
+		labels, err := app.labels(app.rule(dnsName), conf, canary)
+		if err != nil {
+			return "", err
+		}
+		environment, secrets := app.environmentVariables(stackSecrets)
+		var sidecars []containerDefinition
+		if app.tracing {
+			environment = app.tracingEnvironment(environment)
+			sidecars = append(sidecars, defaultCollectorContainer())
+		}
+		def := containerDefinition{
+			Name:                 app.Name,
+			Image:                image,
+			Essential:            true,
+			PortMappings:         []portMapping{tcpPort(app.Port)},
+			Environment:          environment,
+			Secrets:              secrets,
+			MountPoints:          app.mountPoints(),
+			DockerLabels:         labels,
+			ResourceRequirements: app.resourceRequirements(),
+			// The log router runs next to the app with fireLens.
+			LogConfiguration: conf.logConfiguration(service, app.Name),
+		}
+		app.containerOptions.apply(&def)
+		return marshalContainers(append([]containerDefinition{def}, conf.withContainerOptions(conf.withLogging(service, sidecars))...))
 	}).(pulumi.StringOutput)
-
-	return whoamiContainerDef, traefikContainerDef
 }
 
-func createTaskDefinitions(
+func createTraefikTask(
 	ctx *pulumi.Context,
-	whoamiContainerDef pulumi.StringOutput,
 	traefikContainerDef pulumi.StringOutput,
+	traefikVolumes ecs.TaskDefinitionVolumeArray,
 	ecsRole *iam.Role,
 	traefikRole *iam.Role,
-) (*ecs.TaskDefinition, *ecs.TaskDefinition, error) {
-	// whoami task
-	whoamiTask, err := ecs.NewTaskDefinition(ctx, "app-task", &ecs.TaskDefinitionArgs{
-		Family:                  pulumi.String("whoami"),
-		ContainerDefinitions:    whoamiContainerDef,
-		Cpu:                     pulumi.String("256"),
-		Memory:                  pulumi.String("512"),
-		NetworkMode:             pulumi.String("awsvpc"),
-		RequiresCompatibilities: pulumi.StringArray{pulumi.String("FARGATE")},
-		ExecutionRoleArn:        ecsRole.Arn,
-	})
+	conf *stackConfig,
+) (*ecs.TaskDefinition, error) {
+	traefikDefs, err := conf.taskContainerDefinitions(ctx, "traefik", traefikContainerDef)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
 	traefikTask, err := ecs.NewTaskDefinition(ctx, "traefik-task", &ecs.TaskDefinitionArgs{
 		Family:                  pulumi.String("traefik"),
-		ContainerDefinitions:    traefikContainerDef,
-		Cpu:                     pulumi.String("256"),
-		Memory:                  pulumi.String("512"),
+		ContainerDefinitions:    traefikDefs,
+		Cpu:                     conf.taskCPU("traefik"),
+		Memory:                  conf.taskMemory("traefik"),
 		NetworkMode:             pulumi.String("awsvpc"),
-		RequiresCompatibilities: pulumi.StringArray{pulumi.String("FARGATE")},
+		RequiresCompatibilities: conf.compatibilities(),
+		RuntimePlatform:         conf.traefikPlatform().args(),
+		EphemeralStorage:        conf.ephemeralStorage("traefik"),
 		ExecutionRoleArn:        ecsRole.Arn,
 		TaskRoleArn:             traefikRole.Arn,
+		Tags:                    conf.resourceTags(),
+		Volumes:                 traefikVolumes,
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	return whoamiTask, traefikTask, nil
+	return traefikTask, nil
 }
 
-func createServices(
+func createTraefikService(
 	ctx *pulumi.Context,
 	subnet *ec2.GetSubnetIdsResult,
-	containerSg *ec2.SecurityGroup,
 	traefikSg *ec2.SecurityGroup,
 	traefikTg *elb.TargetGroup,
 	traefikAPITg *elb.TargetGroup,
+	traefikTargets []traefikTarget,
 	cluster *ecs.Cluster,
-	whoamiTask *ecs.TaskDefinition,
 	traefikTask *ecs.TaskDefinition,
-) error {
-	// whoami service
-	_, err := ecs.NewService(ctx, "whoami-service", &ecs.ServiceArgs{
-		Name: pulumi.String("whoami"),
-
-		Cluster:        cluster.Arn,
-		TaskDefinition: whoamiTask.Arn,
-
-		DesiredCount: pulumi.Int(3),
-		LaunchType:   pulumi.String("FARGATE"),
-
-		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
-			AssignPublicIp: pulumi.Bool(true),
-			Subnets:        toPulumiStringArray(subnet.Ids),
-			SecurityGroups: pulumi.StringArray{containerSg.ID().ToStringOutput()},
+	conf *stackConfig,
+) (*ecs.Service, error) {
+	traefikLbs := ecs.ServiceLoadBalancerArray{
+		ecs.ServiceLoadBalancerArgs{
+			TargetGroupArn: traefikTg.Arn,
+			ContainerName:  pulumi.String("traefik"),
+			ContainerPort:  pulumi.Int(80),
 		},
-	})
-	if err != nil {
-		return err
+		ecs.ServiceLoadBalancerArgs{
+			TargetGroupArn: traefikAPITg.Arn,
+			ContainerName:  pulumi.String("traefik"),
+			ContainerPort:  pulumi.Int(8080),
+		},
+	}
+	for _, t := range traefikTargets {
+		traefikLbs = append(traefikLbs, ecs.ServiceLoadBalancerArgs{
+			TargetGroupArn: t.tg.Arn,
+			ContainerName:  pulumi.String("traefik"),
+			ContainerPort:  pulumi.Int(t.port),
+		})
 	}
 
 	// traefik service
-	_, err = ecs.NewService(ctx, "traefik-service", &ecs.ServiceArgs{
+	traefikService, err := ecs.NewService(ctx, "traefik-service", &ecs.ServiceArgs{
 		Name: pulumi.String("traefik"),
 
 		Cluster:        cluster.Arn,
 		TaskDefinition: traefikTask.Arn,
 
-		DesiredCount: pulumi.Int(1),
-		LaunchType:   pulumi.String("FARGATE"),
+		DesiredCount:                    pulumi.Int(conf.Traefik.DesiredCount),
+		LaunchType:                      conf.launchType(),
+		PlatformVersion:                 conf.platformVersion(),
+		CapacityProviderStrategies:      conf.capacityProviderStrategies(),
+		DeploymentMinimumHealthyPercent: conf.minimumHealthyPercent("traefik"),
+		DeploymentMaximumPercent:        conf.maximumPercent("traefik"),
+		DeploymentCircuitBreaker:        conf.circuitBreaker(),
+		ForceNewDeployment:              conf.forceNewDeployment(),
+		EnableExecuteCommand:            conf.enableExecuteCommand("traefik"),
+		PlacementConstraints:            conf.placementConstraints("traefik"),
+		OrderedPlacementStrategies:      conf.placementStrategies("traefik"),
+		PropagateTags:                   conf.propagateTags(),
+		EnableEcsManagedTags:            pulumi.Bool(true),
+		Tags:                            conf.resourceTags(),
+		HealthCheckGracePeriodSeconds:   conf.healthCheckGracePeriod("traefik"),
 
-		LoadBalancers: ecs.ServiceLoadBalancerArray{
-			ecs.ServiceLoadBalancerArgs{
-				TargetGroupArn: traefikTg.Arn,
-				ContainerName:  pulumi.String("traefik"),
-				ContainerPort:  pulumi.Int(80),
-			},
-			ecs.ServiceLoadBalancerArgs{
-				TargetGroupArn: traefikAPITg.Arn,
-				ContainerName:  pulumi.String("traefik"),
-				ContainerPort:  pulumi.Int(8080),
-			},
-		},
+		LoadBalancers: traefikLbs,
 
 		NetworkConfiguration: &ecs.ServiceNetworkConfigurationArgs{
-			AssignPublicIp: pulumi.Bool(true),
-			Subnets:        toPulumiStringArray(subnet.Ids),
+			AssignPublicIp: conf.assignPublicIP(),
+			Subnets:        conf.taskSubnets(subnet),
 			SecurityGroups: pulumi.StringArray{traefikSg.ID().ToStringOutput()},
 		},
 	}, pulumi.DependsOn([]pulumi.Resource{traefikTg}))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return traefikService, nil
 }
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestSplitClusterArn(t *testing.T) {
+	tests := []struct {
+		arn   string
+		scope string
+		name  string
+		ok    bool
+	}{
+		{
+			arn:   "arn:aws:ecs:eu-west-1:123456789012:cluster/main",
+			scope: "arn:aws:ecs:eu-west-1:123456789012",
+			name:  "main",
+			ok:    true,
+		},
+		{
+			arn:   "arn:aws-cn:ecs:cn-north-1:123456789012:cluster/apps_2",
+			scope: "arn:aws-cn:ecs:cn-north-1:123456789012",
+			name:  "apps_2",
+			ok:    true,
+		},
+		{
+			arn: "main",
+		},
+		{
+			arn: "arn:aws:ecs:eu-west-1:123456789012:service/main/whoami",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			scope, name, ok := splitClusterArn(tt.arn)
+			if scope != tt.scope || name != tt.name || ok != tt.ok {
+				t.Errorf("splitClusterArn(%q) = %q, %q, %v, want %q, %q, %v", tt.arn, scope, name, ok, tt.scope, tt.name, tt.ok)
+			}
+		})
+	}
+}
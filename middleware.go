@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Middleware is a Traefik middleware on an app's router, defined by the app's
+// labels.
+type Middleware struct {
+	// Name tells apart middlewares of the same kind. It defaults to the
+	// kind, and is prefixed with the app's name, or the chain's.
+	Name string
+	// Kind is the middleware's type in Traefik, e.g. headers.
+	Kind string
+	// Options are relative to traefik.http.middlewares.<name>.<kind>.
+	Options map[string]string
+
+	chain []Middleware
+}
+
+// RateLimit limits requests to average per second per client IP, allowing
+// bursts of up to burst requests.
+func RateLimit(average, burst int) Middleware {
+	return Middleware{Kind: "ratelimit", Options: map[string]string{
+		"average": fmt.Sprint(average),
+		"burst":   fmt.Sprint(burst),
+		// Requests reach Traefik from the ALB, which appends the client IP
+		// to X-Forwarded-For.
+		"sourcecriterion.ipstrategy.depth": "1",
+	}}
+}
+
+// Retry retries failed requests up to attempts times, waiting exponentially
+// longer between attempts, starting at initialInterval. Only requests that
+// didn't get a response, e.g. because of a network error, are retried.
+func Retry(attempts int, initialInterval time.Duration) Middleware {
+	return Middleware{Kind: "retry", Options: map[string]string{
+		"attempts":        fmt.Sprint(attempts),
+		"initialinterval": initialInterval.String(),
+	}}
+}
+
+// BreakerCondition is a condition that trips a circuit breaker.
+type BreakerCondition string
+
+// NetworkErrorRatioAbove trips when more than ratio of the requests fail
+// with a network error.
+func NetworkErrorRatioAbove(ratio float64) BreakerCondition {
+	return BreakerCondition(fmt.Sprintf("NetworkErrorRatio() > %g", ratio))
+}
+
+// ResponseCodeRatioAbove trips when responses with status codes in [from,
+// to) are more than ratio of those with status codes in [dividedByFrom,
+// dividedByTo).
+func ResponseCodeRatioAbove(from, to, dividedByFrom, dividedByTo int, ratio float64) BreakerCondition {
+	return BreakerCondition(fmt.Sprintf("ResponseCodeRatio(%d, %d, %d, %d) > %g", from, to, dividedByFrom, dividedByTo, ratio))
+}
+
+// LatencyAbove trips when the quantile (0-100) of the response times is
+// above latency.
+func LatencyAbove(quantile float64, latency time.Duration) BreakerCondition {
+	return BreakerCondition(fmt.Sprintf("LatencyAtQuantileMS(%g) > %d", quantile, latency.Milliseconds()))
+}
+
+// CircuitBreaker stops forwarding requests, answering them with a 503
+// instead, while any of conditions holds.
+func CircuitBreaker(conditions ...BreakerCondition) Middleware {
+	expressions := make([]string, len(conditions))
+	for i, c := range conditions {
+		expressions[i] = string(c)
+	}
+	return Middleware{Kind: "circuitbreaker", Options: map[string]string{
+		"expression": strings.Join(expressions, " || "),
+	}}
+}
+
+// Headers sets the request headers before forwarding requests, and the
+// response headers before answering them. Headers with empty values are
+// removed instead.
+func Headers(request, response map[string]string) Middleware {
+	options := map[string]string{}
+	for name, value := range request {
+		options["customrequestheaders."+name] = value
+	}
+	for name, value := range response {
+		options["customresponseheaders."+name] = value
+	}
+	return Middleware{Kind: "headers", Options: options}
+}
+
+// RedirectScheme redirects requests to the same URL with scheme, e.g. https.
+func RedirectScheme(scheme string, permanent bool) Middleware {
+	return Middleware{Kind: "redirectscheme", Options: map[string]string{
+		"scheme":    scheme,
+		"permanent": fmt.Sprint(permanent),
+	}}
+}
+
+// RedirectRegex redirects requests whose URL matches regex to replacement,
+// which may refer to the regex's groups, e.g. ${1}.
+func RedirectRegex(regex, replacement string, permanent bool) Middleware {
+	return Middleware{Kind: "redirectregex", Options: map[string]string{
+		"regex":       regex,
+		"replacement": replacement,
+		"permanent":   fmt.Sprint(permanent),
+	}}
+}
+
+// Chain groups middlewares, applied in order, into a single middleware
+// called name, e.g. to reuse the same sequence in several places.
+func Chain(name string, middlewares ...Middleware) Middleware {
+	return Middleware{Name: name, Kind: "chain", chain: middlewares}
+}
+
+// addLabels adds the labels defining m, and the members of a chain, named
+// after prefix, and returns m's name. defined holds the names taken so far.
+func (m Middleware) addLabels(labels map[string]string, prefix string, defined map[string]bool, conf *stackConfig) (string, error) {
+	name := m.Name
+	if name == "" {
+		name = m.Kind
+	}
+	name = prefix + "-" + name
+	if defined[name] {
+		return "", fmt.Errorf("two middlewares are called %s, name one of them", name)
+	}
+	defined[name] = true
+
+	options := m.Options
+	if m.Kind == "chain" {
+		if len(m.chain) == 0 {
+			return "", fmt.Errorf("chain %s has no middlewares", name)
+		}
+		var members []string
+		for _, member := range m.chain {
+			n, err := member.addLabels(labels, name, defined, conf)
+			if err != nil {
+				return "", err
+			}
+			members = append(members, n)
+		}
+		options = map[string]string{"middlewares": strings.Join(members, ",")}
+	}
+
+	kind, err := conf.middlewareKind(m.Kind)
+	if err != nil {
+		return "", err
+	}
+	key := "traefik.http.middlewares." + name + "." + kind
+	// A middleware without options still needs a label to exist.
+	if len(options) == 0 {
+		labels[key] = "true"
+	}
+	for option, value := range options {
+		labels[key+"."+option] = value
+	}
+	return name, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// anomalyAlarm describes an ALB target group metric that is alarmed on with
+// a CloudWatch anomaly detection band instead of a static threshold.
+type anomalyAlarm struct {
+	metric     string
+	stat       string
+	comparison string
+}
+
+var targetGroupAnomalyAlarms = []anomalyAlarm{
+	// Only slowness is a problem; unusually fast responses are not.
+	{"TargetResponseTime", "p90", "GreaterThanUpperThreshold"},
+	// Both a surge and a sudden drop in traffic are worth a look.
+	{"RequestCount", "Sum", "LessThanLowerOrGreaterThanUpperThreshold"},
+}
+
+// lbTargetGroup is a target group the dashboard and the alarms watch, with
+// the load balancer it is registered with.
+type lbTargetGroup struct {
+	name         string
+	loadBalancer *elb.LoadBalancer
+	targetGroup  *elb.TargetGroup
+}
+
+// createAnomalyAlarms creates anomaly detection alarms for every target group,
+// each with the dimension of the load balancer it is behind. bandWidth is the
+// number of standard deviations the expected band spans.
+func createAnomalyAlarms(
+	ctx *pulumi.Context,
+	targetGroups []lbTargetGroup,
+	bandWidth float64,
+	conf *stackConfig,
+) error {
+	for _, tg := range targetGroups {
+		name := tg.name
+		for _, a := range targetGroupAnomalyAlarms {
+			_, err := cloudwatch.NewMetricAlarm(ctx, fmt.Sprintf("%s-%s-anomaly", name, a.metric), &cloudwatch.MetricAlarmArgs{
+				AlarmDescription:   pulumi.Sprintf("%s of the %s target group is outside its expected band", a.metric, name),
+				ComparisonOperator: pulumi.String(a.comparison),
+				EvaluationPeriods:  pulumi.Int(3),
+				ThresholdMetricId:  pulumi.String("band"),
+				TreatMissingData:   pulumi.String("notBreaching"),
+				AlarmActions:       conf.alarmActions(),
+				OkActions:          conf.alarmActions(),
+				MetricQueries: cloudwatch.MetricAlarmMetricQueryArray{
+					cloudwatch.MetricAlarmMetricQueryArgs{
+						Id:         pulumi.String("m"),
+						ReturnData: pulumi.Bool(true),
+						Metric: cloudwatch.MetricAlarmMetricQueryMetricArgs{
+							Namespace:  pulumi.String("AWS/ApplicationELB"),
+							MetricName: pulumi.String(a.metric),
+							Stat:       pulumi.String(a.stat),
+							Period:     pulumi.Int(300),
+							Dimensions: pulumi.StringMap{
+								"LoadBalancer": tg.loadBalancer.ArnSuffix,
+								"TargetGroup":  tg.targetGroup.ArnSuffix,
+							},
+						},
+					},
+					cloudwatch.MetricAlarmMetricQueryArgs{
+						Id:         pulumi.String("band"),
+						Expression: pulumi.Sprintf("ANOMALY_DETECTION_BAND(m, %g)", bandWidth),
+						Label:      pulumi.Sprintf("%s (expected)", a.metric),
+						ReturnData: pulumi.Bool(true),
+					},
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// burnRateWindows are the multi-window, multi-burn-rate alert conditions from
+// the Google SRE workbook. An SLO alarm fires when both the long and the short
+// window consume the error budget faster than rate; the short window makes the
+// alarm reset quickly once the problem is fixed.
+var burnRateWindows = []struct {
+	long, short int // seconds
+	rate        float64
+}{
+	{3600, 300, 14.4}, // 2% of a 30 day budget in an hour
+	{21600, 1800, 6},  // 5% of a 30 day budget in six hours
+}
+
+func sloNamespace(ctx *pulumi.Context) string {
+	return fmt.Sprintf("Traefik/%s/%s", ctx.Project(), ctx.Stack())
+}
+
+// createSLOAlarms turns the Traefik access logs in logGroup into per-router
+// request metrics and creates burn-rate alarms for every app with an SLO.
+// Only the composite alarms notify the alert topic.
+func createSLOAlarms(ctx *pulumi.Context, logGroup *cloudwatch.LogGroup, slos map[string]appSLO, conf *stackConfig) error {
+	namespace := sloNamespace(ctx)
+
+	// Requests and errors share one filter each, split by router. Slow
+	// requests need a filter per app since every app has its own threshold.
+	filters := []struct{ name, metric, pattern string }{
+		{"requests", "Requests", `{ $.DownstreamStatus > 0 }`},
+		{"errors", "ErrorRequests", `{ $.DownstreamStatus >= 500 }`},
+	}
+	for app, slo := range slos {
+		if slo.Latency > 0 {
+			filters = append(filters, struct{ name, metric, pattern string }{
+				app + "-slow", "SlowRequests",
+				fmt.Sprintf(`{ $.RouterName = "%s@ecs" && $.Duration > %d }`, app, int64(slo.LatencyThresholdMs)*1000000),
+			})
+		}
+	}
+	for _, f := range filters {
+		_, err := cloudwatch.NewLogMetricFilter(ctx, "access-log-"+f.name, &cloudwatch.LogMetricFilterArgs{
+			LogGroupName: logGroup.Name,
+			Pattern:      pulumi.String(f.pattern),
+			MetricTransformation: cloudwatch.LogMetricFilterMetricTransformationArgs{
+				Namespace:  pulumi.String(namespace),
+				Name:       pulumi.String(f.metric),
+				Value:      pulumi.String("1"),
+				Unit:       pulumi.String("Count"),
+				Dimensions: pulumi.StringMap{"Router": pulumi.String("$.RouterName")},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for app, slo := range slos {
+		slis := map[string]float64{}
+		if slo.Availability > 0 {
+			slis["ErrorRequests"] = slo.Availability
+		}
+		if slo.Latency > 0 {
+			slis["SlowRequests"] = slo.Latency
+		}
+
+		for bad, target := range slis {
+			for _, w := range burnRateWindows {
+				name := fmt.Sprintf("%s-%s-%dh", app, strings.ToLower(bad), w.long/3600)
+				threshold := w.rate * (1 - target/100)
+
+				long, err := burnRateAlarm(ctx, name+"-long", namespace, app, bad, w.long, threshold)
+				if err != nil {
+					return err
+				}
+				short, err := burnRateAlarm(ctx, name+"-short", namespace, app, bad, w.short, threshold)
+				if err != nil {
+					return err
+				}
+
+				_, err = cloudwatch.NewCompositeAlarm(ctx, name+"-burn-rate", &cloudwatch.CompositeAlarmArgs{
+					AlarmName: pulumi.Sprintf("%s-%s-%s-burn-rate", ctx.Project(), ctx.Stack(), name),
+					AlarmDescription: pulumi.Sprintf("%s is burning its %g%% SLO error budget %gx faster than sustainable",
+						app, target, w.rate),
+					AlarmRule:    pulumi.Sprintf(`ALARM("%s") AND ALARM("%s")`, long.Name, short.Name),
+					AlarmActions: conf.compositeAlarmActions(),
+					OkActions:    conf.compositeAlarmActions(),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// burnRateAlarm alarms when the share of bad requests of app over window
+// seconds exceeds threshold.
+func burnRateAlarm(
+	ctx *pulumi.Context,
+	name, namespace, app, bad string,
+	window int,
+	threshold float64,
+) (*cloudwatch.MetricAlarm, error) {
+	metric := func(id, metricName string) cloudwatch.MetricAlarmMetricQueryArgs {
+		return cloudwatch.MetricAlarmMetricQueryArgs{
+			Id: pulumi.String(id),
+			Metric: cloudwatch.MetricAlarmMetricQueryMetricArgs{
+				Namespace:  pulumi.String(namespace),
+				MetricName: pulumi.String(metricName),
+				Stat:       pulumi.String("Sum"),
+				Period:     pulumi.Int(window),
+				Dimensions: pulumi.StringMap{"Router": pulumi.String(app + "@ecs")},
+			},
+		}
+	}
+
+	return cloudwatch.NewMetricAlarm(ctx, name, &cloudwatch.MetricAlarmArgs{
+		ComparisonOperator: pulumi.String("GreaterThanThreshold"),
+		EvaluationPeriods:  pulumi.Int(1),
+		Threshold:          pulumi.Float64(threshold),
+		TreatMissingData:   pulumi.String("notBreaching"),
+		MetricQueries: cloudwatch.MetricAlarmMetricQueryArray{
+			metric("bad", bad),
+			metric("total", "Requests"),
+			cloudwatch.MetricAlarmMetricQueryArgs{
+				Id:         pulumi.String("ratio"),
+				Expression: pulumi.String("IF(total > 0, FILL(bad, 0) / total, 0)"),
+				ReturnData: pulumi.Bool(true),
+			},
+		},
+	})
+}
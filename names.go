@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// namePrefixPattern matches the prefixes of the names of the stack's IAM
+// roles, policies and target groups.
+var namePrefixPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,13}[a-z0-9])?$`)
+
+// validateNamePrefix checks namePrefix, and that the target groups it names
+// fit in the 32 characters of their names.
+func validateNamePrefix(conf *stackConfig) error {
+	if conf.NamePrefix == "" {
+		return nil
+	}
+	if !namePrefixPattern.MatchString(conf.NamePrefix) {
+		return fmt.Errorf("namePrefix must be 1 to 15 lowercase letters, digits and hyphens, not starting or ending with a hyphen, got %q", conf.NamePrefix)
+	}
+	for _, ep := range conf.EntryPoints {
+		if name := conf.prefixedName("traefik-" + ep.Name); len(name) > 32 {
+			return fmt.Errorf("namePrefix: the target group of entrypoint %s would be named %s, over 32 characters", ep.Name, name)
+		}
+	}
+	return nil
+}
+
+// prefixedName is name prefixed with namePrefix.
+func (c *stackConfig) prefixedName(name string) string {
+	return c.NamePrefix + "-" + name
+}
+
+// givenName is name prefixed with namePrefix, or empty without namePrefix.
+func (c *stackConfig) givenName(name string) string {
+	if c.NamePrefix == "" {
+		return ""
+	}
+	return c.prefixedName(name)
+}
+
+// physicalName names the resources whose names are unique within the account
+// or the region, such as IAM roles and target groups, so that the stack can
+// be deployed twice. It is name prefixed with namePrefix, or nil without it
+// for Pulumi to generate a unique one from the resource name.
+func (c *stackConfig) physicalName(name string) pulumi.StringPtrInput {
+	name = c.givenName(name)
+	if name == "" {
+		return nil
+	}
+	return pulumi.String(name)
+}
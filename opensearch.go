@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticsearch"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// openSearchDomainInvalid matches the characters OpenSearch doesn't accept in
+// the names of domains.
+var openSearchDomainInvalid = regexp.MustCompile(`[^a-z0-9-]`)
+
+// validateOpenSearchDomain checks the domain of fireLens.openSearchDomain,
+// and fills in its defaults: a single small instance, enough for the logs of
+// a test stack.
+func validateOpenSearchDomain(conf *stackConfig) error {
+	d := conf.FireLens.OpenSearchDomain
+	if d.EngineVersion == "" {
+		d.EngineVersion = "OpenSearch_2.11"
+	}
+	if !strings.HasPrefix(d.EngineVersion, "OpenSearch_") {
+		return fmt.Errorf("fireLens.openSearchDomain.engineVersion must be an OpenSearch version, such as OpenSearch_2.11, got %q", d.EngineVersion)
+	}
+	if d.InstanceType == "" {
+		d.InstanceType = "t3.small.search"
+	}
+	if !strings.HasSuffix(d.InstanceType, ".search") {
+		return fmt.Errorf("fireLens.openSearchDomain.instanceType must be an OpenSearch instance type, such as t3.small.search, got %q", d.InstanceType)
+	}
+	if d.InstanceCount == 0 {
+		d.InstanceCount = 1
+	}
+	if d.InstanceCount < 1 {
+		return fmt.Errorf("fireLens.openSearchDomain.instanceCount must be at least 1, got %d", d.InstanceCount)
+	}
+	if d.VolumeSize == 0 {
+		d.VolumeSize = 10
+	}
+	if d.VolumeSize < 10 {
+		return fmt.Errorf("fireLens.openSearchDomain.volumeSize must be at least 10 GiB, got %d", d.VolumeSize)
+	}
+	for _, cidr := range d.AllowedCidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("fireLens.openSearchDomain.allowedCidrs: %w", err)
+		}
+	}
+	return nil
+}
+
+// openSearchDomainName is the name of the domain of the stack.
+func openSearchDomainName(ctx *pulumi.Context) string {
+	name := openSearchDomainInvalid.ReplaceAllString(strings.ToLower(fmt.Sprintf("%s-%s", ctx.Project(), ctx.Stack())), "-")
+	if len(name) > 28 {
+		name = name[:28]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// openSearchAccessPolicy lets the account's IAM policies grant access to the
+// domain of arn, which the stack's do for the log routers, and the clients
+// from cidrs in without signing their requests, e.g. to open Dashboards.
+func openSearchAccessPolicy(account, arn string, cidrs []string) (string, error) {
+	statements := []PolicyStatement{{
+		Effect:    "Allow",
+		Principal: map[string][]string{"AWS": {fmt.Sprintf("arn:aws:iam::%s:root", account)}},
+		Action:    []string{"es:ESHttp*"},
+		Resource:  []string{arn + "/*"},
+	}}
+	if len(cidrs) > 0 {
+		statements = append(statements, PolicyStatement{
+			Effect:    "Allow",
+			Principal: map[string][]string{"AWS": {"*"}},
+			Action:    []string{"es:ESHttp*"},
+			Resource:  []string{arn + "/*"},
+			Condition: map[string]map[string][]string{"IpAddress": {"aws:SourceIp": cidrs}},
+		})
+	}
+	return NewPolicyDocument(statements...).JSON()
+}
+
+// createOpenSearchDomain creates the domain of fireLens.openSearchDomain in
+// region, and sets fireLens.openSearchDomainArn to its ARN. The endpoint of
+// the domain is only known once it is created, so withOpenSearchHost sets it
+// in the container definitions.
+func createOpenSearchDomain(ctx *pulumi.Context, region string, conf *stackConfig) error {
+	f := conf.FireLens
+	d := f.OpenSearchDomain
+	identity, err := aws.GetCallerIdentity(ctx, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+	name := openSearchDomainName(ctx)
+	f.OpenSearchDomainArn = fmt.Sprintf("arn:aws:es:%s:%s:domain/%s", region, identity.AccountId, name)
+	policy, err := openSearchAccessPolicy(identity.AccountId, f.OpenSearchDomainArn, d.AllowedCidrs)
+	if err != nil {
+		return err
+	}
+
+	domain, err := elasticsearch.NewDomain(ctx, "opensearch", &elasticsearch.DomainArgs{
+		DomainName:           pulumi.String(name),
+		ElasticsearchVersion: pulumi.String(d.EngineVersion),
+		ClusterConfig: elasticsearch.DomainClusterConfigArgs{
+			InstanceType:         pulumi.String(d.InstanceType),
+			InstanceCount:        pulumi.Int(d.InstanceCount),
+			ZoneAwarenessEnabled: pulumi.Bool(d.InstanceCount > 1),
+		},
+		EbsOptions: elasticsearch.DomainEbsOptionsArgs{
+			EbsEnabled: pulumi.Bool(true),
+			VolumeType: pulumi.String("gp3"),
+			VolumeSize: pulumi.Int(d.VolumeSize),
+		},
+		EncryptAtRest: elasticsearch.DomainEncryptAtRestArgs{
+			Enabled:  pulumi.Bool(true),
+			KmsKeyId: conf.kmsKey(),
+		},
+		NodeToNodeEncryption: elasticsearch.DomainNodeToNodeEncryptionArgs{Enabled: pulumi.Bool(true)},
+		DomainEndpointOptions: elasticsearch.DomainDomainEndpointOptionsArgs{
+			EnforceHttps:      pulumi.Bool(true),
+			TlsSecurityPolicy: pulumi.String("Policy-Min-TLS-1-2-2019-07"),
+		},
+		AccessPolicies: pulumi.String(policy),
+		Tags:           conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	conf.openSearchEndpoint = domain.Endpoint
+
+	ctx.Export("openSearchEndpoint", domain.Endpoint)
+	ctx.Export("openSearchDashboardsUrl", pulumi.Sprintf("https://%s/_dashboards/", domain.Endpoint))
+	return nil
+}
+
+// withOpenSearchHost sets the endpoint of the domain of
+// fireLens.openSearchDomain as the host of the log routers' output in the
+// container definitions defs.
+func (c *stackConfig) withOpenSearchHost(defs pulumi.StringInput) pulumi.StringInput {
+	if c.FireLens == nil || c.FireLens.OpenSearchDomain == nil {
+		return defs
+	}
+	return pulumi.All(defs, c.openSearchEndpoint).ApplyT(func(args []interface{}) (string, error) {
+		defs, endpoint := args[0].(string), args[1].(string)
+		var containers []map[string]interface{}
+		if err := json.Unmarshal([]byte(defs), &containers); err != nil {
+			return "", err
+		}
+		for _, container := range containers {
+			logging, _ := container["logConfiguration"].(map[string]interface{})
+			if logging == nil || logging["logDriver"] != "awsfirelens" {
+				continue
+			}
+			options, _ := logging["options"].(map[string]interface{})
+			if options == nil {
+				continue
+			}
+			options["Host"] = endpoint
+		}
+		b, err := json.Marshal(containers)
+		return string(b), err
+	}).(pulumi.StringOutput)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// parameterArnPattern matches the ARNs of SSM parameters.
+var parameterArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:ssm:[a-z0-9-]+:\d{12}:parameter/.+$`)
+
+// validateParameters checks that every parameter is either created from a
+// value or refers to an existing one by ARN.
+func validateParameters(conf *stackConfig) error {
+	for name, p := range conf.Parameters {
+		if !secretNamePattern.MatchString(name) {
+			return fmt.Errorf("parameters: names must be up to 64 letters, numbers, dots, hyphens and underscores, got %q", name)
+		}
+		if (p.Arn == "") == (p.Value == "") {
+			return fmt.Errorf("parameters.%s needs either a value or the arn of an existing parameter", name)
+		}
+		if p.Arn != "" {
+			if !parameterArnPattern.MatchString(p.Arn) {
+				return fmt.Errorf("parameters.%s.arn must be the ARN of an SSM parameter, got %q", name, p.Arn)
+			}
+			if p.Description != "" || p.KmsKeyArn != "" {
+				return fmt.Errorf("parameters.%s: the description and kmsKeyArn of an existing parameter can't be set", name)
+			}
+		}
+	}
+	return nil
+}
+
+// parameterName is the name of the SecureString parameter the stack creates
+// for its parameter name.
+func parameterName(ctx *pulumi.Context, name string) string {
+	return fmt.Sprintf("/%s/%s/%s", ctx.Project(), ctx.Stack(), name)
+}
+
+// createParameters creates the SecureString parameters given a value, and
+// lets the task execution role read them and the existing ones.
+func createParameters(ctx *pulumi.Context, ecsRole *iam.Role, conf *stackConfig) error {
+	var names []string
+	for name := range conf.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	conf.parameterArns = map[string]pulumi.StringOutput{}
+	var arns []interface{}
+	var kmsKeys []interface{}
+	for _, name := range names {
+		p := conf.Parameters[name]
+		if p.Arn != "" {
+			conf.parameterArns[name] = pulumi.String(p.Arn).ToStringOutput()
+			arns = append(arns, conf.parameterArns[name])
+			continue
+		}
+
+		// Without a key of their own, the parameters are encrypted with the
+		// stack's key, or else the aws/ssm key anyone of the account may
+		// decrypt them with.
+		args := &ssm.ParameterArgs{
+			Name:        pulumi.String(parameterName(ctx, name)),
+			Description: pulumi.String(p.Description),
+			Type:        pulumi.String("SecureString"),
+			Value:       pulumi.ToSecret(pulumi.String(p.Value)).(pulumi.StringOutput),
+			Tags:        conf.resourceTags(),
+		}
+		if p.KmsKeyArn != "" {
+			args.KeyId = pulumi.String(p.KmsKeyArn)
+			kmsKeys = append(kmsKeys, pulumi.String(p.KmsKeyArn))
+		} else if conf.KMS != nil {
+			args.KeyId = conf.kmsKeyArn
+			kmsKeys = append(kmsKeys, conf.kmsKeyArn)
+		}
+		param, err := ssm.NewParameter(ctx, "parameter-"+name, args)
+		if err != nil {
+			return err
+		}
+		conf.parameterArns[name] = param.Arn
+		arns = append(arns, param.Arn)
+	}
+	if len(arns) == 0 {
+		return nil
+	}
+
+	policy := pulumi.All(append(arns, kmsKeys...)...).ApplyT(func(args []interface{}) (string, error) {
+		var resources, keys []string
+		for _, arn := range args[:len(arns)] {
+			resources = append(resources, arn.(string))
+		}
+		seen := map[string]bool{}
+		for _, arn := range args[len(arns):] {
+			if !seen[arn.(string)] {
+				seen[arn.(string)] = true
+				keys = append(keys, arn.(string))
+			}
+		}
+		statements := []PolicyStatement{Allow([]string{"ssm:GetParameters"}, resources...)}
+		if len(keys) > 0 {
+			statements = append(statements, Allow([]string{"kms:Decrypt"}, keys...))
+		}
+		return NewPolicyDocument(statements...).JSON()
+	}).(pulumi.StringOutput)
+	_, err := iam.NewRolePolicy(ctx, "stack-parameters-policy", &iam.RolePolicyArgs{
+		Role:   ecsRole.ID(),
+		Policy: policy,
+	})
+	return err
+}
+
+// WithParameters sets environment variables of the app's containers to the
+// values of parameters of the stack's parameters, by name.
+func (a *App) WithParameters(parameters map[string]string) *App {
+	if a.parameters == nil {
+		a.parameters = map[string]string{}
+	}
+	for variable, name := range parameters {
+		a.parameters[variable] = name
+	}
+	return a
+}
+
+// validateAppParameters checks that the apps refer to parameters of the
+// stack, each variable set by a single secret or parameter.
+func validateAppParameters(apps []*App, conf *stackConfig) error {
+	for _, app := range apps {
+		for variable, name := range app.parameters {
+			if _, ok := conf.Parameters[name]; !ok {
+				return fmt.Errorf("app %s: %s refers to unknown parameter %s", app.Name, variable, name)
+			}
+			_, secret := app.secrets[variable]
+			_, stackSecret := app.stackSecrets[variable]
+			if secret || stackSecret {
+				return fmt.Errorf("app %s: %s is set by both WithParameters and WithSecrets or WithStackSecrets", app.Name, variable)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// validatePlacement checks the placement constraints and strategies of the
+// services, which only apply to the EC2 launch type.
+func validatePlacement(conf *stackConfig) error {
+	if len(conf.Placement) > 0 && conf.LaunchType != "EC2" {
+		return fmt.Errorf("placement requires launchType EC2")
+	}
+	for service, p := range conf.Placement {
+		if len(p.Constraints) > 10 {
+			return fmt.Errorf("placement.%s: ECS allows at most 10 constraints, got %d", service, len(p.Constraints))
+		}
+		for _, c := range p.Constraints {
+			switch c.Type {
+			case "distinctInstance":
+				if c.Expression != "" {
+					return fmt.Errorf("placement.%s: distinctInstance constraints take no expression", service)
+				}
+			case "memberOf":
+				if c.Expression == "" {
+					return fmt.Errorf("placement.%s: memberOf constraints need an expression", service)
+				}
+			default:
+				return fmt.Errorf("placement.%s: constraints must be distinctInstance or memberOf, got %q", service, c.Type)
+			}
+		}
+		if len(p.Strategies) > 5 {
+			return fmt.Errorf("placement.%s: ECS allows at most 5 strategies, got %d", service, len(p.Strategies))
+		}
+		for _, s := range p.Strategies {
+			switch s.Type {
+			case "spread":
+				if s.Field == "" {
+					return fmt.Errorf("placement.%s: spread strategies need a field, such as attribute:ecs.availability-zone or instanceId", service)
+				}
+			case "binpack":
+				if s.Field != "cpu" && s.Field != "memory" {
+					return fmt.Errorf("placement.%s: binpack strategies pack by cpu or memory, got %q", service, s.Field)
+				}
+			case "random":
+				if s.Field != "" {
+					return fmt.Errorf("placement.%s: random strategies take no field", service)
+				}
+			default:
+				return fmt.Errorf("placement.%s: strategies must be spread, binpack or random, got %q", service, s.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// placement is the placement of the tasks of service. Canaries are placed
+// like the service they are the canary of, unless placed themselves.
+func (c *stackConfig) placement(service string) placementConfig {
+	if p, ok := c.Placement[service]; ok {
+		return p
+	}
+	if strings.HasSuffix(service, "-canary") {
+		return c.placement(strings.TrimSuffix(service, "-canary"))
+	}
+	return placementConfig{}
+}
+
+// placementConstraints are the instances the tasks of service may be placed
+// on.
+func (c *stackConfig) placementConstraints(service string) ecs.ServicePlacementConstraintArrayInput {
+	constraints := c.placement(service).Constraints
+	if len(constraints) == 0 {
+		return nil
+	}
+	var args ecs.ServicePlacementConstraintArray
+	for _, constraint := range constraints {
+		arg := ecs.ServicePlacementConstraintArgs{Type: pulumi.String(constraint.Type)}
+		if constraint.Expression != "" {
+			arg.Expression = pulumi.String(constraint.Expression)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// placementStrategies order the instances the tasks of service are placed
+// on.
+func (c *stackConfig) placementStrategies(service string) ecs.ServiceOrderedPlacementStrategyArrayInput {
+	strategies := c.placement(service).Strategies
+	if len(strategies) == 0 {
+		return nil
+	}
+	var args ecs.ServiceOrderedPlacementStrategyArray
+	for _, strategy := range strategies {
+		arg := ecs.ServiceOrderedPlacementStrategyArgs{Type: pulumi.String(strategy.Type)}
+		if strategy.Field != "" {
+			arg.Field = pulumi.String(strategy.Field)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
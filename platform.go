@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// RuntimePlatform is the CPU architecture and operating system a task runs
+// on.
+type RuntimePlatform struct {
+	// CPUArchitecture is X86_64 or ARM64, for Graviton.
+	CPUArchitecture string `json:"cpuArchitecture"`
+	// OperatingSystemFamily is LINUX or one of the WINDOWS_SERVER_ families.
+	OperatingSystemFamily string `json:"operatingSystemFamily"`
+}
+
+// validate checks the platform of key and fills in the defaults.
+func (p *RuntimePlatform) validate(key string) error {
+	switch p.CPUArchitecture {
+	case "":
+		p.CPUArchitecture = "X86_64"
+	case "X86_64", "ARM64":
+	default:
+		return fmt.Errorf("%s.cpuArchitecture must be X86_64 or ARM64, got %q", key, p.CPUArchitecture)
+	}
+	if p.OperatingSystemFamily == "" {
+		p.OperatingSystemFamily = "LINUX"
+	}
+	if p.OperatingSystemFamily != "LINUX" && !strings.HasPrefix(p.OperatingSystemFamily, "WINDOWS_SERVER_") {
+		return fmt.Errorf("%s.operatingSystemFamily must be LINUX or a WINDOWS_SERVER_ family, got %q", key, p.OperatingSystemFamily)
+	}
+	if p.OperatingSystemFamily != "LINUX" && p.CPUArchitecture == "ARM64" {
+		return fmt.Errorf("%s: ARM64 is only available with LINUX", key)
+	}
+	return nil
+}
+
+// args are the task definition arguments of the platform, or nil for the
+// default, X86_64 Linux.
+func (p *RuntimePlatform) args() ecs.TaskDefinitionRuntimePlatformPtrInput {
+	if p == nil {
+		return nil
+	}
+	return ecs.TaskDefinitionRuntimePlatformArgs{
+		CpuArchitecture:       pulumi.String(p.CPUArchitecture),
+		OperatingSystemFamily: pulumi.String(p.OperatingSystemFamily),
+	}
+}
+
+// traefikPlatform is the platform of the Traefik tasks, which their own
+// option overrides.
+func (c *stackConfig) traefikPlatform() *RuntimePlatform {
+	if c.Traefik.RuntimePlatform != nil {
+		return c.Traefik.RuntimePlatform
+	}
+	return c.RuntimePlatform
+}
+
+// validateRuntimePlatforms checks the platforms of the stack. Traefik and
+// the containers next to it only come for Linux, and the instances of the
+// EC2 launch type all have the architecture of runtimePlatform.
+func validateRuntimePlatforms(conf *stackConfig) error {
+	if p := conf.RuntimePlatform; p != nil {
+		if err := p.validate("runtimePlatform"); err != nil {
+			return err
+		}
+	}
+	if p := conf.Traefik.RuntimePlatform; p != nil {
+		if err := p.validate("traefik.runtimePlatform"); err != nil {
+			return err
+		}
+	}
+	if p := conf.traefikPlatform(); p != nil && p.OperatingSystemFamily != "LINUX" {
+		return fmt.Errorf("traefik only runs on LINUX, got %s", p.OperatingSystemFamily)
+	}
+	return conf.checkInstancePlatform("traefik.runtimePlatform", conf.traefikPlatform())
+}
+
+// checkInstancePlatform checks that the tasks of key, on platform p, can
+// run on the instances of the EC2 launch type.
+func (c *stackConfig) checkInstancePlatform(key string, p *RuntimePlatform) error {
+	if c.LaunchType != "EC2" || p == nil {
+		return nil
+	}
+	if p.OperatingSystemFamily != "LINUX" {
+		return fmt.Errorf("%s: the EC2 instances run LINUX, got %s", key, p.OperatingSystemFamily)
+	}
+	if p.CPUArchitecture != c.instanceArchitecture() {
+		return fmt.Errorf("%s: the EC2 instances are %s, got %s", key, c.instanceArchitecture(), p.CPUArchitecture)
+	}
+	return nil
+}
+
+// instanceArchitecture is the CPU architecture of the instances of the EC2
+// launch type.
+func (c *stackConfig) instanceArchitecture() string {
+	if c.RuntimePlatform != nil {
+		return c.RuntimePlatform.CPUArchitecture
+	}
+	return "X86_64"
+}
+
+// WithRuntimePlatform runs the app on cpuArchitecture and
+// operatingSystemFamily instead of runtimePlatform. Empty values default to
+// X86_64 and LINUX. The app's image must have a variant for them.
+func (a *App) WithRuntimePlatform(cpuArchitecture, operatingSystemFamily string) *App {
+	a.runtimePlatform = &RuntimePlatform{CPUArchitecture: cpuArchitecture, OperatingSystemFamily: operatingSystemFamily}
+	return a
+}
+
+// platform is the platform of the app's tasks.
+func (a *App) platform(conf *stackConfig) *RuntimePlatform {
+	if a.runtimePlatform != nil {
+		return a.runtimePlatform
+	}
+	return conf.RuntimePlatform
+}
+
+// validateAppPlatforms checks the platforms the apps run on.
+func validateAppPlatforms(apps []*App, conf *stackConfig) error {
+	for _, app := range apps {
+		p := app.runtimePlatform
+		if p == nil {
+			continue
+		}
+		key := "app " + app.Name
+		if err := p.validate(key); err != nil {
+			return err
+		}
+		if err := conf.checkInstancePlatform(key, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
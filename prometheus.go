@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/amp"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"gopkg.in/yaml.v2"
+)
+
+// ampWorkspacePattern matches the IDs of Amazon Managed Service for
+// Prometheus workspaces.
+var ampWorkspacePattern = regexp.MustCompile(`^ws-[0-9a-f-]+$`)
+
+// validateManagedPrometheus checks the workspace and the scrape interval,
+// and turns on the Prometheus metrics of Traefik the collector scrapes.
+func validateManagedPrometheus(conf *stackConfig) error {
+	p := conf.ManagedPrometheus
+	if p.WorkspaceID != "" && !ampWorkspacePattern.MatchString(p.WorkspaceID) {
+		return fmt.Errorf("managedPrometheus.workspaceId must be the ID of a workspace, such as ws-12345678-abcd-1234-abcd-123456789012, got %q", p.WorkspaceID)
+	}
+	if p.ScrapeInterval == "" {
+		p.ScrapeInterval = "30s"
+	}
+	if d, err := time.ParseDuration(p.ScrapeInterval); err != nil || d < time.Second {
+		return fmt.Errorf("managedPrometheus.scrapeInterval must be a duration of at least 1s, got %q", p.ScrapeInterval)
+	}
+	conf.Traefik.Metrics.Prometheus = true
+	return nil
+}
+
+// createPrometheusWorkspace creates the workspace the collectors write the
+// metrics of Traefik to, unless managedPrometheus.workspaceId is an
+// existing one, and lets the Traefik tasks write to it.
+func createPrometheusWorkspace(ctx *pulumi.Context, traefikRole *iam.Role, conf *stackConfig) error {
+	p := conf.ManagedPrometheus
+	if p.WorkspaceID != "" {
+		identity, err := aws.GetCallerIdentity(ctx, conf.invokeOptions()...)
+		if err != nil {
+			return err
+		}
+		region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+		if err != nil {
+			return err
+		}
+		conf.prometheusWorkspaceArn = pulumi.Sprintf("arn:aws:aps:%s:%s:workspace/%s", region.Name, identity.AccountId, p.WorkspaceID)
+		conf.prometheusEndpoint = pulumi.Sprintf("https://aps-workspaces.%s.amazonaws.com/workspaces/%s/", region.Name, p.WorkspaceID)
+	} else {
+		workspace, err := amp.NewWorkspace(ctx, "prometheus", &amp.WorkspaceArgs{
+			Alias: pulumi.Sprintf("%s-%s", ctx.Project(), ctx.Stack()),
+		})
+		if err != nil {
+			return err
+		}
+		conf.prometheusWorkspaceArn = workspace.Arn
+		conf.prometheusEndpoint = workspace.PrometheusEndpoint
+	}
+
+	policy := conf.prometheusWorkspaceArn.ApplyT(func(arn string) (string, error) {
+		return NewPolicyDocument(Allow([]string{"aps:RemoteWrite"}, arn)).JSON()
+	}).(pulumi.StringOutput)
+	_, err := iam.NewRolePolicy(ctx, "traefik-prometheus-policy", &iam.RolePolicyArgs{
+		Role:   traefikRole.ID(),
+		Policy: policy,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.Export("prometheusEndpoint", conf.prometheusEndpoint)
+	return nil
+}
+
+// collectorConfig is the configuration of the collector sidecar with
+// managedPrometheus: it scrapes the metrics entrypoint of Traefik and
+// remote-writes the metrics to the workspace at endpoint, labelled with the
+// task they come from. It also forwards the traces of Traefik to X-Ray if
+// it has no other collector.
+func (c *stackConfig) collectorConfig(region, endpoint string) (string, error) {
+	receivers := map[string]interface{}{
+		"prometheus": map[string]interface{}{
+			"config": map[string]interface{}{
+				"global": map[string]string{"scrape_interval": c.ManagedPrometheus.ScrapeInterval},
+				"scrape_configs": []interface{}{
+					map[string]interface{}{
+						"job_name":       "traefik",
+						"static_configs": []interface{}{map[string][]string{"targets": {fmt.Sprintf("localhost:%d", c.Traefik.Metrics.Port)}}},
+					},
+				},
+			},
+		},
+	}
+	exporters := map[string]interface{}{
+		"prometheusremotewrite": map[string]interface{}{
+			"endpoint":                         endpoint + "api/v1/remote_write",
+			"auth":                             map[string]string{"authenticator": "sigv4auth"},
+			"resource_to_telemetry_conversion": map[string]bool{"enabled": true},
+		},
+	}
+	pipelines := map[string]interface{}{
+		"metrics": map[string][]string{
+			"receivers":  {"prometheus"},
+			"processors": {"resourcedetection"},
+			"exporters":  {"prometheusremotewrite"},
+		},
+	}
+	if c.tracesToXRay() {
+		receivers["otlp"] = map[string]interface{}{
+			"protocols": map[string]interface{}{"grpc": map[string]string{"endpoint": collectorEndpoint}},
+		}
+		exporters["awsxray"] = map[string]string{"region": region}
+		pipelines["traces"] = map[string][]string{
+			"receivers": {"otlp"},
+			"exporters": {"awsxray"},
+		}
+	}
+
+	b, err := yaml.Marshal(map[string]interface{}{
+		"extensions": map[string]interface{}{
+			"sigv4auth": map[string]string{"region": region, "service": "aps"},
+		},
+		"receivers": receivers,
+		"processors": map[string]interface{}{
+			"resourcedetection": map[string][]string{"detectors": {"env", "ecs"}},
+		},
+		"exporters": exporters,
+		"service": map[string]interface{}{
+			"extensions": []string{"sigv4auth"},
+			"pipelines":  pipelines,
+		},
+	})
+	return string(b), err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+var (
+	// roleArnPattern matches the ARNs of IAM roles.
+	roleArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/.+$`)
+	// sessionNamePattern matches the session names STS accepts.
+	sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+	// externalIDPattern matches the characters of the external IDs STS
+	// accepts, which are 2 to 1224 of them, more than a regexp can count.
+	externalIDPattern = regexp.MustCompile(`^[\w+=,.@:/-]+$`)
+	// sessionNameInvalid matches the characters STS doesn't accept in
+	// session names.
+	sessionNameInvalid = regexp.MustCompile(`[^\w+=,.@-]`)
+)
+
+// validateAssumeRole checks the role the stack is deployed with.
+func validateAssumeRole(conf *stackConfig) error {
+	r := conf.AssumeRole
+	if !roleArnPattern.MatchString(r.RoleArn) {
+		return fmt.Errorf("assumeRole.roleArn must be the ARN of a role, got %q", r.RoleArn)
+	}
+	if id := r.ExternalID; id != "" && (len(id) < 2 || len(id) > 1224 || !externalIDPattern.MatchString(id)) {
+		return fmt.Errorf("assumeRole.externalId must be 2 to 1224 letters, numbers or +=,.@:/- characters")
+	}
+	if r.SessionName != "" && !sessionNamePattern.MatchString(r.SessionName) {
+		return fmt.Errorf("assumeRole.sessionName must be 2 to 64 letters, numbers or +=,.@- characters, got %q", r.SessionName)
+	}
+	return nil
+}
+
+// sessionName names the sessions of the role after the stack, unless
+// assumeRole.sessionName does.
+func sessionName(ctx *pulumi.Context, r *assumeRoleConfig) string {
+	if r.SessionName != "" {
+		return r.SessionName
+	}
+	name := fmt.Sprintf("pulumi-%s-%s", ctx.Project(), ctx.Stack())
+	name = sessionNameInvalid.ReplaceAllString(name, "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// createProvider creates the AWS provider that assumes assumeRole, in the
+// region and with the profile of the aws configuration, and has every AWS
+// resource of the stack created with it.
+func createProvider(ctx *pulumi.Context, conf *stackConfig) error {
+	r := conf.AssumeRole
+	assumeRole := aws.ProviderAssumeRoleArgs{
+		RoleArn:     pulumi.String(r.RoleArn),
+		SessionName: pulumi.String(sessionName(ctx, r)),
+	}
+	if r.ExternalID != "" {
+		assumeRole.ExternalId = pulumi.String(r.ExternalID)
+	}
+	args := &aws.ProviderArgs{AssumeRole: assumeRole}
+	awsConf := config.New(ctx, "aws")
+	if region := awsConf.Get("region"); region != "" {
+		args.Region = pulumi.String(region)
+	}
+	if profile := awsConf.Get("profile"); profile != "" {
+		args.Profile = pulumi.String(profile)
+	}
+	provider, err := aws.NewProvider(ctx, "aws", args)
+	if err != nil {
+		return err
+	}
+	conf.provider = provider
+
+	return ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		if !strings.HasPrefix(args.Type, "aws:") {
+			return nil
+		}
+		return &pulumi.ResourceTransformationResult{
+			Props: args.Props,
+			Opts:  append(args.Opts, pulumi.Provider(provider)),
+		}
+	})
+}
+
+// invokeOptions have the lookups of the stack go through its provider, with
+// assumeRole.
+func (c *stackConfig) invokeOptions() []pulumi.InvokeOption {
+	if c.provider == nil {
+		return nil
+	}
+	return []pulumi.InvokeOption{pulumi.Provider(c.provider)}
+}
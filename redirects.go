@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// hostAndPath splits a redirect's host with an optional path.
+func hostAndPath(s string) (string, string) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		return s[:i], strings.TrimSuffix(s[i:], "/")
+	}
+	return s, ""
+}
+
+func (r redirectConfig) validate() error {
+	for _, s := range []string{r.From, r.To} {
+		if host, _ := hostAndPath(s); host == "" || strings.Contains(s, "://") {
+			return fmt.Errorf("redirects: %q must be a host with an optional path, without a scheme", s)
+		}
+	}
+	return nil
+}
+
+// rule matches the requests for From.
+func (r redirectConfig) rule() string {
+	host, path := hostAndPath(r.From)
+	rule := fmt.Sprintf("Host(`%s`)", host)
+	if path != "" {
+		rule += fmt.Sprintf(" && PathPrefix(`%s`)", path)
+	}
+	return rule
+}
+
+// redirectRegex replaces From in the URL with To, keeping the scheme and
+// the rest of the path.
+func (r redirectConfig) redirectRegex() *redirectRegexConfig {
+	fromHost, fromPath := hostAndPath(r.From)
+	toHost, toPath := hostAndPath(r.To)
+	return &redirectRegexConfig{
+		Regex:       "^(https?)://" + regexp.QuoteMeta(fromHost) + "(:[0-9]+)?" + regexp.QuoteMeta(fromPath) + "(.*)",
+		Replacement: "${1}://" + toHost + toPath + "${3}",
+		Permanent:   r.Permanent,
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// lambdaRuntime is the runtime of the stack's Python functions.
+const lambdaRuntime = "python3.12"
+
+// refreshHandler forces a new deployment of every service it is given, which
+// makes ECS pull the image tags again.
+const refreshHandler = `import os
+
+import boto3
+
+ecs = boto3.client("ecs")
+
+
+def handler(event, context):
+    for service in os.environ["SERVICES"].split(","):
+        ecs.update_service(cluster=os.environ["CLUSTER"], service=service, forceNewDeployment=True)
+`
+
+// createImageRefresh schedules a forced redeployment of every service in
+// services whose name is not excluded, so mutable tags such as `traefik:v2.7`
+// pick up the patch releases published under them.
+func createImageRefresh(
+	ctx *pulumi.Context,
+	cluster *ecs.Cluster,
+	services map[string]*ecs.Service,
+	conf imageRefreshConfig,
+	stack *stackConfig,
+) error {
+	excluded := map[string]bool{}
+	for _, name := range conf.Exclude {
+		excluded[name] = true
+	}
+
+	var names []string
+	var arns []interface{}
+	for name := range services {
+		if !excluded[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		arns = append(arns, services[name].ID())
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	lambdaTrust, err := assumeRolePolicy("lambda.amazonaws.com")
+	if err != nil {
+		return err
+	}
+	role, err := iam.NewRole(ctx, "image-refresh-role", &iam.RoleArgs{
+		NamePrefix:          stack.roleNamePrefix(""),
+		Path:                stack.iamPath(),
+		PermissionsBoundary: stack.permissionsBoundary(),
+		AssumeRolePolicy:    lambdaTrust,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = iam.NewRolePolicyAttachment(ctx, "image-refresh-logs", &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"),
+	})
+	if err != nil {
+		return err
+	}
+
+	policy := pulumi.All(arns...).ApplyT(func(arns []interface{}) (string, error) {
+		var services []string
+		for _, arn := range arns {
+			services = append(services, string(arn.(pulumi.ID)))
+		}
+		return NewPolicyDocument(Allow([]string{"ecs:UpdateService"}, services...)).JSON()
+	}).(pulumi.StringOutput)
+
+	_, err = iam.NewRolePolicy(ctx, "image-refresh-policy", &iam.RolePolicyArgs{
+		Role:   role.ID(),
+		Policy: policy,
+	})
+	if err != nil {
+		return err
+	}
+
+	fn, err := lambda.NewFunction(ctx, "image-refresh", &lambda.FunctionArgs{
+		Description: pulumi.String("Forces new deployments so services pick up updated image tags"),
+		Runtime:     pulumi.String(lambdaRuntime),
+		Handler:     pulumi.String("index.handler"),
+		Role:        role.Arn,
+		Timeout:     pulumi.Int(60),
+		Code: pulumi.NewAssetArchive(map[string]interface{}{
+			"index.py": pulumi.NewStringAsset(refreshHandler),
+		}),
+		Environment: lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap{
+				"CLUSTER":  cluster.Name,
+				"SERVICES": pulumi.String(strings.Join(names, ",")),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	rule, err := cloudwatch.NewEventRule(ctx, "image-refresh-schedule", &cloudwatch.EventRuleArgs{
+		Description:        pulumi.String("Nightly redeployment for services tracking mutable image tags"),
+		ScheduleExpression: pulumi.String(conf.Schedule),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = lambda.NewPermission(ctx, "image-refresh-invoke", &lambda.PermissionArgs{
+		Action:    pulumi.String("lambda:InvokeFunction"),
+		Function:  fn.Name,
+		Principal: pulumi.String("events.amazonaws.com"),
+		SourceArn: rule.Arn,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = cloudwatch.NewEventTarget(ctx, "image-refresh-target", &cloudwatch.EventTargetArgs{
+		Rule: rule.Name,
+		Arn:  fn.Arn,
+	})
+	return err
+}
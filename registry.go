@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecr"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+var registryClient = &http.Client{Timeout: 30 * time.Second}
+
+// ecrHostPattern matches the host of a private ECR registry, capturing its
+// account and region.
+var ecrHostPattern = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// registryAuth holds the ECR authorization tokens of a deployment by
+// registry host, as the images of the task definitions resolve concurrently.
+type registryAuth struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// authorization returns the Authorization header of the requests to host: a
+// token of the deploy credentials for private ECR registries, and none for
+// other registries.
+func (a *registryAuth) authorization(ctx *pulumi.Context, host string, conf *stackConfig) (string, error) {
+	m := ecrHostPattern.FindStringSubmatch(host)
+	if m == nil {
+		return "", nil
+	}
+	account, registryRegion := m[1], m[2]
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if header, ok := a.tokens[host]; ok {
+		return header, nil
+	}
+	// ECR tokens are only valid in the region they were issued in.
+	region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+	if err != nil {
+		return "", err
+	}
+	if registryRegion != region.Name {
+		return "", fmt.Errorf("the deploy credentials only authenticate to the ECR registries of %s, not to %s", region.Name, host)
+	}
+	token, err := ecr.GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenArgs{
+		RegistryId: &account,
+	}, conf.invokeOptions()...)
+	if err != nil {
+		return "", fmt.Errorf("authenticating to %s: %w", host, err)
+	}
+	a.tokens[host] = "Basic " + token.AuthorizationToken
+	return a.tokens[host], nil
+}
+
+// manifestMediaTypes are the manifest formats we accept when asking a
+// registry for an image digest. Listing the index types first makes the
+// registry report the multi-arch digest rather than a single platform's.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// splitImage breaks an image reference into registry host, repository and
+// tag, applying the same defaults as the docker CLI.
+func splitImage(image string) (host, repo, tag string) {
+	host = "registry-1.docker.io"
+	repo = image
+	if i := strings.Index(image, "/"); i > 0 {
+		first := image[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			host, repo = first, image[i+1:]
+		}
+	}
+	if host == "registry-1.docker.io" && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+
+	tag = "latest"
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	return host, repo, tag
+}
+
+// pinImage rewrites an image reference to address digest instead of a tag.
+func pinImage(image, digest string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + "@" + digest
+}
+
+// resolveImageDigest asks the image's registry which digest its tag currently
+// points at. Private ECR registries are authenticated to with the deploy
+// credentials. Other registries that require a bearer token are sent through
+// their anonymous token flow, so only their public images resolve.
+func resolveImageDigest(ctx *pulumi.Context, image string, conf *stackConfig) (string, error) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:], nil
+	}
+
+	host, repo, tag := splitImage(image)
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repo, tag)
+
+	authorization, err := conf.registries.authorization(ctx, host, conf)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", image, err)
+	}
+	resp, err := headManifest(manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && authorization == "" {
+		token, err := anonymousToken(resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", fmt.Errorf("resolving %s: %w", image, err)
+		}
+		resp, err = headManifest(manifestURL, "Bearer "+token)
+		if err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolving %s: registry returned %s", image, resp.Status)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("resolving %s: registry did not return a digest", image)
+	}
+	return digest, nil
+}
+
+func headManifest(manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// anonymousToken fetches a pull token from the realm named in a
+// `WWW-Authenticate: Bearer realm=...,service=...,scope=...` challenge.
+func anonymousToken(challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry auth challenge %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("registry auth challenge has no realm: %q", challenge)
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if v, ok := params[key]; ok {
+			query.Set(key, v)
+		}
+	}
+	resp, err := registryClient.Get(realm + "?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/appautoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// validateScheduledScaling checks the bounds and actions of the scheduled
+// scaling of every service.
+func validateScheduledScaling(conf *stackConfig) error {
+	for service, s := range conf.ScheduledScaling {
+		if s.MinCapacity < 0 || s.MaxCapacity < s.MinCapacity {
+			return fmt.Errorf("scheduledScaling.%s: maxCapacity must be at least minCapacity, got %d and %d", service, s.MaxCapacity, s.MinCapacity)
+		}
+		if len(s.Actions) == 0 {
+			return fmt.Errorf("scheduledScaling.%s needs actions", service)
+		}
+		names := map[string]bool{}
+		for _, a := range s.Actions {
+			if a.Name == "" || a.Schedule == "" {
+				return fmt.Errorf("scheduledScaling.%s: actions need a name and a schedule", service)
+			}
+			if names[a.Name] {
+				return fmt.Errorf("scheduledScaling.%s: duplicate action %s", service, a.Name)
+			}
+			names[a.Name] = true
+			if a.MinCapacity == nil && a.MaxCapacity == nil {
+				return fmt.Errorf("scheduledScaling.%s.%s needs minCapacity or maxCapacity", service, a.Name)
+			}
+			if a.MinCapacity != nil && a.MaxCapacity != nil && *a.MaxCapacity < *a.MinCapacity {
+				return fmt.Errorf("scheduledScaling.%s.%s: maxCapacity must be at least minCapacity", service, a.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// createScheduledScaling registers the services of conf.ScheduledScaling
+// with Application Auto Scaling and schedules their actions. ECS moves the
+// task count of a service into the bounds an action sets when it runs.
+func createScheduledScaling(
+	ctx *pulumi.Context,
+	cluster *ecs.Cluster,
+	services map[string]*ecs.Service,
+	conf *stackConfig,
+) error {
+	var names []string
+	for name := range conf.ScheduledScaling {
+		if services[name] == nil {
+			return fmt.Errorf("scheduledScaling: unknown service %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := conf.ScheduledScaling[name]
+		target, err := appautoscaling.NewTarget(ctx, name+"-scaling-target", &appautoscaling.TargetArgs{
+			ServiceNamespace:  pulumi.String("ecs"),
+			ScalableDimension: pulumi.String("ecs:service:DesiredCount"),
+			ResourceId:        pulumi.Sprintf("service/%s/%s", cluster.Name, services[name].Name),
+			MinCapacity:       pulumi.Int(s.MinCapacity),
+			MaxCapacity:       pulumi.Int(s.MaxCapacity),
+		})
+		if err != nil {
+			return err
+		}
+
+		var timezone pulumi.StringPtrInput
+		if s.Timezone != "" {
+			timezone = pulumi.String(s.Timezone)
+		}
+		for _, a := range s.Actions {
+			action := appautoscaling.ScheduledActionScalableTargetActionArgs{}
+			if a.MinCapacity != nil {
+				action.MinCapacity = pulumi.Int(*a.MinCapacity)
+			}
+			if a.MaxCapacity != nil {
+				action.MaxCapacity = pulumi.Int(*a.MaxCapacity)
+			}
+			_, err = appautoscaling.NewScheduledAction(ctx, name+"-"+a.Name, &appautoscaling.ScheduledActionArgs{
+				Name:                 pulumi.Sprintf("%s-%s", name, a.Name),
+				ServiceNamespace:     target.ServiceNamespace,
+				ScalableDimension:    target.ScalableDimension,
+				ResourceId:           target.ResourceId,
+				Schedule:             pulumi.String(a.Schedule),
+				Timezone:             timezone,
+				ScalableTargetAction: action,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/secretsmanager"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// secretNamePattern matches the names of the stack's secrets, which become
+// part of the names of the Secrets Manager secrets.
+var secretNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// validateSecrets checks that every secret is either created from a value
+// or refers to an existing one.
+func validateSecrets(conf *stackConfig) error {
+	for name, s := range conf.Secrets {
+		if !secretNamePattern.MatchString(name) {
+			return fmt.Errorf("secrets: names must be up to 64 letters, numbers, dots, hyphens and underscores, got %q", name)
+		}
+		if (s.Arn == "") == (s.Value == "") {
+			return fmt.Errorf("secrets.%s needs either a value or the arn of an existing secret", name)
+		}
+		if s.Arn != "" && (s.Description != "" || s.KmsKeyArn != "") {
+			return fmt.Errorf("secrets.%s: the description and kmsKeyArn of an existing secret can't be set", name)
+		}
+	}
+	return nil
+}
+
+// secretName is the name of the Secrets Manager secret the stack creates for
+// its secret name.
+func secretName(ctx *pulumi.Context, name string) string {
+	return fmt.Sprintf("%s/%s/%s", ctx.Project(), ctx.Stack(), name)
+}
+
+// createSecrets creates the secrets given a value, and lets the task
+// execution role read them and the existing ones.
+func createSecrets(ctx *pulumi.Context, ecsRole *iam.Role, conf *stackConfig) error {
+	var names []string
+	for name := range conf.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	conf.secretArns = map[string]pulumi.StringOutput{}
+	var arns []interface{}
+	var kmsKeys []interface{}
+	for _, name := range names {
+		s := conf.Secrets[name]
+		if s.Arn != "" {
+			conf.secretArns[name] = pulumi.String(s.Arn).ToStringOutput()
+			arns = append(arns, conf.secretArns[name])
+			continue
+		}
+
+		args := &secretsmanager.SecretArgs{
+			Name:        pulumi.String(secretName(ctx, name)),
+			Description: pulumi.String(s.Description),
+		}
+		if s.KmsKeyArn != "" {
+			args.KmsKeyId = pulumi.String(s.KmsKeyArn)
+			kmsKeys = append(kmsKeys, pulumi.String(s.KmsKeyArn))
+		} else if conf.KMS != nil {
+			args.KmsKeyId = conf.kmsKeyArn
+			kmsKeys = append(kmsKeys, conf.kmsKeyArn)
+		}
+		secret, err := secretsmanager.NewSecret(ctx, "secret-"+name, args)
+		if err != nil {
+			return err
+		}
+		_, err = secretsmanager.NewSecretVersion(ctx, "secret-"+name+"-version", &secretsmanager.SecretVersionArgs{
+			SecretId:     secret.ID(),
+			SecretString: pulumi.ToSecret(pulumi.String(s.Value)).(pulumi.StringOutput),
+		})
+		if err != nil {
+			return err
+		}
+		conf.secretArns[name] = secret.Arn
+		arns = append(arns, secret.Arn)
+	}
+	if len(arns) == 0 {
+		return nil
+	}
+
+	policy := pulumi.All(append(arns, kmsKeys...)...).ApplyT(func(args []interface{}) (string, error) {
+		var resources, keys []string
+		for _, arn := range args[:len(arns)] {
+			resources = append(resources, arn.(string))
+		}
+		seen := map[string]bool{}
+		for _, arn := range args[len(arns):] {
+			if !seen[arn.(string)] {
+				seen[arn.(string)] = true
+				keys = append(keys, arn.(string))
+			}
+		}
+		statements := []PolicyStatement{Allow([]string{"secretsmanager:GetSecretValue"}, resources...)}
+		if len(keys) > 0 {
+			statements = append(statements, Allow([]string{"kms:Decrypt"}, keys...))
+		}
+		return NewPolicyDocument(statements...).JSON()
+	}).(pulumi.StringOutput)
+	_, err := iam.NewRolePolicy(ctx, "stack-secrets-policy", &iam.RolePolicyArgs{
+		Role:   ecsRole.ID(),
+		Policy: policy,
+	})
+	return err
+}
+
+// WithStackSecrets sets environment variables of the app's containers to
+// secrets of the stack's secrets, by name.
+func (a *App) WithStackSecrets(secrets map[string]string) *App {
+	if a.stackSecrets == nil {
+		a.stackSecrets = map[string]string{}
+	}
+	for variable, name := range secrets {
+		a.stackSecrets[variable] = name
+	}
+	return a
+}
+
+// validateStackSecrets checks that the apps refer to secrets of the stack,
+// each variable set by a single secret.
+func validateStackSecrets(apps []*App, conf *stackConfig) error {
+	for _, app := range apps {
+		for variable, name := range app.stackSecrets {
+			if _, ok := conf.Secrets[name]; !ok {
+				return fmt.Errorf("app %s: %s refers to unknown secret %s", app.Name, variable, name)
+			}
+			if _, ok := app.secrets[variable]; ok {
+				return fmt.Errorf("app %s: %s is set by both WithSecrets and WithStackSecrets", app.Name, variable)
+			}
+		}
+	}
+	return nil
+}
+
+// stackSecretArns maps the variables the app sets from stack secrets and
+// parameters to their ARNs.
+func (a *App) stackSecretArns(conf *stackConfig) pulumi.StringMapOutput {
+	arns := pulumi.StringMap{}
+	for variable, name := range a.stackSecrets {
+		arns[variable] = conf.secretArns[name]
+	}
+	for variable, name := range a.parameters {
+		arns[variable] = conf.parameterArns[name]
+	}
+	return arns.ToStringMapOutput()
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/secretsmanager"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"gopkg.in/yaml.v2"
+)
+
+// staticConfig is Traefik's static configuration, written to traefik.yml.
+// Only the options this stack sets are modelled.
+type staticConfig struct {
+	EntryPoints           map[string]entryPointConfig           `yaml:"entryPoints"`
+	Providers             providersConfig                       `yaml:"providers"`
+	API                   apiConfig                             `yaml:"api"`
+	Ping                  pingConfig                            `yaml:"ping"`
+	Log                   logConfig                             `yaml:"log"`
+	AccessLog             *accessLogConfig                      `yaml:"accessLog,omitempty"`
+	Metrics               *metricsConfig                        `yaml:"metrics,omitempty"`
+	Tracing               *tracingConfig                        `yaml:"tracing,omitempty"`
+	CertificatesResolvers map[string]certificatesResolverConfig `yaml:"certificatesResolvers,omitempty"`
+	Experimental          *experimentalConfig                   `yaml:"experimental,omitempty"`
+	ServersTransport      *serversTransportConfig               `yaml:"serversTransport,omitempty"`
+}
+
+type entryPointConfig struct {
+	Address          string                     `yaml:"address"`
+	HTTP             *entryPointHTTPConfig      `yaml:"http,omitempty"`
+	Transport        *entryPointTransportConfig `yaml:"transport,omitempty"`
+	ForwardedHeaders *forwardedHeadersConfig    `yaml:"forwardedHeaders,omitempty"`
+}
+
+type forwardedHeadersConfig struct {
+	Insecure bool `yaml:"insecure"`
+}
+
+type entryPointTransportConfig struct {
+	RespondingTimeouts struct {
+		ReadTimeout  string `yaml:"readTimeout"`
+		WriteTimeout string `yaml:"writeTimeout"`
+		IdleTimeout  string `yaml:"idleTimeout"`
+	} `yaml:"respondingTimeouts"`
+}
+
+type serversTransportConfig struct {
+	ForwardingTimeouts struct {
+		ResponseHeaderTimeout string `yaml:"responseHeaderTimeout"`
+	} `yaml:"forwardingTimeouts"`
+}
+
+type entryPointHTTPConfig struct {
+	TLS struct {
+		CertResolver string `yaml:"certResolver,omitempty"`
+	} `yaml:"tls"`
+}
+
+type providersConfig struct {
+	ECS  ecsProviderConfig   `yaml:"ecs"`
+	File *fileProviderConfig `yaml:"file,omitempty"`
+}
+
+type fileProviderConfig struct {
+	Directory string `yaml:"directory"`
+	Watch     bool   `yaml:"watch,omitempty"`
+}
+
+type ecsProviderConfig struct {
+	Clusters             []string `yaml:"clusters,omitempty"`
+	AutoDiscoverClusters bool     `yaml:"autoDiscoverClusters,omitempty"`
+	Region               string   `yaml:"region"`
+	RefreshSeconds       int      `yaml:"refreshSeconds"`
+	ExposedByDefault     bool     `yaml:"exposedByDefault"`
+	Constraints          string   `yaml:"constraints,omitempty"`
+}
+
+type apiConfig struct {
+	Dashboard bool `yaml:"dashboard"`
+	Debug     bool `yaml:"debug"`
+}
+
+type pingConfig struct {
+	EntryPoint string `yaml:"entryPoint"`
+}
+
+type logConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type accessLogConfig struct {
+	Format  string           `yaml:"format"`
+	Filters AccessLogFilters `yaml:"filters,omitempty"`
+	Fields  AccessLogFields  `yaml:"fields,omitempty"`
+}
+
+type metricsConfig struct {
+	Prometheus struct {
+		EntryPoint           string `yaml:"entryPoint"`
+		AddEntryPointsLabels bool   `yaml:"addEntryPointsLabels"`
+		AddServicesLabels    bool   `yaml:"addServicesLabels"`
+	} `yaml:"prometheus"`
+}
+
+type tracingConfig struct {
+	ServiceName string  `yaml:"serviceName"`
+	SampleRate  float64 `yaml:"sampleRate"`
+	OTLP        struct {
+		GRPC struct {
+			Endpoint string `yaml:"endpoint"`
+			Insecure bool   `yaml:"insecure"`
+		} `yaml:"grpc"`
+	} `yaml:"otlp"`
+}
+
+type experimentalConfig struct {
+	Plugins map[string]PluginOptions `yaml:"plugins,omitempty"`
+}
+
+type certificatesResolverConfig struct {
+	ACME struct {
+		Email        string `yaml:"email"`
+		Storage      string `yaml:"storage"`
+		CAServer     string `yaml:"caServer,omitempty"`
+		DNSChallenge struct {
+			Provider string `yaml:"provider"`
+		} `yaml:"dnsChallenge"`
+	} `yaml:"acme"`
+}
+
+// staticConfigPath is where the Traefik container writes its static
+// configuration before starting Traefik, which reads it from there by default.
+const staticConfigPath = "/etc/traefik/traefik.yml"
+
+// traefikStaticConfig generates the static configuration of the Traefik
+// tasks discovering cluster that play role in obtaining certificates.
+// accessLog tells whether there is a log group the access logs are shipped to.
+func traefikStaticConfig(conf *stackConfig, cluster, region string, accessLog bool, role acmeRole) staticConfig {
+	opts := conf.Traefik
+
+	c := staticConfig{
+		EntryPoints: map[string]entryPointConfig{
+			"traefik": {Address: ":8080"},
+			// Health checks get their own entrypoint that no listener
+			// forwards to.
+			"health": {Address: fmt.Sprintf(":%d", conf.HealthPort)},
+		},
+		Providers: providersConfig{
+			ECS: ecsProviderConfig{
+				Clusters:         append([]string{cluster}, opts.Clusters...),
+				Region:           region,
+				RefreshSeconds:   opts.RefreshSeconds,
+				ExposedByDefault: opts.ExposedByDefault,
+				Constraints:      opts.constraints(),
+			},
+		},
+		API: apiConfig{
+			Dashboard: !opts.API.DisableDashboard,
+			Debug:     opts.API.Debug,
+		},
+		Ping: pingConfig{EntryPoint: "health"},
+		Log: logConfig{
+			Level:  opts.LogLevel,
+			Format: opts.LogFormat,
+		},
+	}
+
+	// Traefik ignores the clusters when it discovers them all.
+	if opts.AutoDiscoverClusters {
+		c.Providers.ECS.Clusters = nil
+		c.Providers.ECS.AutoDiscoverClusters = true
+	}
+
+	// The checks of the authentication service come from Traefik itself,
+	// which sets the X-Forwarded headers the service needs.
+	if conf.ForwardAuth != nil {
+		c.EntryPoints["auth"] = entryPointConfig{
+			Address:          fmt.Sprintf("127.0.0.1:%d", forwardAuthPort),
+			ForwardedHeaders: &forwardedHeadersConfig{Insecure: true},
+		}
+	}
+
+	// The entrypoints clients reach share the timeouts.
+	transport := conf.Timeouts.entryPointTransport()
+	c.EntryPoints["web"] = entryPointConfig{Address: ":80", Transport: transport}
+	for _, ep := range conf.EntryPoints {
+		c.EntryPoints[ep.Name] = entryPointConfig{Address: entryPointAddress(ep), Transport: transport}
+	}
+	if t := conf.Timeouts; t != nil {
+		c.ServersTransport = &serversTransportConfig{}
+		c.ServersTransport.ForwardingTimeouts.ResponseHeaderTimeout = fmt.Sprintf("%ds", t.ResponseHeader)
+	}
+
+	// The generated dynamic configuration and the files on EFS are only
+	// read on start; changes on EFS don't notify other NFS clients anyway.
+	// Only the certificates a reader's sidecar dumps change later.
+	c.Providers.File = &fileProviderConfig{Directory: dynamicConfigPath, Watch: role == acmeReader}
+
+	// Traefik downloads plugins from the catalog on start, over the
+	// unrestricted egress of its security group.
+	if len(opts.Plugins) > 0 {
+		c.Experimental = &experimentalConfig{Plugins: opts.Plugins}
+	}
+
+	// Access logs are only written when there is a log group to ship them to.
+	if accessLog {
+		c.AccessLog = &accessLogConfig{
+			Format:  "json",
+			Filters: opts.AccessLogFilters,
+			Fields:  opts.AccessLogFields,
+		}
+	}
+
+	// Metrics get an entrypoint of their own, like the health checks.
+	if m := opts.Metrics; m.Prometheus {
+		c.EntryPoints["metrics"] = entryPointConfig{Address: fmt.Sprintf(":%d", m.Port)}
+		c.Metrics = &metricsConfig{}
+		c.Metrics.Prometheus.EntryPoint = "metrics"
+		c.Metrics.Prometheus.AddEntryPointsLabels = true
+		c.Metrics.Prometheus.AddServicesLabels = true
+	}
+
+	if t := opts.Tracing; t != nil {
+		c.Tracing = &tracingConfig{ServiceName: "traefik", SampleRate: t.SampleRate}
+		c.Tracing.OTLP.GRPC.Endpoint = t.Endpoint
+		c.Tracing.OTLP.GRPC.Insecure = t.Insecure
+		if t.Endpoint == "" {
+			c.Tracing.OTLP.GRPC.Endpoint = collectorEndpoint
+			c.Tracing.OTLP.GRPC.Insecure = true
+		}
+	}
+
+	// With ACME, every router on the websecure entrypoint gets its
+	// certificate from Let's Encrypt. Readers load the issuer's
+	// certificates from files instead.
+	if conf.ACME != nil {
+		websecure := entryPointConfig{Address: ":443", HTTP: &entryPointHTTPConfig{}, Transport: transport}
+		c.EntryPoints["websecure"] = websecure
+		if role == acmeReader {
+			return c
+		}
+		websecure.HTTP.TLS.CertResolver = acmeResolverName
+
+		var resolver certificatesResolverConfig
+		resolver.ACME.Email = conf.ACME.Email
+		resolver.ACME.Storage = acmeMountPath + "/acme.json"
+		resolver.ACME.CAServer = conf.ACME.CAServer
+		resolver.ACME.DNSChallenge.Provider = "route53"
+		c.CertificatesResolvers = map[string]certificatesResolverConfig{acmeResolverName: resolver}
+	}
+
+	return c
+}
+
+// traefikConfig holds the SSM parameters the Traefik containers read their
+// configuration from.
+type traefikConfig struct {
+	static *ssm.Parameter
+	// issuerStatic is the static configuration of the ACME issuer, if it
+	// runs on its own.
+	issuerStatic *ssm.Parameter
+	dynamic      *ssm.Parameter
+	// hubToken is the ARN of the secret holding the Traefik Hub token.
+	hubToken string
+	// hash changes with any of the configurations.
+	hash pulumi.StringOutput
+	// internal is the configuration of the internal Traefik, if there is
+	// one.
+	internal *traefikConfig
+}
+
+// staticParameter is the parameter holding the static configuration of a
+// Traefik task playing role.
+func (c *traefikConfig) staticParameter(role acmeRole) *ssm.Parameter {
+	if role == acmeIssuer {
+		return c.issuerStatic
+	}
+	return c.static
+}
+
+// createTraefikConfig stores the static and the generated dynamic
+// configuration routing apps in SSM parameters, from which ECS injects them
+// into the Traefik containers, and lets the task execution role and the
+// Traefik task role read them.
+// The hash changes with any configuration, so that a change rolls out new
+// tasks.
+func createTraefikConfig(
+	ctx *pulumi.Context,
+	cluster *ecs.Cluster,
+	region string,
+	accessLog bool,
+	ecsRole *iam.Role,
+	traefikRole *iam.Role,
+	apps []*App,
+	conf *stackConfig,
+) (*traefikConfig, error) {
+	newStaticParameter := func(name, file string, conf *stackConfig, role acmeRole) (*ssm.Parameter, pulumi.StringOutput, error) {
+		value := cluster.Name.ApplyT(func(cluster string) (string, error) {
+			b, err := yaml.Marshal(traefikStaticConfig(conf, cluster, region, accessLog, role))
+			return string(b), err
+		}).(pulumi.StringOutput)
+
+		param, err := ssm.NewParameter(ctx, name, &ssm.ParameterArgs{
+			Name:        pulumi.Sprintf("/%s/%s/%s", ctx.Project(), ctx.Stack(), file),
+			Description: pulumi.String("Static configuration of the Traefik tasks"),
+			Type:        pulumi.String("String"),
+			// The configuration easily outgrows the 4 KB of a standard parameter.
+			Tier:  pulumi.String("Intelligent-Tiering"),
+			Value: value,
+		})
+		return param, value, err
+	}
+
+	var c traefikConfig
+	var staticValue pulumi.StringOutput
+	var err error
+	c.static, staticValue, err = newStaticParameter("traefik-static-config", "traefik.yml", conf, conf.servingACMERole())
+	if err != nil {
+		return nil, err
+	}
+	arns := pulumi.StringArray{c.static.Arn}
+	issuerValue := pulumi.String("").ToStringOutput()
+	if conf.sharedACME() {
+		c.issuerStatic, issuerValue, err = newStaticParameter("traefik-acme-static-config", "traefik-acme.yml", conf, acmeIssuer)
+		if err != nil {
+			return nil, err
+		}
+		arns = append(arns, c.issuerStatic.Arn)
+	}
+	var internalValue pulumi.StringOutput
+	if conf.InternalTraefik != nil {
+		c.internal = &traefikConfig{}
+		c.internal.static, internalValue, err = newStaticParameter("traefik-internal-static-config", "traefik-internal.yml", conf.internalConfig(), acmeResolver)
+		if err != nil {
+			return nil, err
+		}
+		arns = append(arns, c.internal.static.Arn)
+	}
+
+	dynamicValue, err := yaml.Marshal(traefikDynamicConfig(conf, apps))
+	if err != nil {
+		return nil, err
+	}
+
+	c.dynamic, err = ssm.NewParameter(ctx, "traefik-dynamic-config", &ssm.ParameterArgs{
+		Name:        pulumi.Sprintf("/%s/%s/dynamic.yml", ctx.Project(), ctx.Stack()),
+		Description: pulumi.String("Generated dynamic configuration of the Traefik tasks"),
+		Type:        pulumi.String("String"),
+		Tier:        pulumi.String("Intelligent-Tiering"),
+		Value:       pulumi.String(dynamicValue),
+	})
+	if err != nil {
+		return nil, err
+	}
+	arns = append(arns, c.dynamic.Arn)
+
+	// The token is kept out of the parameters, which anyone allowed to read
+	// the stack's configuration can read.
+	if h := conf.Traefik.Hub; h != nil {
+		secret, err := secretsmanager.LookupSecret(ctx, &secretsmanager.LookupSecretArgs{Name: &h.TokenSecret}, conf.invokeOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("looking up the Traefik Hub token %s: %w", h.TokenSecret, err)
+		}
+		c.hubToken = secret.Arn
+	}
+
+	readPolicy := func(actions ...string) pulumi.StringOutput {
+		return arns.ToStringArrayOutput().ApplyT(func(arns []string) (string, error) {
+			statements := []PolicyStatement{Allow(actions, arns...)}
+			if c.hubToken != "" {
+				statements = append(statements, Allow([]string{"secretsmanager:GetSecretValue"}, c.hubToken))
+			}
+			return NewPolicyDocument(statements...).JSON()
+		}).(pulumi.StringOutput)
+	}
+
+	_, err = iam.NewRolePolicy(ctx, "traefik-config-policy", &iam.RolePolicyArgs{
+		Role:   ecsRole.ID(),
+		Policy: readPolicy("ssm:GetParameters"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The tasks may read their configuration again, e.g. from a shell
+	// opened with ECS Exec, but no other parameter or secret.
+	_, err = iam.NewRolePolicy(ctx, "traefik-task-config-policy", &iam.RolePolicyArgs{
+		Role:   traefikRole.ID(),
+		Policy: readPolicy("ssm:GetParameter", "ssm:GetParameters"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.hash = pulumi.All(staticValue, issuerValue).ApplyT(func(values []interface{}) string {
+		sum := sha256.Sum256([]byte(values[0].(string) + "---\n" + values[1].(string) + "---\n" + string(dynamicValue)))
+		return hex.EncodeToString(sum[:])
+	}).(pulumi.StringOutput)
+
+	if c.internal != nil {
+		c.internal.dynamic = c.dynamic
+		c.internal.hash = internalValue.ApplyT(func(value string) string {
+			sum := sha256.Sum256([]byte(value + "---\n" + string(dynamicValue)))
+			return hex.EncodeToString(sum[:])
+		}).(pulumi.StringOutput)
+	}
+
+	return &c, nil
+}
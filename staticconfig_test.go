@@ -0,0 +1,196 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestTraefikStaticConfig(t *testing.T) {
+	const cluster = "arn:aws:ecs:eu-west-1:123456789012:cluster/main"
+	tests := []struct {
+		name      string
+		conf      func(*stackConfig)
+		role      acmeRole
+		accessLog bool
+		want      string
+	}{
+		{
+			name: "defaults",
+			conf: func(*stackConfig) {},
+			want: `entryPoints:
+  health:
+    address: :8082
+  traefik:
+    address: :8080
+  web:
+    address: :80
+providers:
+  ecs:
+    clusters:
+    - arn:aws:ecs:eu-west-1:123456789012:cluster/main
+    region: eu-west-1
+    refreshSeconds: 15
+    exposedByDefault: false
+  file:
+    directory: /etc/traefik/dynamic
+api:
+  dashboard: true
+  debug: false
+ping:
+  entryPoint: health
+log:
+  level: ERROR
+  format: common
+`,
+		},
+		{
+			name: "ACME resolver discovering every cluster",
+			conf: func(c *stackConfig) {
+				c.ACME = &acmeConfig{Email: "ops@example.com"}
+				c.Traefik.Clusters = []string{"other"}
+				c.Traefik.AutoDiscoverClusters = true
+			},
+			role: acmeResolver,
+			want: `entryPoints:
+  health:
+    address: :8082
+  traefik:
+    address: :8080
+  web:
+    address: :80
+  websecure:
+    address: :443
+    http:
+      tls:
+        certResolver: letsencrypt
+providers:
+  ecs:
+    autoDiscoverClusters: true
+    region: eu-west-1
+    refreshSeconds: 15
+    exposedByDefault: false
+  file:
+    directory: /etc/traefik/dynamic
+api:
+  dashboard: true
+  debug: false
+ping:
+  entryPoint: health
+log:
+  level: ERROR
+  format: common
+certificatesResolvers:
+  letsencrypt:
+    acme:
+      email: ops@example.com
+      storage: /acme/acme.json
+      dnsChallenge:
+        provider: route53
+`,
+		},
+		{
+			name: "ACME reader",
+			conf: func(c *stackConfig) { c.ACME = &acmeConfig{Email: "ops@example.com"} },
+			role: acmeReader,
+			want: `entryPoints:
+  health:
+    address: :8082
+  traefik:
+    address: :8080
+  web:
+    address: :80
+  websecure:
+    address: :443
+    http:
+      tls: {}
+providers:
+  ecs:
+    clusters:
+    - arn:aws:ecs:eu-west-1:123456789012:cluster/main
+    region: eu-west-1
+    refreshSeconds: 15
+    exposedByDefault: false
+  file:
+    directory: /etc/traefik/dynamic
+    watch: true
+api:
+  dashboard: true
+  debug: false
+ping:
+  entryPoint: health
+log:
+  level: ERROR
+  format: common
+`,
+		},
+		{
+			name: "entrypoints, metrics and access logs",
+			conf: func(c *stackConfig) {
+				c.EntryPoints = []EntryPointOptions{{Name: "dns", Port: 53, Protocol: "udp"}}
+				c.Traefik.Metrics = MetricsOptions{Prometheus: true, Port: 8083}
+				c.Traefik.AccessLogFilters.StatusCodes = []string{"500-599"}
+				c.Traefik.AccessLogFields.Headers.DefaultMode = "drop"
+			},
+			accessLog: true,
+			want: `entryPoints:
+  dns:
+    address: :53/udp
+  health:
+    address: :8082
+  metrics:
+    address: :8083
+  traefik:
+    address: :8080
+  web:
+    address: :80
+providers:
+  ecs:
+    clusters:
+    - arn:aws:ecs:eu-west-1:123456789012:cluster/main
+    region: eu-west-1
+    refreshSeconds: 15
+    exposedByDefault: false
+  file:
+    directory: /etc/traefik/dynamic
+api:
+  dashboard: true
+  debug: false
+ping:
+  entryPoint: health
+log:
+  level: ERROR
+  format: common
+accessLog:
+  format: json
+  filters:
+    statusCodes:
+    - 500-599
+  fields:
+    headers:
+      defaultMode: drop
+metrics:
+  prometheus:
+    entryPoint: metrics
+    addEntryPointsLabels: true
+    addServicesLabels: true
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &stackConfig{
+				HealthPort: 8082,
+				Traefik:    TraefikOptions{LogLevel: "ERROR", LogFormat: "common", RefreshSeconds: 15},
+			}
+			tt.conf(conf)
+			b, err := yaml.Marshal(traefikStaticConfig(conf, cluster, "eu-west-1", tt.accessLog, tt.role))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
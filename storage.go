@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/efs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// dynamicConfigPath is the directory the file provider reads, including its
+// subdirectories.
+const dynamicConfigPath = "/etc/traefik/dynamic"
+
+// generatedConfigVolume is mounted at dynamicConfigPath when sidecars
+// generate dynamic configuration too.
+const generatedConfigVolume = "generated-config"
+
+// traefikMount is a directory of the Traefik storage, reached through an
+// access point of its own, that is mounted into the Traefik containers.
+type traefikMount struct {
+	volume        string
+	accessPoint   string // resource name
+	rootDirectory string // on EFS
+	containerPath string
+	readOnly      bool
+}
+
+// traefikMounts lists the directories the Traefik containers mount.
+func (c *stackConfig) traefikMounts() []traefikMount {
+	var mounts []traefikMount
+	if c.ACME != nil {
+		mounts = append(mounts, traefikMount{
+			volume:        acmeVolume,
+			accessPoint:   "acme-storage",
+			rootDirectory: "/traefik",
+			containerPath: acmeMountPath,
+		})
+	}
+	// Traefik only reads the dynamic configuration; it is written by
+	// whoever manages it.
+	if c.Traefik.FileProvider {
+		mounts = append(mounts, traefikMount{
+			volume:        "dynamic-config",
+			accessPoint:   "traefik-dynamic-config",
+			rootDirectory: "/dynamic",
+			containerPath: dynamicConfigPath + "/files",
+			readOnly:      true,
+		})
+	}
+	return mounts
+}
+
+// createTraefikStorage creates the encrypted EFS file system backing mounts,
+// mountable by the Traefik tasks from every subnet, and lets traefikRole
+// mount its access points, and write through those of mounts that aren't
+// read-only. It returns the task definition volumes of mounts and the file
+// system.
+func createTraefikStorage(
+	ctx *pulumi.Context,
+	vpc *ec2.LookupVpcResult,
+	subnet *ec2.GetSubnetIdsResult,
+	traefikSg *ec2.SecurityGroup,
+	traefikRole *iam.Role,
+	mounts []traefikMount,
+) (*efs.FileSystem, ecs.TaskDefinitionVolumeArray, error) {
+	fs, err := efs.NewFileSystem(ctx, "traefik-storage", &efs.FileSystemArgs{
+		Encrypted: pulumi.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// allow NFS from Traefik
+	efsSg, err := ec2.NewSecurityGroup(ctx, "traefik-storage-sg", &ec2.SecurityGroupArgs{
+		VpcId:       pulumi.String(vpc.Id),
+		Description: pulumi.String("Allow NFS traffic from traefik"),
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:       pulumi.String("tcp"),
+				FromPort:       pulumi.Int(2049),
+				ToPort:         pulumi.Int(2049),
+				SecurityGroups: pulumi.StringArray{traefikSg.ID().ToStringOutput()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mountTargets []pulumi.Resource
+	for i, id := range subnet.Ids {
+		mt, err := efs.NewMountTarget(ctx, fmt.Sprintf("traefik-storage-%d", i), &efs.MountTargetArgs{
+			FileSystemId:   fs.ID(),
+			SubnetId:       pulumi.String(id),
+			SecurityGroups: pulumi.StringArray{efsSg.ID().ToStringOutput()},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		mountTargets = append(mountTargets, mt)
+	}
+
+	var volumes ecs.TaskDefinitionVolumeArray
+	var mountArns, writeArns pulumi.StringArray
+	for _, m := range mounts {
+		// Traefik runs as root and insists on acme.json being readable by
+		// its owner only.
+		ap, err := efs.NewAccessPoint(ctx, m.accessPoint, &efs.AccessPointArgs{
+			FileSystemId: fs.ID(),
+			PosixUser: efs.AccessPointPosixUserArgs{
+				Uid: pulumi.Int(0),
+				Gid: pulumi.Int(0),
+			},
+			RootDirectory: efs.AccessPointRootDirectoryArgs{
+				Path: pulumi.String(m.rootDirectory),
+				CreationInfo: efs.AccessPointRootDirectoryCreationInfoArgs{
+					OwnerUid:    pulumi.Int(0),
+					OwnerGid:    pulumi.Int(0),
+					Permissions: pulumi.String("700"),
+				},
+			},
+		}, pulumi.DependsOn(mountTargets))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		volumes = append(volumes, ecs.TaskDefinitionVolumeArgs{
+			Name: pulumi.String(m.volume),
+			EfsVolumeConfiguration: ecs.TaskDefinitionVolumeEfsVolumeConfigurationArgs{
+				FileSystemId:      fs.ID(),
+				TransitEncryption: pulumi.String("ENABLED"),
+				AuthorizationConfig: ecs.TaskDefinitionVolumeEfsVolumeConfigurationAuthorizationConfigArgs{
+					AccessPointId: ap.ID(),
+					Iam:           pulumi.String("ENABLED"),
+				},
+			},
+		})
+		mountArns = append(mountArns, ap.Arn)
+		if !m.readOnly {
+			writeArns = append(writeArns, ap.Arn)
+		}
+	}
+
+	// Mounting the file system without one of the access points, or
+	// writing through a read-only one, is denied.
+	policy := pulumi.All(fs.Arn, mountArns, writeArns).ApplyT(func(args []interface{}) (string, error) {
+		arn, mountArns, writeArns := args[0].(string), args[1].([]string), args[2].([]string)
+		mount := Allow([]string{"elasticfilesystem:ClientMount"}, arn)
+		mount.Condition = map[string]map[string][]string{"StringEquals": {"elasticfilesystem:AccessPointArn": mountArns}}
+		statements := []PolicyStatement{mount}
+		if len(writeArns) > 0 {
+			write := Allow([]string{"elasticfilesystem:ClientWrite"}, arn)
+			write.Condition = map[string]map[string][]string{"StringEquals": {"elasticfilesystem:AccessPointArn": writeArns}}
+			statements = append(statements, write)
+		}
+		return NewPolicyDocument(statements...).JSON()
+	}).(pulumi.StringOutput)
+
+	_, err = iam.NewRolePolicy(ctx, "traefik-storage-policy", &iam.RolePolicyArgs{
+		Role:   traefikRole.ID(),
+		Policy: policy,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fs, volumes, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	elb "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/elasticloadbalancingv2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/synthetics"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// The canary runs on the Node.js runtime of Synthetics, which loads the
+// handler from nodejs/node_modules in the code archive.
+const (
+	canaryRuntime = "syn-nodejs-puppeteer-6.2"
+	canaryScript  = `const synthetics = require("Synthetics");
+
+const endpoints = %s;
+
+// Reads the whole response, and fails the step unless it is a 200.
+const expectOK = (res) => new Promise((resolve, reject) => {
+  res.on("data", () => {});
+  res.on("end", () => res.statusCode === 200 ? resolve() : reject(new Error("status " + res.statusCode)));
+});
+
+exports.handler = async () => {
+  synthetics.getConfiguration().setConfig({ continueOnHttpStepFailure: true });
+  for (const endpoint of endpoints) {
+    const url = new URL(endpoint.url);
+    await synthetics.executeHttpStep(endpoint.name, {
+      hostname: url.hostname,
+      method: "GET",
+      path: url.pathname + url.search,
+      port: url.protocol === "https:" ? 443 : 80,
+      protocol: url.protocol,
+    }, expectOK);
+  }
+};
+`
+)
+
+var (
+	// canarySchedulePattern matches the rate and cron expressions of
+	// Synthetics schedules.
+	canarySchedulePattern = regexp.MustCompile(`^(rate|cron)\(.+\)$`)
+	// canaryNameInvalid matches the characters Synthetics doesn't accept in
+	// the names of canaries.
+	canaryNameInvalid = regexp.MustCompile(`[^0-9a-z_-]`)
+)
+
+// validateSynthetics checks the schedule, the path and the excluded apps of
+// the canary, and fills in their defaults.
+func validateSynthetics(apps []*App, conf *stackConfig) error {
+	s := conf.Synthetics
+	if s.Schedule == "" {
+		s.Schedule = "rate(5 minutes)"
+	}
+	if !canarySchedulePattern.MatchString(s.Schedule) {
+		return fmt.Errorf("synthetics.schedule must be a rate or cron expression, such as rate(5 minutes), got %q", s.Schedule)
+	}
+	if s.Path == "" {
+		s.Path = "/"
+	}
+	if !strings.HasPrefix(s.Path, "/") {
+		return fmt.Errorf("synthetics.path must start with /, got %q", s.Path)
+	}
+	names := map[string]bool{}
+	for _, app := range apps {
+		names[app.Name] = true
+	}
+	for _, name := range s.Exclude {
+		if !names[name] {
+			return fmt.Errorf("synthetics.exclude: there is no app %s", name)
+		}
+	}
+	return nil
+}
+
+// canaryEndpoint is a URL the canary checks, in the step named name.
+type canaryEndpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// canaryEndpoints are the URLs the canary checks: synthetics.path on host,
+// the host of the load balancer, and the rule of every public app but those
+// behind the stack's authentication, offboarded or excluded. The apps on
+// their own host are checked with HTTPS if the load balancer has
+// certificates.
+func (c *stackConfig) canaryEndpoints(host string, apps []*App) []canaryEndpoint {
+	endpoints := []canaryEndpoint{{Name: "url", URL: "http://" + host + c.Synthetics.Path}}
+	seen := map[string]bool{endpoints[0].URL: true}
+	excluded := map[string]bool{}
+	for _, name := range c.Synthetics.Exclude {
+		excluded[name] = true
+	}
+	for _, app := range apps {
+		if app.internal || app.stackAuth || c.offboarding(app.Name) || excluded[app.Name] {
+			continue
+		}
+		url := "http://" + host + app.pathPrefix + "/"
+		if app.host != "" {
+			scheme := "http"
+			if len(c.CertificateArns) > 0 {
+				scheme = "https"
+			}
+			url = scheme + "://" + app.host + app.pathPrefix + "/"
+		}
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		endpoints = append(endpoints, canaryEndpoint{Name: app.Name, URL: url})
+	}
+	return endpoints
+}
+
+// createCanary creates the Synthetics canary that checks the endpoints of
+// loadBalancer and apps on schedule, with the bucket of its code and
+// artifacts and its role, and the alarm of its failed runs.
+func createCanary(ctx *pulumi.Context, loadBalancer *elb.LoadBalancer, apps []*App, conf *stackConfig) error {
+	identity, err := aws.GetCallerIdentity(ctx, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+	region, err := aws.GetRegion(ctx, nil, conf.invokeOptions()...)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := s3.NewBucketV2(ctx, "synthetics", &s3.BucketV2Args{
+		// It is full of artifacts by the time the stack is destroyed.
+		ForceDestroy: pulumi.Bool(true),
+		Tags:         conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s3.NewBucketPublicAccessBlock(ctx, "synthetics", &s3.BucketPublicAccessBlockArgs{
+		Bucket:                bucket.ID(),
+		BlockPublicAcls:       pulumi.Bool(true),
+		BlockPublicPolicy:     pulumi.Bool(true),
+		IgnorePublicAcls:      pulumi.Bool(true),
+		RestrictPublicBuckets: pulumi.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s3.NewBucketLifecycleConfigurationV2(ctx, "synthetics", &s3.BucketLifecycleConfigurationV2Args{
+		Bucket: bucket.ID(),
+		Rules: s3.BucketLifecycleConfigurationV2RuleArray{
+			s3.BucketLifecycleConfigurationV2RuleArgs{
+				Id:         pulumi.String("expire-artifacts"),
+				Status:     pulumi.String("Enabled"),
+				Filter:     s3.BucketLifecycleConfigurationV2RuleFilterArgs{Prefix: pulumi.String("artifacts/")},
+				Expiration: s3.BucketLifecycleConfigurationV2RuleExpirationArgs{Days: pulumi.Int(31)},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A changed script is uploaded under a new key, which updates the canary.
+	script := loadBalancer.DnsName.ApplyT(func(host string) (string, error) {
+		b, err := json.MarshalIndent(conf.canaryEndpoints(host, apps), "", "  ")
+		return fmt.Sprintf(canaryScript, b), err
+	}).(pulumi.StringOutput)
+	code, err := s3.NewBucketObjectv2(ctx, "synthetics-code", &s3.BucketObjectv2Args{
+		Bucket: bucket.ID(),
+		Key: script.ApplyT(func(script string) string {
+			return fmt.Sprintf("code/%x.zip", sha256.Sum256([]byte(script)))
+		}).(pulumi.StringOutput),
+		Source: script.ApplyT(func(script string) pulumi.Archive {
+			return pulumi.NewAssetArchive(map[string]interface{}{
+				"nodejs/node_modules/index.js": pulumi.NewStringAsset(script),
+			})
+		}).(pulumi.ArchiveOutput),
+	})
+	if err != nil {
+		return err
+	}
+
+	lambdaTrust, err := assumeRolePolicy("lambda.amazonaws.com")
+	if err != nil {
+		return err
+	}
+	role, err := iam.NewRole(ctx, "synthetics-role", &iam.RoleArgs{
+		NamePrefix:          conf.roleNamePrefix(""),
+		Path:                conf.iamPath(),
+		PermissionsBoundary: conf.permissionsBoundary(),
+		AssumeRolePolicy:    lambdaTrust,
+		Tags:                conf.resourceTags(),
+	})
+	if err != nil {
+		return err
+	}
+	// The permissions of the role the Synthetics console creates.
+	policy := bucket.Arn.ApplyT(func(arn string) (string, error) {
+		metrics := Allow([]string{"cloudwatch:PutMetricData"}, "*")
+		metrics.Condition = map[string]map[string][]string{
+			"StringEquals": {"cloudwatch:namespace": {"CloudWatchSynthetics"}},
+		}
+		return NewPolicyDocument(
+			Allow([]string{"s3:GetObject", "s3:PutObject"}, arn+"/*"),
+			Allow([]string{"s3:GetBucketLocation"}, arn),
+			Allow([]string{"s3:ListAllMyBuckets"}, "*"),
+			Allow([]string{"logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents"},
+				fmt.Sprintf("arn:aws:logs:%s:%s:log-group:/aws/lambda/cwsyn-*", region.Name, identity.AccountId)),
+			metrics,
+		).JSON()
+	}).(pulumi.StringOutput)
+	rolePolicy, err := iam.NewRolePolicy(ctx, "synthetics-policy", &iam.RolePolicyArgs{
+		Role:   role.ID(),
+		Policy: policy,
+	})
+	if err != nil {
+		return err
+	}
+
+	name := canaryNameInvalid.ReplaceAllString(strings.ToLower(fmt.Sprintf("%s-%s", ctx.Project(), ctx.Stack())), "-")
+	if len(name) > 21 {
+		name = name[:21]
+	}
+	canary, err := synthetics.NewCanary(ctx, "synthetics", &synthetics.CanaryArgs{
+		Name:               pulumi.String(name),
+		RuntimeVersion:     pulumi.String(canaryRuntime),
+		Handler:            pulumi.String("index.handler"),
+		ExecutionRoleArn:   role.Arn,
+		S3Bucket:           bucket.ID(),
+		S3Key:              code.Key,
+		ArtifactS3Location: pulumi.Sprintf("s3://%s/artifacts/", bucket.ID()),
+		Schedule:           synthetics.CanaryScheduleArgs{Expression: pulumi.String(conf.Synthetics.Schedule)},
+		StartCanary:        pulumi.Bool(true),
+		Tags:               conf.resourceTags(),
+	}, pulumi.DependsOn([]pulumi.Resource{rolePolicy}))
+	if err != nil {
+		return err
+	}
+
+	_, err = cloudwatch.NewMetricAlarm(ctx, "synthetics-failures", &cloudwatch.MetricAlarmArgs{
+		AlarmDescription:   pulumi.String("The canary finds endpoints that don't respond with 200"),
+		Namespace:          pulumi.String("CloudWatchSynthetics"),
+		MetricName:         pulumi.String("SuccessPercent"),
+		Statistic:          pulumi.String("Minimum"),
+		Period:             pulumi.Int(300),
+		ComparisonOperator: pulumi.String("LessThanThreshold"),
+		EvaluationPeriods:  pulumi.Int(2),
+		Threshold:          pulumi.Float64(100),
+		TreatMissingData:   pulumi.String("notBreaching"),
+		AlarmActions:       conf.alarmActions(),
+		OkActions:          conf.alarmActions(),
+		Dimensions:         pulumi.StringMap{"CanaryName": canary.Name},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Export("canaryName", canary.Name)
+	return nil
+}
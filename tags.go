@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// stackTag is the default tag naming the project and the stack a resource
+// belongs to.
+const stackTag = "pulumi-stack"
+
+// untaggedTypes are the resource types with tags that don't get the default
+// tags: S3 objects have at most 10.
+var untaggedTypes = map[string]bool{
+	"aws:s3/bucketObject:BucketObject":     true,
+	"aws:s3/bucketObjectv2:BucketObjectv2": true,
+}
+
+// validateTags checks the tags against the limits of ECS, which adds a few
+// of its own to the tasks of the services.
+func validateTags(conf *stackConfig) error {
+	if len(conf.Tags) > 40 {
+		return fmt.Errorf("tags: at most 40 tags leave room for those ECS adds, got %d", len(conf.Tags))
+	}
+	if err := checkTags("tags", conf.Tags); err != nil {
+		return err
+	}
+	if len(conf.DefaultTags) == 0 {
+		return nil
+	}
+	if _, ok := conf.DefaultTags[stackTag]; ok {
+		return fmt.Errorf("defaultTags: %s is set by the stack", stackTag)
+	}
+	if err := checkTags("defaultTags", conf.DefaultTags); err != nil {
+		return err
+	}
+	keys := map[string]bool{stackTag: true}
+	for k := range conf.Tags {
+		keys[k] = true
+	}
+	for k := range conf.DefaultTags {
+		keys[k] = true
+	}
+	if len(keys) > 40 {
+		return fmt.Errorf("defaultTags: at most 40 tags and default tags together, with %s, leave room for those ECS adds, got %d", stackTag, len(keys))
+	}
+	return nil
+}
+
+// checkTags checks the keys and values of the tags of key.
+func checkTags(key string, tags map[string]string) error {
+	for k, v := range tags {
+		if k == "" || len(k) > 128 {
+			return fmt.Errorf("%s: keys must be 1 to 128 characters long, got %q", key, k)
+		}
+		if strings.HasPrefix(strings.ToLower(k), "aws:") {
+			return fmt.Errorf("%s: the aws: prefix of %s is reserved for AWS", key, k)
+		}
+		if len(v) > 256 {
+			return fmt.Errorf("%s.%s: values are at most 256 characters long", key, k)
+		}
+	}
+	return nil
+}
+
+// resourceTags are the tags of the services and the task definitions, or
+// nil without tags.
+func (c *stackConfig) resourceTags() pulumi.StringMapInput {
+	if len(c.Tags) == 0 {
+		return nil
+	}
+	return pulumi.ToStringMap(c.Tags)
+}
+
+// propagateTags has the services tag the tasks they start with their own
+// tags, next to the cluster and service names ECS tags them with.
+func (c *stackConfig) propagateTags() pulumi.StringPtrInput {
+	return pulumi.String("SERVICE")
+}
+
+// registerDefaultTags adds defaultTags, and the stackTag, to the tags of every
+// AWS resource of the stack that has a map of them. The resource's own tags
+// take precedence.
+func registerDefaultTags(ctx *pulumi.Context, conf *stackConfig) error {
+	defaults := map[string]string{stackTag: fmt.Sprintf("%s/%s", ctx.Project(), ctx.Stack())}
+	for k, v := range conf.DefaultTags {
+		defaults[k] = v
+	}
+	tagsType := reflect.TypeOf((*pulumi.StringMapInput)(nil)).Elem()
+
+	return ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		if !strings.HasPrefix(args.Type, "aws:") || untaggedTypes[args.Type] {
+			return nil
+		}
+		// Props are a pointer to the Args struct of the resource.
+		props := reflect.ValueOf(args.Props)
+		if props.Kind() != reflect.Ptr || props.IsNil() || props.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		field := props.Elem().FieldByName("Tags")
+		if !field.IsValid() || field.Type() != tagsType {
+			return nil
+		}
+		var tags pulumi.StringMapInput = pulumi.ToStringMap(defaults)
+		if !field.IsNil() {
+			tags = field.Interface().(pulumi.StringMapInput).ToStringMapOutput().ApplyT(func(own map[string]string) map[string]string {
+				merged := map[string]string{}
+				for k, v := range defaults {
+					merged[k] = v
+				}
+				for k, v := range own {
+					merged[k] = v
+				}
+				return merged
+			}).(pulumi.StringMapOutput)
+		}
+		field.Set(reflect.ValueOf(&tags).Elem())
+		return &pulumi.ResourceTransformationResult{Props: args.Props, Opts: args.Opts}
+	})
+}
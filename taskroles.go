@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// WithTaskPolicy gives the app, and its canary, a task role of its own that
+// statements allow access to AWS resources with, such as an S3 bucket or a
+// DynamoDB table:
+//
+//	NewApp("api").WithTaskPolicy(
+//		Allow([]string{"s3:GetObject", "s3:PutObject"}, "arn:aws:s3:::uploads/*"),
+//		Allow([]string{"dynamodb:GetItem", "dynamodb:PutItem"}, "arn:aws:dynamodb:eu-west-1:123456789012:table/orders"),
+//	)
+func (a *App) WithTaskPolicy(statements ...PolicyStatement) *App {
+	a.taskPolicy = append(a.taskPolicy, statements...)
+	return a
+}
+
+// validateTaskPolicies checks the task policies of apps, which are identity
+// policies and name no principal.
+func validateTaskPolicies(apps []*App) error {
+	for _, app := range apps {
+		if len(app.taskPolicy) == 0 {
+			continue
+		}
+		// Its resources would take the names of the shared role's.
+		if app.Name == "app" {
+			return fmt.Errorf("app app: an app named app can't have a task role of its own")
+		}
+		if err := NewPolicyDocument(app.taskPolicy...).validate(); err != nil {
+			return fmt.Errorf("app %s: task policy: %w", app.Name, err)
+		}
+		for i, s := range app.taskPolicy {
+			if len(s.Principal) > 0 || len(s.Resource) == 0 {
+				return fmt.Errorf("app %s: task policy: statement %d needs resources and no principal", app.Name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// createAppTaskRoles creates the task roles of the apps with a task policy,
+// which the stack adds the permissions for ECS Exec, volumes and FireLens to
+// as it does to the role the other apps share.
+func createAppTaskRoles(ctx *pulumi.Context, apps []*App, conf *stackConfig) error {
+	tasksTrust, err := assumeRolePolicy("ecs-tasks.amazonaws.com")
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if len(app.taskPolicy) == 0 {
+			continue
+		}
+		role, err := iam.NewRole(ctx, app.Name+"-task-role", &iam.RoleArgs{
+			NamePrefix:          conf.roleNamePrefix(""),
+			Path:                conf.iamPath(),
+			PermissionsBoundary: conf.permissionsBoundary(),
+			AssumeRolePolicy:    tasksTrust,
+		})
+		if err != nil {
+			return err
+		}
+		policy, err := NewPolicyDocument(app.taskPolicy...).JSON()
+		if err != nil {
+			return err
+		}
+		_, err = iam.NewRolePolicy(ctx, app.Name+"-task-policy", &iam.RolePolicyArgs{
+			Role:   role.ID(),
+			Policy: pulumi.String(policy),
+		})
+		if err != nil {
+			return err
+		}
+		app.role = role
+	}
+	return nil
+}
+
+// taskRole is the task role of service, the app's or its canary's: the
+// app's own, or else the one the tasks without one share.
+func (a *App) taskRole(service string, conf *stackConfig) pulumi.StringPtrInput {
+	if a.role != nil {
+		return a.role.Arn
+	}
+	return conf.appTaskRole(service, len(a.volumes) > 0 || a.tracing)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// validateEphemeralStorage checks the ephemeral storage sizes of the tasks.
+func validateEphemeralStorage(conf *stackConfig) error {
+	if len(conf.EphemeralStorage) > 0 && conf.LaunchType != "FARGATE" {
+		return fmt.Errorf("ephemeralStorage requires launchType FARGATE")
+	}
+	for service, size := range conf.EphemeralStorage {
+		if size < 21 || size > 200 {
+			return fmt.Errorf("ephemeralStorage.%s must be between 21 and 200 GiB, got %d", service, size)
+		}
+	}
+	return nil
+}
+
+// ephemeralStorage is the scratch space of the tasks of service, or the 20
+// GiB Fargate gives them by default.
+func (c *stackConfig) ephemeralStorage(service string) ecs.TaskDefinitionEphemeralStoragePtrInput {
+	size, ok := c.EphemeralStorage[service]
+	if !ok {
+		return nil
+	}
+	return ecs.TaskDefinitionEphemeralStorageArgs{SizeInGib: pulumi.Int(size)}
+}
+
+// defaultDesiredCount is the number of tasks of service without a
+// desiredCounts entry. Production stacks run enough of the long-running
+// services to lose a task, or a zone, and the others one of each.
+func (c *stackConfig) defaultDesiredCount(service string) int {
+	if !c.production {
+		return 1
+	}
+	if c.appServices[service] {
+		return 3
+	}
+	switch service {
+	case "traefik", "traefik-internal", forwardAuthService:
+		return 2
+	}
+	return 1
+}
+
+// validateDesiredCounts checks the task counts of the services. Traefik's
+// come from their own options, and the ACME task is a single one.
+func validateDesiredCounts(conf *stackConfig) error {
+	for service, count := range conf.DesiredCounts {
+		switch service {
+		case "traefik", "traefik-internal":
+			return fmt.Errorf("desiredCounts.%s: set the desiredCount of its own options instead", service)
+		case "traefik-acme":
+			return fmt.Errorf("desiredCounts.%s: the ACME task must be a single one", service)
+		}
+		if count < 0 {
+			return fmt.Errorf("desiredCounts.%s must not be negative, got %d", service, count)
+		}
+	}
+	return nil
+}
+
+// desiredCount is the number of tasks of service.
+func (c *stackConfig) desiredCount(service string) int {
+	if count, ok := c.DesiredCounts[service]; ok {
+		return count
+	}
+	return c.defaultDesiredCount(service)
+}
+
+// fargateMemory lists the memory sizes, in MiB, Fargate offers with each
+// CPU size, in CPU units, as the smallest, the largest and the step between
+// them.
+var fargateMemory = map[int][3]int{
+	256:   {512, 2048, 512},
+	512:   {1024, 4096, 1024},
+	1024:  {2048, 8192, 1024},
+	2048:  {4096, 16384, 1024},
+	4096:  {8192, 30720, 1024},
+	8192:  {16384, 61440, 4096},
+	16384: {32768, 122880, 8192},
+}
+
+// validateTaskSizes checks the CPU and memory of the tasks. Fargate only
+// offers some combinations.
+func validateTaskSizes(conf *stackConfig) error {
+	for service, size := range conf.TaskSizes {
+		if size.CPU <= 0 || size.Memory <= 0 {
+			return fmt.Errorf("taskSizes.%s needs cpu and memory", service)
+		}
+		if conf.LaunchType != "FARGATE" {
+			continue
+		}
+		memory, ok := fargateMemory[size.CPU]
+		if !ok {
+			return fmt.Errorf("taskSizes.%s: Fargate offers 256, 512, 1024, 2048, 4096, 8192 or 16384 CPU units, got %d", service, size.CPU)
+		}
+		if size.Memory < memory[0] || size.Memory > memory[1] || (size.Memory-memory[0])%memory[2] != 0 {
+			return fmt.Errorf("taskSizes.%s: Fargate offers %d to %d MiB in steps of %d with %d CPU units, got %d",
+				service, memory[0], memory[1], memory[2], size.CPU, size.Memory)
+		}
+	}
+	return nil
+}
+
+// taskSize is the size of the tasks of service. Canaries are sized like
+// the service they are the canary of, unless sized themselves.
+func (c *stackConfig) taskSize(service string) taskSizeConfig {
+	if size, ok := c.TaskSizes[service]; ok {
+		return size
+	}
+	if strings.HasSuffix(service, "-canary") {
+		return c.taskSize(strings.TrimSuffix(service, "-canary"))
+	}
+	return taskSizeConfig{CPU: 256, Memory: 512}
+}
+
+// taskCPU is the CPU units of the tasks of service.
+func (c *stackConfig) taskCPU(service string) pulumi.StringPtrInput {
+	return pulumi.Sprintf("%d", c.taskSize(service).CPU)
+}
+
+// taskMemory is the memory, in MiB, of the tasks of service.
+func (c *stackConfig) taskMemory(service string) pulumi.StringPtrInput {
+	return pulumi.Sprintf("%d", c.taskSize(service).Memory)
+}
+
+// checkTaskServices checks that the services the task options of conf are
+// given for exist.
+func checkTaskServices(services map[string]*ecs.Service, conf *stackConfig) error {
+	for name := range conf.EphemeralStorage {
+		if services[name] == nil {
+			return fmt.Errorf("ephemeralStorage: unknown service %s", name)
+		}
+	}
+	for name := range conf.TaskSizes {
+		if services[name] == nil {
+			return fmt.Errorf("taskSizes: unknown service %s", name)
+		}
+	}
+	for name := range conf.DesiredCounts {
+		if services[name] == nil {
+			return fmt.Errorf("desiredCounts: unknown service %s", name)
+		}
+	}
+	for name := range conf.HealthCheckGracePeriods {
+		if services[name] == nil {
+			return fmt.Errorf("healthCheckGracePeriods: unknown service %s", name)
+		}
+	}
+	for name := range conf.Deployment.Services {
+		if services[name] == nil {
+			return fmt.Errorf("deployment.services: unknown service %s", name)
+		}
+	}
+	for name := range conf.Placement {
+		if services[name] == nil {
+			return fmt.Errorf("placement: unknown service %s", name)
+		}
+	}
+	return nil
+}
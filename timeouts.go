@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+// entryPointTransport returns the timeouts of the entrypoints behind the
+// load balancers, or nil to keep Traefik's defaults. Traefik keeps idle
+// connections open for longer than the load balancer, so that it's always
+// the load balancer closing them; otherwise a request on a connection
+// Traefik just closed fails with a 502.
+func (t *timeoutsConfig) entryPointTransport() *entryPointTransportConfig {
+	if t == nil {
+		return nil
+	}
+	idle := t.Idle + 60
+	if idle < 180 {
+		idle = 180
+	}
+	transport := &entryPointTransportConfig{}
+	transport.RespondingTimeouts.ReadTimeout = fmt.Sprintf("%ds", t.Read)
+	transport.RespondingTimeouts.WriteTimeout = fmt.Sprintf("%ds", t.Write)
+	transport.RespondingTimeouts.IdleTimeout = fmt.Sprintf("%ds", idle)
+	return transport
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// The collector sidecar receives Traefik's traces over OTLP within the task
+// and exports them to X-Ray, using the default configuration ADOT ships for
+// ECS unless it scrapes the metrics for managedPrometheus too.
+const (
+	collectorImage    = "public.ecr.aws/aws-observability/aws-otel-collector:v0.40.0"
+	collectorEndpoint = "localhost:4317"
+)
+
+// tracesToXRay reports whether Traefik sends its traces to X-Ray through
+// the collector sidecar.
+func (c *stackConfig) tracesToXRay() bool {
+	return c.Traefik.Tracing != nil && c.Traefik.Tracing.Endpoint == ""
+}
+
+// needsCollector reports whether the Traefik tasks run the collector sidecar.
+func (c *stackConfig) needsCollector() bool {
+	return c.tracesToXRay() || c.ManagedPrometheus != nil
+}
+
+// collectorContainer is the collector sidecar of the Traefik tasks in region,
+// writing the metrics to the Prometheus workspace at endpoint with
+// managedPrometheus. Traefik keeps serving without it, losing only the traces
+// and the metrics.
+func (c *stackConfig) collectorContainer(region, endpoint string) (containerDefinition, error) {
+	def := defaultCollectorContainer()
+	if c.ManagedPrometheus == nil {
+		return def, nil
+	}
+	def.Command = nil
+	config, err := c.collectorConfig(region, endpoint)
+	if err != nil {
+		return def, err
+	}
+	def.Environment = []keyValuePair{{Name: "AOT_CONFIG_CONTENT", Value: config}}
+	return def, nil
+}
+
+// createTracingPolicy lets the collector sidecars write to X-Ray.
+func createTracingPolicy(ctx *pulumi.Context, traefikRole *iam.Role) error {
+	return createXRayPolicy(ctx, "traefik-xray-policy", traefikRole)
+}
+
+// defaultCollectorContainer is the collector sidecar with the default
+// configuration, which receives traces over OTLP and from X-Ray SDKs and
+// sends them to X-Ray.
+func defaultCollectorContainer() containerDefinition {
+	return containerDefinition{
+		Name:      "otel-collector",
+		Image:     collectorImage,
+		Essential: false,
+		Command:   []string{"--config=/etc/ecs/ecs-default-config.yaml"},
+	}
+}
+
+// WithTracing runs the collector sidecar next to the app, and its canary,
+// and gives their task role the permission to write to X-Ray. The app sends
+// its traces to it over OTLP, or to the X-Ray daemon address, both of which
+// its environment points SDKs at, and they join the traces of Traefik when
+// traefik.tracing sends those to X-Ray too.
+func (a *App) WithTracing() *App {
+	a.tracing = true
+	return a
+}
+
+// usesTracing reports whether any of apps sends traces to X-Ray.
+func usesTracing(apps []*App) bool {
+	for _, app := range apps {
+		if app.tracing {
+			return true
+		}
+	}
+	return false
+}
+
+// tracingEnvironment adds the addresses of the collector sidecar to
+// environment, unless the app sets them itself.
+func (a *App) tracingEnvironment(environment []keyValuePair) []keyValuePair {
+	defaults := []keyValuePair{
+		{Name: "AWS_XRAY_DAEMON_ADDRESS", Value: "127.0.0.1:2000"},
+		{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "http://" + collectorEndpoint},
+	}
+	for _, kv := range defaults {
+		if _, ok := a.environment[kv.Name]; !ok {
+			environment = append(environment, kv)
+		}
+	}
+	sort.Slice(environment, func(i, j int) bool { return environment[i].Name < environment[j].Name })
+	return environment
+}
+
+// createAppTracingPolicies lets the collector sidecars of the apps with
+// tracing write to X-Ray, with the apps' own task roles or appRole.
+func createAppTracingPolicies(ctx *pulumi.Context, appRole *iam.Role, apps []*App) error {
+	shared := false
+	for _, app := range apps {
+		if !app.tracing {
+			continue
+		}
+		if app.role == nil {
+			shared = true
+			continue
+		}
+		if err := createXRayPolicy(ctx, app.Name+"-xray-policy", app.role); err != nil {
+			return err
+		}
+	}
+	if !shared {
+		return nil
+	}
+	return createXRayPolicy(ctx, "app-xray-policy", appRole)
+}
+
+// createXRayPolicy attaches the policy of the X-Ray daemon to role.
+func createXRayPolicy(ctx *pulumi.Context, name string, role *iam.Role) error {
+	_, err := iam.NewRolePolicyAttachment(ctx, name, &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AWSXRayDaemonWriteAccess"),
+	})
+	return err
+}
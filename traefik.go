@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// traefikVersion is the release of a Traefik image. It decides which flags
+// are generated for it and which options it supports.
+type traefikVersion struct {
+	major, minor int
+}
+
+func (v traefikVersion) String() string {
+	return fmt.Sprintf("v%d.%d", v.major, v.minor)
+}
+
+func (v traefikVersion) atLeast(major, minor int) bool {
+	return v.major > major || v.major == major && v.minor >= minor
+}
+
+// traefikVersions are the versions of every Traefik running in the stack,
+// all of which have to understand the labels of the apps.
+func (c *stackConfig) traefikVersions() []traefikVersion {
+	versions := []traefikVersion{c.Traefik.version}
+	if c.TraefikCanary != nil {
+		versions = append(versions, c.TraefikCanary.version)
+	}
+	return versions
+}
+
+// middlewareKind returns the label name of the middleware kind that every
+// Traefik of the stack understands. v2.11 renamed ipWhiteList to
+// ipAllowList, and v3 dropped the old name.
+func (c *stackConfig) middlewareKind(kind string) (string, error) {
+	if kind != "ipallowlist" {
+		return kind, nil
+	}
+
+	renamed, old := 0, 0
+	for _, v := range c.traefikVersions() {
+		if v.atLeast(2, 11) {
+			renamed++
+		}
+		if !v.atLeast(3, 0) {
+			old++
+		}
+	}
+	switch {
+	case renamed == len(c.traefikVersions()):
+		return "ipallowlist", nil
+	case old == len(c.traefikVersions()):
+		return "ipwhitelist", nil
+	}
+	return "", fmt.Errorf("no IP allow list works with both Traefik %s and %s, upgrade to v2.11 before v3",
+		c.Traefik.version, c.TraefikCanary.version)
+}
+
+// parseTraefikVersion reads the version from an explicit override, or else
+// from the tag of image, e.g. `traefik:v2.7`, `traefik:3.1.2` or
+// `traefik:v3.0-alpine`.
+func parseTraefikVersion(image, override string) (traefikVersion, error) {
+	version := override
+	if version == "" {
+		_, _, version = splitImage(image)
+	}
+
+	var v traefikVersion
+	if i := strings.IndexAny(version, "-_"); i >= 0 {
+		version = version[:i]
+	}
+	if _, err := fmt.Sscanf(strings.TrimPrefix(version, "v"), "%d.%d", &v.major, &v.minor); err != nil {
+		return v, fmt.Errorf("cannot tell the Traefik version of %s, set its version explicitly", image)
+	}
+
+	// The ECS provider was introduced in v2.2.
+	if !v.atLeast(2, 2) || v.major > 3 {
+		return v, fmt.Errorf("%s is Traefik %s, only v2.2 and later v2 and v3 releases are supported", image, v)
+	}
+	return v, nil
+}
+
+// traefikContainerDefinition generates the container definitions of a
+// Traefik task of service running image and playing role in obtaining
+// certificates. On start, the container writes its static and the generated dynamic
+// configuration from the parameters of config to files Traefik reads.
+func traefikContainerDefinition(
+	service string,
+	region string,
+	accessLogGroup *cloudwatch.LogGroup,
+	config *traefikConfig,
+	dashboardUsers pulumi.StringOutput,
+	conf *stackConfig,
+	image string,
+	role acmeRole,
+) pulumi.StringOutput {
+	accessLogGroupName := pulumi.String("").ToStringOutput()
+	if accessLogGroup != nil {
+		accessLogGroupName = accessLogGroup.Name
+	}
+	prometheusEndpoint := pulumi.String("").ToStringOutput()
+	if conf.ManagedPrometheus != nil {
+		prometheusEndpoint = conf.prometheusEndpoint
+	}
+
+	return pulumi.All(config.staticParameter(role).Arn, config.dynamic.Arn, config.hash, accessLogGroupName, dashboardUsers, prometheusEndpoint).ApplyT(func(args []interface{}) (string, error) {
+		staticArn, dynamicArn, hash := args[0].(string), args[1].(string), args[2].(string)
+		logGroup, users, prometheus := args[3].(string), args[4].(string), args[5].(string)
+
+		script := fmt.Sprintf(`mkdir -p $(dirname %[1]s) $(dirname %[2]s) && printf '%%s' "$TRAEFIK_STATIC_CONFIG" > %[1]s && printf '%%s' "$TRAEFIK_DYNAMIC_CONFIG" > %[2]s`,
+			staticConfigPath, generatedConfigFile)
+		secrets := []secret{
+			{Name: "TRAEFIK_STATIC_CONFIG", ValueFrom: staticArn},
+			{Name: "TRAEFIK_DYNAMIC_CONFIG", ValueFrom: dynamicArn},
+		}
+		// Traefik reads its static configuration from a single source, so
+		// the Hub token is added to the file rather than passed as a flag.
+		if config.hubToken != "" {
+			script += fmt.Sprintf(` && printf 'hub:\n  token: "%%s"\n' "$TRAEFIK_HUB_TOKEN" >> %s`, staticConfigPath)
+			secrets = append(secrets, secret{Name: "TRAEFIK_HUB_TOKEN", ValueFrom: config.hubToken})
+		}
+		entryPoint := []string{"sh", "-c", script + " && exec traefik --configFile=" + staticConfigPath}
+
+		ports := []EntryPointOptions{{Port: 80}, {Port: 8080}, {Port: conf.HealthPort}}
+		if m := conf.Traefik.Metrics; m.Prometheus {
+			ports = append(ports, EntryPointOptions{Port: m.Port})
+		}
+		if conf.ACME != nil {
+			ports = append(ports, EntryPointOptions{Port: 443})
+		}
+		ports = append(ports, conf.EntryPoints...)
+		var portMappings []portMapping
+		for _, p := range ports {
+			mapping := tcpPort(p.Port)
+			if p.Protocol == "udp" {
+				mapping.Protocol = "udp"
+			}
+			portMappings = append(portMappings, mapping)
+		}
+
+		// A reader's sidecar mounts acme.json instead of Traefik, and both
+		// share the directory the sidecar dumps the certificates to.
+		mounts := []mountPoint{}
+		var sidecarMounts []mountPoint
+		if role == acmeReader {
+			generated := mountPoint{SourceVolume: generatedConfigVolume, ContainerPath: dynamicConfigPath}
+			mounts = append(mounts, generated)
+			sidecarMounts = append(sidecarMounts, generated)
+		}
+		for _, m := range conf.traefikMounts() {
+			mount := mountPoint{SourceVolume: m.volume, ContainerPath: m.containerPath, ReadOnly: m.readOnly}
+			if m.volume == acmeVolume && role == acmeReader {
+				mount.ReadOnly = true
+				sidecarMounts = append(sidecarMounts, mount)
+				continue
+			}
+			mounts = append(mounts, mount)
+		}
+
+		var sidecars []containerDefinition
+		if role == acmeReader {
+			sidecars = append(sidecars, certsDumperContainer(sidecarMounts))
+		}
+		if e := conf.ErrorPages; e != nil && e.Image != "" && role != acmeIssuer {
+			sidecars = append(sidecars, errorPagesContainer(e))
+		}
+		if conf.needsCollector() {
+			collector, err := conf.collectorContainer(region, prometheus)
+			if err != nil {
+				return "", err
+			}
+			sidecars = append(sidecars, collector)
+		}
+		sidecars = conf.withContainerOptions(conf.withLogging(service, sidecars))
+
+		// The hash makes a changed configuration a new task definition.
+		environment := []keyValuePair{
+			{Name: "TRAEFIK_CONFIG_SHA256", Value: hash},
+		}
+		// Spares the Route53 provider a zone lookup.
+		if conf.ACME != nil {
+			environment = append(environment, keyValuePair{Name: "AWS_HOSTED_ZONE_ID", Value: conf.ACME.HostedZoneID})
+		}
+
+		logging := conf.logConfiguration(service, "traefik")
+		if logGroup != "" {
+			logging = &logConfiguration{
+				LogDriver: "awslogs",
+				Options: map[string]string{
+					"awslogs-group":         logGroup,
+					"awslogs-region":        region,
+					"awslogs-stream-prefix": "traefik",
+				},
+			}
+		}
+
+		// The issuer serves nothing, so it doesn't route the dashboard
+		// either.
+		labels := dashboardLabels(conf.Traefik.API, conf.dashboardRouted() && role != acmeIssuer, users)
+		for k, v := range conf.Traefik.ConstraintLabels {
+			labels[k] = v
+		}
+
+		traefik := containerDefinition{
+			Name:             "traefik",
+			Image:            image,
+			Essential:        true,
+			EntryPoint:       entryPoint,
+			DockerLabels:     labels,
+			PortMappings:     portMappings,
+			MountPoints:      mounts,
+			LogConfiguration: logging,
+			Environment:      environment,
+			Secrets:          secrets,
+		}
+		conf.traefikContainerOptions().apply(&traefik)
+		return marshalContainers(append([]containerDefinition{traefik}, sidecars...))
+	}).(pulumi.StringOutput)
+}
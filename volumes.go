@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/efs"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Volume is an EFS directory mounted into the containers of an app. By
+// default it is a directory of the stack's app storage, reached through an
+// access point of its own.
+type Volume struct {
+	// Name is the volume's name, unique within the app.
+	Name          string
+	ContainerPath string
+	ReadOnly      bool
+	// UID and GID own the directory on the app storage, and every file
+	// access through it.
+	UID int
+	GID int
+	// FileSystemID mounts an existing file system instead, or
+	// AccessPointID an access point of it. Its mount targets must let the
+	// appSecurityGroup output in.
+	FileSystemID  string
+	AccessPointID string
+}
+
+// WithVolume mounts v into the app's containers, and those of its canary.
+func (a *App) WithVolume(v Volume) *App {
+	a.volumes = append(a.volumes, v)
+	return a
+}
+
+// validateVolumes checks the volumes of apps.
+func validateVolumes(apps []*App) error {
+	for _, app := range apps {
+		names := map[string]bool{}
+		for _, v := range app.volumes {
+			if v.Name == "" || names[v.Name] {
+				return fmt.Errorf("app %s: volumes need unique names", app.Name)
+			}
+			names[v.Name] = true
+			if !strings.HasPrefix(v.ContainerPath, "/") {
+				return fmt.Errorf("app %s: the containerPath of volume %s must be absolute", app.Name, v.Name)
+			}
+			if v.AccessPointID != "" && v.FileSystemID == "" {
+				return fmt.Errorf("app %s: volume %s needs the fileSystemID of its access point", app.Name, v.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// usesVolumes reports whether any of apps mounts volumes, and whether any
+// of those is on the app storage.
+func usesVolumes(apps []*App) (volumes bool, appStorage bool) {
+	for _, app := range apps {
+		for _, v := range app.volumes {
+			volumes = true
+			appStorage = appStorage || v.FileSystemID == ""
+		}
+	}
+	return volumes, appStorage
+}
+
+// volumeName is the task definition volume of v.
+func (a *App) volumeName(v Volume) string {
+	return "volume-" + v.Name
+}
+
+// mountPoints are the mount points of the app's container definition.
+func (a *App) mountPoints() []mountPoint {
+	var mounts []mountPoint
+	for _, v := range a.volumes {
+		mounts = append(mounts, mountPoint{
+			SourceVolume:  a.volumeName(v),
+			ContainerPath: v.ContainerPath,
+			ReadOnly:      v.ReadOnly,
+		})
+	}
+	return mounts
+}
+
+// createAppStorage creates the encrypted EFS file system the volumes of the
+// apps are directories of, mountable by the app tasks from every subnet.
+func createAppStorage(
+	ctx *pulumi.Context,
+	vpc *ec2.LookupVpcResult,
+	subnet *ec2.GetSubnetIdsResult,
+	containerSg *ec2.SecurityGroup,
+) (*efs.FileSystem, []pulumi.Resource, error) {
+	fs, err := efs.NewFileSystem(ctx, "app-storage", &efs.FileSystemArgs{
+		Encrypted: pulumi.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// allow NFS from the apps
+	efsSg, err := ec2.NewSecurityGroup(ctx, "app-storage-sg", &ec2.SecurityGroupArgs{
+		VpcId:       pulumi.String(vpc.Id),
+		Description: pulumi.String("Allow NFS traffic from the apps"),
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:       pulumi.String("tcp"),
+				FromPort:       pulumi.Int(2049),
+				ToPort:         pulumi.Int(2049),
+				SecurityGroups: pulumi.StringArray{containerSg.ID().ToStringOutput()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mountTargets []pulumi.Resource
+	for i, id := range subnet.Ids {
+		mt, err := efs.NewMountTarget(ctx, fmt.Sprintf("app-storage-%d", i), &efs.MountTargetArgs{
+			FileSystemId:   fs.ID(),
+			SubnetId:       pulumi.String(id),
+			SecurityGroups: pulumi.StringArray{efsSg.ID().ToStringOutput()},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		mountTargets = append(mountTargets, mt)
+	}
+	return fs, mountTargets, nil
+}
+
+// createAppVolumes creates the access points of the app's volumes on
+// storage, the app storage, and returns the task definition volumes of the
+// app. storage's mount targets must exist before the access points.
+func createAppVolumes(
+	ctx *pulumi.Context,
+	app *App,
+	storage *efs.FileSystem,
+	mountTargets []pulumi.Resource,
+) (ecs.TaskDefinitionVolumeArray, error) {
+	var volumes ecs.TaskDefinitionVolumeArray
+	for _, v := range app.volumes {
+		fileSystemID := pulumi.String(v.FileSystemID).ToStringOutput()
+		var accessPointID pulumi.StringPtrInput
+		if v.AccessPointID != "" {
+			accessPointID = pulumi.String(v.AccessPointID)
+		}
+		if v.FileSystemID == "" {
+			// Every access through the access point is by the volume's
+			// owner, whatever user the app runs as.
+			ap, err := efs.NewAccessPoint(ctx, app.Name+"-"+v.Name+"-volume", &efs.AccessPointArgs{
+				FileSystemId: storage.ID(),
+				PosixUser: efs.AccessPointPosixUserArgs{
+					Uid: pulumi.Int(v.UID),
+					Gid: pulumi.Int(v.GID),
+				},
+				RootDirectory: efs.AccessPointRootDirectoryArgs{
+					Path: pulumi.Sprintf("/%s/%s", app.Name, v.Name),
+					CreationInfo: efs.AccessPointRootDirectoryCreationInfoArgs{
+						OwnerUid:    pulumi.Int(v.UID),
+						OwnerGid:    pulumi.Int(v.GID),
+						Permissions: pulumi.String("700"),
+					},
+				},
+			}, pulumi.DependsOn(mountTargets))
+			if err != nil {
+				return nil, err
+			}
+			fileSystemID = storage.ID().ToStringOutput()
+			accessPointID = ap.ID().ToStringOutput()
+		}
+
+		volumes = append(volumes, ecs.TaskDefinitionVolumeArgs{
+			Name: pulumi.String(app.volumeName(v)),
+			EfsVolumeConfiguration: ecs.TaskDefinitionVolumeEfsVolumeConfigurationArgs{
+				FileSystemId:      fileSystemID,
+				TransitEncryption: pulumi.String("ENABLED"),
+				AuthorizationConfig: ecs.TaskDefinitionVolumeEfsVolumeConfigurationAuthorizationConfigArgs{
+					AccessPointId: accessPointID,
+					Iam:           pulumi.String("ENABLED"),
+				},
+			},
+		})
+	}
+	return volumes, nil
+}
+
+// createAppStoragePolicies lets the apps with a task role of their own mount
+// their volumes with it, and those without with appRole.
+func createAppStoragePolicies(ctx *pulumi.Context, appRole *iam.Role, storage *efs.FileSystem, apps []*App) error {
+	var shared []*App
+	for _, app := range apps {
+		if len(app.volumes) == 0 {
+			continue
+		}
+		if app.role == nil {
+			shared = append(shared, app)
+			continue
+		}
+		err := createAppStoragePolicy(ctx, app.Name+"-storage-policy", app.role, storage, []*App{app})
+		if err != nil {
+			return err
+		}
+	}
+	if len(shared) == 0 {
+		return nil
+	}
+	return createAppStoragePolicy(ctx, "app-storage-policy", appRole, storage, shared)
+}
+
+// createAppStoragePolicy lets the tasks of role mount the file systems of the
+// volumes of apps, storage being the app storage if any of them uses it, and
+// write to those of the volumes that aren't read-only.
+func createAppStoragePolicy(ctx *pulumi.Context, name string, role *iam.Role, storage *efs.FileSystem, apps []*App) error {
+	mounts := map[string]bool{}
+	writes := map[string]bool{}
+	for _, app := range apps {
+		for _, v := range app.volumes {
+			mounts[v.FileSystemID] = true
+			writes[v.FileSystemID] = writes[v.FileSystemID] || !v.ReadOnly
+		}
+	}
+	var ids []string
+	for id := range mounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var mountArns, writeArns pulumi.StringArray
+	for _, id := range ids {
+		var arn pulumi.StringInput = pulumi.Sprintf("arn:aws:elasticfilesystem:*:*:file-system/%s", id)
+		if id == "" {
+			arn = storage.Arn
+		}
+		mountArns = append(mountArns, arn)
+		if writes[id] {
+			writeArns = append(writeArns, arn)
+		}
+	}
+
+	policy := pulumi.All(mountArns, writeArns).ApplyT(func(arns []interface{}) (string, error) {
+		statements := []PolicyStatement{Allow([]string{"elasticfilesystem:ClientMount"}, arns[0].([]string)...)}
+		if writeArns := arns[1].([]string); len(writeArns) > 0 {
+			statements = append(statements, Allow([]string{"elasticfilesystem:ClientWrite"}, writeArns...))
+		}
+		return NewPolicyDocument(statements...).JSON()
+	}).(pulumi.StringOutput)
+
+	_, err := iam.NewRolePolicy(ctx, name, &iam.RolePolicyArgs{
+		Role:   role.ID(),
+		Policy: policy,
+	})
+	return err
+}